@@ -0,0 +1,373 @@
+package odmap
+
+import (
+	"cmp"
+	"math/bits"
+	"slices"
+)
+
+// Backend is the ordered structure holding the pairs of the single-threaded map of
+// the default build, which implements its semantics, versions, CAS, history, change
+// feed and JSON, once over any backend. A backend is only used by one goroutine at a
+// time. The concurrent map of the safety_map build keeps its keys in a persistent
+// tree of its own, WithBackend is accepted there and ignored.
+type Backend[K cmp.Ordered, V any] interface {
+	Find(key K) (V, bool)
+	// Insert sets the value of key and returns the value it replaced
+	Insert(key K, value V) (V, bool)
+	Delete(key K) (V, bool)
+	Len() int
+
+	First() Cursor[K, V]
+	Last() Cursor[K, V]
+	// LowerBound returns a cursor at the first key equal or greater than key
+	LowerBound(key K) Cursor[K, V]
+	// UpperBound returns a cursor at the first key greater than key
+	UpperBound(key K) Cursor[K, V]
+}
+
+// Cursor walks the pairs of a backend in key order. Writing to the backend may
+// invalidate its cursors.
+type Cursor[K cmp.Ordered, V any] interface {
+	Valid() bool
+	Next()
+	Prev()
+	Key() K
+	Value() V
+}
+
+// sortedLoader is a backend that can be built in linear time from pairs in increasing
+// key order, it is only loaded while empty
+type sortedLoader[K cmp.Ordered, V any] interface {
+	loadSorted(keys []K, values []V)
+}
+
+// loadBackend loads the pairs in increasing key order into the empty backend b
+func loadBackend[K cmp.Ordered, V any](b Backend[K, V], keys []K, values []V) {
+	if l, ok := b.(sortedLoader[K, V]); ok {
+		l.loadSorted(keys, values)
+		return
+	}
+	for i, key := range keys {
+		b.Insert(key, values[i])
+	}
+}
+
+// redDepth returns the depth from which the nodes of a red-black tree of n nodes split
+// at their middle are red, those of the last level unless it is complete
+func redDepth(n int) int {
+	return bits.Len(uint(n+1)) - 1
+}
+
+// Versioned is a value with the version of the write that stored it, maps keep them
+// in their backend
+type Versioned[V any] struct {
+	Value   V
+	Version uint64
+}
+
+// rbBackend keeps the pairs in a red-black tree
+type rbBackend[K cmp.Ordered, V any] struct {
+	tree *RBTree[K, V]
+}
+
+// NewRBTreeBackend returns a backend keeping the pairs in an RBTree, whose nodes box
+// their value behind an atomic pointer
+func NewRBTreeBackend[K cmp.Ordered, V any](compare func(K, K) int) Backend[K, V] {
+	return rbBackend[K, V]{tree: NewRBTree[K, V](compare)}
+}
+
+func (b rbBackend[K, V]) Find(key K) (V, bool) {
+	node := b.tree.FindNode(key)
+	if node == nil {
+		return empty[V](), false
+	}
+	return node.Value(), true
+}
+
+func (b rbBackend[K, V]) Insert(key K, value V) (V, bool) {
+	node := b.tree.FindNode(key)
+	if node == nil {
+		b.tree.Insert(key, value)
+		return empty[V](), false
+	}
+	previous, p := node.Value(), value
+	node.value.Store(&p)
+	return previous, true
+}
+
+func (b rbBackend[K, V]) Delete(key K) (V, bool) {
+	node := b.tree.FindNode(key)
+	if node == nil {
+		return empty[V](), false
+	}
+	// Delete may move the successor's pair into node, read the value first
+	value := node.Value()
+	b.tree.Delete(node)
+	return value, true
+}
+
+func (b rbBackend[K, V]) Len() int {
+	return b.tree.Size()
+}
+
+func (b rbBackend[K, V]) First() Cursor[K, V] {
+	return &rbCursor[K, V]{node: b.tree.First()}
+}
+
+func (b rbBackend[K, V]) Last() Cursor[K, V] {
+	return &rbCursor[K, V]{node: b.tree.Last()}
+}
+
+func (b rbBackend[K, V]) LowerBound(key K) Cursor[K, V] {
+	return &rbCursor[K, V]{node: b.tree.FindLowerBoundNode(key)}
+}
+
+func (b rbBackend[K, V]) UpperBound(key K) Cursor[K, V] {
+	return &rbCursor[K, V]{node: b.tree.FindUpperBoundNode(key)}
+}
+
+type rbCursor[K cmp.Ordered, V any] struct {
+	node *Entry[K, V]
+}
+
+func (c *rbCursor[K, V]) Valid() bool { return c.node != nil }
+func (c *rbCursor[K, V]) Next()       { c.node = c.node.Next() }
+func (c *rbCursor[K, V]) Prev()       { c.node = c.node.Prev() }
+func (c *rbCursor[K, V]) Key() K      { return c.node.Key() }
+func (c *rbCursor[K, V]) Value() V    { return c.node.Value() }
+
+// btreeBackend keeps the pairs in a B-tree
+type btreeBackend[K cmp.Ordered, V any] struct {
+	tree *BTree[K, V]
+}
+
+// NewBTreeBackend returns a backend keeping the pairs in a B-tree of the default
+// degree, WithBTree picks another degree
+func NewBTreeBackend[K cmp.Ordered, V any](compare func(K, K) int) Backend[K, V] {
+	return newBTreeBackend[K, V](0, compare)
+}
+
+func newBTreeBackend[K cmp.Ordered, V any](degree int, compare func(K, K) int) Backend[K, V] {
+	return btreeBackend[K, V]{tree: NewBTree[K, V](degree, compare)}
+}
+
+func (b btreeBackend[K, V]) Find(key K) (V, bool)            { return b.tree.Get(key) }
+func (b btreeBackend[K, V]) Insert(key K, value V) (V, bool) { return b.tree.Put(key, value) }
+func (b btreeBackend[K, V]) Delete(key K) (V, bool)          { return b.tree.Delete(key) }
+func (b btreeBackend[K, V]) Len() int                        { return b.tree.Len() }
+
+func (b btreeBackend[K, V]) First() Cursor[K, V] {
+	return btreeCursor[K, V]{b.tree.First()}
+}
+
+func (b btreeBackend[K, V]) Last() Cursor[K, V] {
+	return btreeCursor[K, V]{b.tree.Last()}
+}
+
+func (b btreeBackend[K, V]) LowerBound(key K) Cursor[K, V] {
+	return btreeCursor[K, V]{b.tree.LowerBound(key)}
+}
+
+func (b btreeBackend[K, V]) UpperBound(key K) Cursor[K, V] {
+	return btreeCursor[K, V]{b.tree.UpperBound(key)}
+}
+
+type btreeCursor[K cmp.Ordered, V any] struct {
+	*BTreeIterator[K, V]
+}
+
+func (c btreeCursor[K, V]) Valid() bool { return c.IsValid() }
+
+// skipListBackend keeps the pairs in a skip list
+type skipListBackend[K cmp.Ordered, V any] struct {
+	list *SkipListMap[K, V]
+}
+
+// NewSkipListBackend returns a backend keeping the pairs in a skip list
+func NewSkipListBackend[K cmp.Ordered, V any](compare func(K, K) int) Backend[K, V] {
+	return skipListBackend[K, V]{list: newSkipList[K, V](compare)}
+}
+
+func (b skipListBackend[K, V]) Find(key K) (V, bool)            { return b.list.Load(key) }
+func (b skipListBackend[K, V]) Insert(key K, value V) (V, bool) { return b.list.Swap(key, value) }
+func (b skipListBackend[K, V]) Delete(key K) (V, bool)          { return b.list.LoadAndDelete(key) }
+func (b skipListBackend[K, V]) Len() int                        { return int(b.list.Len()) }
+
+func (b skipListBackend[K, V]) First() Cursor[K, V] {
+	c := &skipListCursor[K, V]{list: b.list}
+	c.node, c.value = b.list.first(b.list.head.next[0].Load().node)
+	return c
+}
+
+func (b skipListBackend[K, V]) Last() Cursor[K, V] {
+	c := &skipListCursor[K, V]{list: b.list}
+	c.node, c.value = b.list.last(func(K) bool { return true })
+	return c
+}
+
+func (b skipListBackend[K, V]) LowerBound(key K) Cursor[K, V] {
+	return b.seek(func(k K) bool { return b.list.compare(k, key) < 0 })
+}
+
+func (b skipListBackend[K, V]) UpperBound(key K) Cursor[K, V] {
+	return b.seek(func(k K) bool { return b.list.compare(k, key) <= 0 })
+}
+
+func (b skipListBackend[K, V]) seek(before func(key K) bool) Cursor[K, V] {
+	c := &skipListCursor[K, V]{list: b.list}
+	_, n := b.list.descend(before)
+	c.node, c.value = b.list.first(n)
+	return c
+}
+
+type skipListCursor[K cmp.Ordered, V any] struct {
+	list  *SkipListMap[K, V]
+	node  *slNode[K, V]
+	value *V
+}
+
+func (c *skipListCursor[K, V]) Valid() bool { return c.node != nil }
+func (c *skipListCursor[K, V]) Key() K      { return c.node.key }
+func (c *skipListCursor[K, V]) Value() V    { return *c.value }
+
+func (c *skipListCursor[K, V]) Next() {
+	c.node, c.value = c.list.first(c.node.next[0].Load().node)
+}
+
+func (c *skipListCursor[K, V]) Prev() {
+	key := c.node.key
+	c.node, c.value = c.list.last(func(k K) bool { return c.list.compare(k, key) < 0 })
+}
+
+// sortedBackend keeps the pairs in a sorted slice. It is the most compact layout and
+// the fastest to scan, but a write moves half of the pairs on average.
+type sortedBackend[K cmp.Ordered, V any] struct {
+	compare func(K, K) int
+	items   []bitem[K, V]
+	// mod counts the inserts and deletes, cursors seek again after one
+	mod uint64
+}
+
+// NewSortedBackend returns a backend keeping the pairs in a sorted slice, which suits
+// maps that are mostly read
+func NewSortedBackend[K cmp.Ordered, V any](compare func(K, K) int) Backend[K, V] {
+	return &sortedBackend[K, V]{compare: compare}
+}
+
+// search returns the index of the first pair not below key and whether it holds key
+func (b *sortedBackend[K, V]) search(key K) (int, bool) {
+	return slices.BinarySearchFunc(b.items, key, func(item bitem[K, V], key K) int {
+		return b.compare(item.key, key)
+	})
+}
+
+func (b *sortedBackend[K, V]) Find(key K) (V, bool) {
+	i, found := b.search(key)
+	if !found {
+		return empty[V](), false
+	}
+	return b.items[i].value, true
+}
+
+func (b *sortedBackend[K, V]) Insert(key K, value V) (V, bool) {
+	i, found := b.search(key)
+	if found {
+		previous := b.items[i].value
+		b.items[i].value = value
+		return previous, true
+	}
+	b.items = slices.Insert(b.items, i, bitem[K, V]{key: key, value: value})
+	b.mod++
+	return empty[V](), false
+}
+
+func (b *sortedBackend[K, V]) Delete(key K) (V, bool) {
+	i, found := b.search(key)
+	if !found {
+		return empty[V](), false
+	}
+	value := b.items[i].value
+	b.items = deleteItem(b.items, i)
+	b.mod++
+	return value, true
+}
+
+func (b *sortedBackend[K, V]) loadSorted(keys []K, values []V) {
+	b.items = make([]bitem[K, V], len(keys))
+	for i, key := range keys {
+		b.items[i] = bitem[K, V]{key: key, value: values[i]}
+	}
+	b.mod++
+}
+
+func (b *sortedBackend[K, V]) Len() int {
+	return len(b.items)
+}
+
+func (b *sortedBackend[K, V]) First() Cursor[K, V] {
+	return b.cursor(0)
+}
+
+func (b *sortedBackend[K, V]) Last() Cursor[K, V] {
+	return b.cursor(len(b.items) - 1)
+}
+
+func (b *sortedBackend[K, V]) LowerBound(key K) Cursor[K, V] {
+	i, _ := b.search(key)
+	return b.cursor(i)
+}
+
+func (b *sortedBackend[K, V]) UpperBound(key K) Cursor[K, V] {
+	i, found := b.search(key)
+	if found {
+		i++
+	}
+	return b.cursor(i)
+}
+
+func (b *sortedBackend[K, V]) cursor(i int) Cursor[K, V] {
+	c := &sortedCursor[K, V]{backend: b, i: i}
+	c.load()
+	return c
+}
+
+// sortedCursor holds a copy of the pair it is at, and seeks again from its key when
+// the slice changed since it moved
+type sortedCursor[K cmp.Ordered, V any] struct {
+	backend *sortedBackend[K, V]
+	i       int
+	mod     uint64
+	item    bitem[K, V]
+	valid   bool
+}
+
+func (c *sortedCursor[K, V]) load() {
+	c.mod = c.backend.mod
+	if c.valid = c.i >= 0 && c.i < len(c.backend.items); c.valid {
+		c.item = c.backend.items[c.i]
+	}
+}
+
+func (c *sortedCursor[K, V]) Valid() bool { return c.valid }
+func (c *sortedCursor[K, V]) Key() K      { return c.item.key }
+func (c *sortedCursor[K, V]) Value() V    { return c.item.value }
+
+func (c *sortedCursor[K, V]) Next() {
+	if c.mod != c.backend.mod {
+		var found bool
+		if c.i, found = c.backend.search(c.item.key); !found {
+			c.i--
+		}
+	}
+	c.i++
+	c.load()
+}
+
+func (c *sortedCursor[K, V]) Prev() {
+	if c.mod != c.backend.mod {
+		c.i, _ = c.backend.search(c.item.key)
+	}
+	c.i--
+	c.load()
+}
@@ -0,0 +1,418 @@
+package odmap
+
+import (
+	"cmp"
+	"math"
+)
+
+// arenaChunk is the number of nodes of an arena chunk. Chunks are never reallocated,
+// so a growing arena copies nothing.
+const arenaChunk = 1 << 14
+
+// arenaTree is a red-black tree whose nodes live in large chunks and link to each
+// other by index. It holds no pointer per node, so the garbage collector scans a few
+// chunks rather than every node, none at all when K and V hold no pointers. Deleted
+// nodes are kept on a free list and reused by the next inserts.
+type arenaTree[K cmp.Ordered, V any] struct {
+	compare func(K, K) int
+	chunks  [][]anode[K, V]
+	// used is the number of nodes ever taken from the chunks, the node 0 is nil
+	used int32
+	// free is the first node of the free list, which links through left
+	free int32
+	root int32
+	size int
+}
+
+type anode[K cmp.Ordered, V any] struct {
+	parent, left, right int32
+	color               Color
+	key                 K
+	value               V
+}
+
+// NewArenaBackend returns a backend keeping the pairs in a red-black tree allocated
+// from an arena, for very large maps
+func NewArenaBackend[K cmp.Ordered, V any](compare func(K, K) int) Backend[K, V] {
+	return &arenaTree[K, V]{compare: compare, used: 1}
+}
+
+func (t *arenaTree[K, V]) node(i int32) *anode[K, V] {
+	return &t.chunks[i/arenaChunk][i%arenaChunk]
+}
+
+func (t *arenaTree[K, V]) alloc() int32 {
+	if i := t.free; i != 0 {
+		t.free = t.node(i).left
+		return i
+	}
+	if t.used == math.MaxInt32 {
+		panic("odmap: arena is full")
+	}
+	if int(t.used) >= len(t.chunks)*arenaChunk {
+		t.chunks = append(t.chunks, make([]anode[K, V], arenaChunk))
+	}
+	t.used++
+	return t.used - 1
+}
+
+// release clears node i, so that it holds no reference, and adds it to the free list
+func (t *arenaTree[K, V]) release(i int32) {
+	*t.node(i) = anode[K, V]{left: t.free}
+	t.free = i
+}
+
+func (t *arenaTree[K, V]) isRed(i int32) bool {
+	return i != 0 && t.node(i).color == RED
+}
+
+func (t *arenaTree[K, V]) find(key K) int32 {
+	for i := t.root; i != 0; {
+		n := t.node(i)
+		switch c := t.compare(key, n.key); {
+		case c < 0:
+			i = n.left
+		case c > 0:
+			i = n.right
+		default:
+			return i
+		}
+	}
+	return 0
+}
+
+func (t *arenaTree[K, V]) Find(key K) (V, bool) {
+	i := t.find(key)
+	if i == 0 {
+		return empty[V](), false
+	}
+	return t.node(i).value, true
+}
+
+func (t *arenaTree[K, V]) Insert(key K, value V) (V, bool) {
+	parent, left := int32(0), false
+	for i := t.root; i != 0; {
+		n := t.node(i)
+		switch c := t.compare(key, n.key); {
+		case c < 0:
+			parent, left, i = i, true, n.left
+		case c > 0:
+			parent, left, i = i, false, n.right
+		default:
+			previous := n.value
+			n.value = value
+			return previous, true
+		}
+	}
+
+	z := t.alloc()
+	*t.node(z) = anode[K, V]{parent: parent, color: RED, key: key, value: value}
+	switch {
+	case parent == 0:
+		t.root = z
+	case left:
+		t.node(parent).left = z
+	default:
+		t.node(parent).right = z
+	}
+	t.size++
+	t.insertFixup(z)
+	return empty[V](), false
+}
+
+func (t *arenaTree[K, V]) insertFixup(z int32) {
+	for t.isRed(t.node(z).parent) {
+		parent := t.node(z).parent
+		grandparent := t.node(parent).parent
+		if parent == t.node(grandparent).left {
+			if uncle := t.node(grandparent).right; t.isRed(uncle) {
+				t.node(parent).color, t.node(uncle).color, t.node(grandparent).color = BLACK, BLACK, RED
+				z = grandparent
+				continue
+			}
+			if z == t.node(parent).right {
+				z = parent
+				t.rotateLeft(z)
+			}
+			parent = t.node(z).parent
+			t.node(parent).color, t.node(grandparent).color = BLACK, RED
+			t.rotateRight(grandparent)
+		} else {
+			if uncle := t.node(grandparent).left; t.isRed(uncle) {
+				t.node(parent).color, t.node(uncle).color, t.node(grandparent).color = BLACK, BLACK, RED
+				z = grandparent
+				continue
+			}
+			if z == t.node(parent).left {
+				z = parent
+				t.rotateRight(z)
+			}
+			parent = t.node(z).parent
+			t.node(parent).color, t.node(grandparent).color = BLACK, RED
+			t.rotateLeft(grandparent)
+		}
+	}
+	t.node(t.root).color = BLACK
+}
+
+func (t *arenaTree[K, V]) Delete(key K) (V, bool) {
+	z := t.find(key)
+	if z == 0 {
+		return empty[V](), false
+	}
+
+	// x takes the place of the node leaving its position
+	zn := t.node(z)
+	var x, parent int32
+	color := zn.color
+	switch {
+	case zn.left == 0:
+		x, parent = zn.right, zn.parent
+		t.transplant(z, zn.right)
+	case zn.right == 0:
+		x, parent = zn.left, zn.parent
+		t.transplant(z, zn.left)
+	default:
+		y := zn.right
+		for t.node(y).left != 0 {
+			y = t.node(y).left
+		}
+		yn := t.node(y)
+		color, x = yn.color, yn.right
+		if yn.parent == z {
+			parent = y
+		} else {
+			parent = yn.parent
+			t.transplant(y, yn.right)
+			yn.right = zn.right
+			t.node(yn.right).parent = y
+		}
+		t.transplant(z, y)
+		yn.left = zn.left
+		t.node(yn.left).parent = y
+		yn.color = zn.color
+	}
+	if color == BLACK {
+		t.deleteFixup(x, parent)
+	}
+
+	value := zn.value
+	t.release(z)
+	t.size--
+	return value, true
+}
+
+func (t *arenaTree[K, V]) loadSorted(keys []K, values []V) {
+	t.root, t.size = t.build(0, keys, values, 0, redDepth(len(keys))), len(keys)
+}
+
+// build returns a balanced tree of the pairs, red from depth red
+func (t *arenaTree[K, V]) build(parent int32, keys []K, values []V, depth, red int) int32 {
+	if len(keys) == 0 {
+		return 0
+	}
+	color, mid := Color(BLACK), len(keys)/2
+	if depth >= red {
+		color = RED
+	}
+	i := t.alloc()
+	*t.node(i) = anode[K, V]{parent: parent, color: color, key: keys[mid], value: values[mid]}
+	left := t.build(i, keys[:mid], values[:mid], depth+1, red)
+	right := t.build(i, keys[mid+1:], values[mid+1:], depth+1, red)
+	n := t.node(i)
+	n.left, n.right = left, right
+	return i
+}
+
+// transplant puts v in the place of u
+func (t *arenaTree[K, V]) transplant(u, v int32) {
+	parent := t.node(u).parent
+	switch {
+	case parent == 0:
+		t.root = v
+	case u == t.node(parent).left:
+		t.node(parent).left = v
+	default:
+		t.node(parent).right = v
+	}
+	if v != 0 {
+		t.node(v).parent = parent
+	}
+}
+
+func (t *arenaTree[K, V]) deleteFixup(x, parent int32) {
+	for x != t.root && !t.isRed(x) {
+		p := t.node(parent)
+		if x == p.left {
+			w := p.right
+			if t.isRed(w) {
+				t.node(w).color, p.color = BLACK, RED
+				t.rotateLeft(parent)
+				w = p.right
+			}
+			wn := t.node(w)
+			if !t.isRed(wn.left) && !t.isRed(wn.right) {
+				wn.color = RED
+				x, parent = parent, p.parent
+				continue
+			}
+			if !t.isRed(wn.right) {
+				t.node(wn.left).color, wn.color = BLACK, RED
+				t.rotateRight(w)
+				w = p.right
+				wn = t.node(w)
+			}
+			wn.color, p.color = p.color, BLACK
+			t.node(wn.right).color = BLACK
+			t.rotateLeft(parent)
+		} else {
+			w := p.left
+			if t.isRed(w) {
+				t.node(w).color, p.color = BLACK, RED
+				t.rotateRight(parent)
+				w = p.left
+			}
+			wn := t.node(w)
+			if !t.isRed(wn.left) && !t.isRed(wn.right) {
+				wn.color = RED
+				x, parent = parent, p.parent
+				continue
+			}
+			if !t.isRed(wn.left) {
+				t.node(wn.right).color, wn.color = BLACK, RED
+				t.rotateLeft(w)
+				w = p.left
+				wn = t.node(w)
+			}
+			wn.color, p.color = p.color, BLACK
+			t.node(wn.left).color = BLACK
+			t.rotateRight(parent)
+		}
+		x = t.root
+	}
+	if x != 0 {
+		t.node(x).color = BLACK
+	}
+}
+
+func (t *arenaTree[K, V]) rotateLeft(x int32) {
+	xn := t.node(x)
+	y := xn.right
+	yn := t.node(y)
+	xn.right = yn.left
+	if yn.left != 0 {
+		t.node(yn.left).parent = x
+	}
+	t.transplant(x, y)
+	yn.left = x
+	xn.parent = y
+}
+
+func (t *arenaTree[K, V]) rotateRight(x int32) {
+	xn := t.node(x)
+	y := xn.left
+	yn := t.node(y)
+	xn.left = yn.right
+	if yn.right != 0 {
+		t.node(yn.right).parent = x
+	}
+	t.transplant(x, y)
+	yn.right = x
+	xn.parent = y
+}
+
+func (t *arenaTree[K, V]) Len() int {
+	return t.size
+}
+
+func (t *arenaTree[K, V]) First() Cursor[K, V] {
+	i := t.root
+	for i != 0 && t.node(i).left != 0 {
+		i = t.node(i).left
+	}
+	return &arenaCursor[K, V]{tree: t, i: i}
+}
+
+func (t *arenaTree[K, V]) Last() Cursor[K, V] {
+	i := t.root
+	for i != 0 && t.node(i).right != 0 {
+		i = t.node(i).right
+	}
+	return &arenaCursor[K, V]{tree: t, i: i}
+}
+
+func (t *arenaTree[K, V]) LowerBound(key K) Cursor[K, V] {
+	var bound int32
+	for i := t.root; i != 0; {
+		if n := t.node(i); t.compare(n.key, key) >= 0 {
+			bound, i = i, n.left
+		} else {
+			i = n.right
+		}
+	}
+	return &arenaCursor[K, V]{tree: t, i: bound}
+}
+
+func (t *arenaTree[K, V]) UpperBound(key K) Cursor[K, V] {
+	var bound int32
+	for i := t.root; i != 0; {
+		if n := t.node(i); t.compare(n.key, key) > 0 {
+			bound, i = i, n.left
+		} else {
+			i = n.right
+		}
+	}
+	return &arenaCursor[K, V]{tree: t, i: bound}
+}
+
+// bound returns the first node whose key probe does not place below the searched key,
+// nor at it when strict, probe returning the comparison of a key with the searched one
+func (t *arenaTree[K, V]) bound(probe func(K) int, strict bool) int32 {
+	var bound int32
+	for i := t.root; i != 0; {
+		n := t.node(i)
+		if c := probe(n.key); c > 0 || (!strict && c == 0) {
+			bound, i = i, n.left
+		} else {
+			i = n.right
+		}
+	}
+	return bound
+}
+
+type arenaCursor[K cmp.Ordered, V any] struct {
+	tree *arenaTree[K, V]
+	i    int32
+}
+
+func (c *arenaCursor[K, V]) Valid() bool { return c.i != 0 }
+func (c *arenaCursor[K, V]) Key() K      { return c.tree.node(c.i).key }
+func (c *arenaCursor[K, V]) Value() V    { return c.tree.node(c.i).value }
+
+func (c *arenaCursor[K, V]) Next() {
+	t, i := c.tree, c.i
+	if r := t.node(i).right; r != 0 {
+		for i = r; t.node(i).left != 0; i = t.node(i).left {
+		}
+		c.i = i
+		return
+	}
+	for p := t.node(i).parent; p != 0 && i == t.node(p).right; p = t.node(i).parent {
+		i = p
+	}
+	c.i = t.node(i).parent
+}
+
+func (c *arenaCursor[K, V]) Prev() {
+	t, i := c.tree, c.i
+	if l := t.node(i).left; l != 0 {
+		for i = l; t.node(i).right != 0; i = t.node(i).right {
+		}
+		c.i = i
+		return
+	}
+	for p := t.node(i).parent; p != 0 && i == t.node(p).left; p = t.node(i).parent {
+		i = p
+	}
+	c.i = t.node(i).parent
+}
@@ -0,0 +1,335 @@
+package odmap
+
+import "cmp"
+
+// inlineTree is a red-black tree whose nodes hold their value, unlike RBTree it boxes
+// nothing and reads no atomics. A deletion relinks the nodes rather than moving pairs
+// between them, so a cursor stays valid until its own node is deleted.
+type inlineTree[K cmp.Ordered, V any] struct {
+	compare func(K, K) int
+	root    *inode[K, V]
+	size    int
+}
+
+type inode[K cmp.Ordered, V any] struct {
+	parent, left, right *inode[K, V]
+	color               Color
+	key                 K
+	value               V
+}
+
+// NewInlineBackend returns a backend keeping the pairs in a red-black tree that
+// stores the values in its nodes, the default of the single-threaded map
+func NewInlineBackend[K cmp.Ordered, V any](compare func(K, K) int) Backend[K, V] {
+	return &inlineTree[K, V]{compare: compare}
+}
+
+func (t *inlineTree[K, V]) find(key K) *inode[K, V] {
+	for n := t.root; n != nil; {
+		switch c := t.compare(key, n.key); {
+		case c < 0:
+			n = n.left
+		case c > 0:
+			n = n.right
+		default:
+			return n
+		}
+	}
+	return nil
+}
+
+func (t *inlineTree[K, V]) Find(key K) (V, bool) {
+	n := t.find(key)
+	if n == nil {
+		return empty[V](), false
+	}
+	return n.value, true
+}
+
+func (t *inlineTree[K, V]) Insert(key K, value V) (V, bool) {
+	var parent *inode[K, V]
+	link := &t.root
+	for *link != nil {
+		parent = *link
+		switch c := t.compare(key, parent.key); {
+		case c < 0:
+			link = &parent.left
+		case c > 0:
+			link = &parent.right
+		default:
+			previous := parent.value
+			parent.value = value
+			return previous, true
+		}
+	}
+
+	n := &inode[K, V]{parent: parent, color: RED, key: key, value: value}
+	*link = n
+	t.size++
+	t.insertFixup(n)
+	return empty[V](), false
+}
+
+func (t *inlineTree[K, V]) insertFixup(z *inode[K, V]) {
+	for z.parent.isRed() {
+		grandparent := z.parent.parent
+		if z.parent == grandparent.left {
+			if uncle := grandparent.right; uncle.isRed() {
+				z.parent.color, uncle.color, grandparent.color = BLACK, BLACK, RED
+				z = grandparent
+				continue
+			}
+			if z == z.parent.right {
+				z = z.parent
+				t.rotateLeft(z)
+			}
+			z.parent.color, grandparent.color = BLACK, RED
+			t.rotateRight(grandparent)
+		} else {
+			if uncle := grandparent.left; uncle.isRed() {
+				z.parent.color, uncle.color, grandparent.color = BLACK, BLACK, RED
+				z = grandparent
+				continue
+			}
+			if z == z.parent.left {
+				z = z.parent
+				t.rotateRight(z)
+			}
+			z.parent.color, grandparent.color = BLACK, RED
+			t.rotateLeft(grandparent)
+		}
+	}
+	t.root.color = BLACK
+}
+
+func (t *inlineTree[K, V]) Delete(key K) (V, bool) {
+	z := t.find(key)
+	if z == nil {
+		return empty[V](), false
+	}
+
+	// x takes the place of the node leaving its position, y
+	var x, parent *inode[K, V]
+	color := z.color
+	switch {
+	case z.left == nil:
+		x, parent = z.right, z.parent
+		t.transplant(z, z.right)
+	case z.right == nil:
+		x, parent = z.left, z.parent
+		t.transplant(z, z.left)
+	default:
+		y := z.right
+		for y.left != nil {
+			y = y.left
+		}
+		color, x = y.color, y.right
+		if y.parent == z {
+			parent = y
+		} else {
+			parent = y.parent
+			t.transplant(y, y.right)
+			y.right = z.right
+			y.right.parent = y
+		}
+		t.transplant(z, y)
+		y.left = z.left
+		y.left.parent = y
+		y.color = z.color
+	}
+	if color == BLACK {
+		t.deleteFixup(x, parent)
+	}
+	t.size--
+	return z.value, true
+}
+
+func (t *inlineTree[K, V]) loadSorted(keys []K, values []V) {
+	t.root, t.size = t.build(nil, keys, values, 0, redDepth(len(keys))), len(keys)
+}
+
+// build returns a balanced tree of the pairs, red from depth red
+func (t *inlineTree[K, V]) build(parent *inode[K, V], keys []K, values []V, depth, red int) *inode[K, V] {
+	if len(keys) == 0 {
+		return nil
+	}
+	color, mid := Color(BLACK), len(keys)/2
+	if depth >= red {
+		color = RED
+	}
+	n := &inode[K, V]{parent: parent, color: color, key: keys[mid], value: values[mid]}
+	n.left = t.build(n, keys[:mid], values[:mid], depth+1, red)
+	n.right = t.build(n, keys[mid+1:], values[mid+1:], depth+1, red)
+	return n
+}
+
+// transplant puts v in the place of u
+func (t *inlineTree[K, V]) transplant(u, v *inode[K, V]) {
+	switch {
+	case u.parent == nil:
+		t.root = v
+	case u == u.parent.left:
+		u.parent.left = v
+	default:
+		u.parent.right = v
+	}
+	if v != nil {
+		v.parent = u.parent
+	}
+}
+
+func (t *inlineTree[K, V]) deleteFixup(x, parent *inode[K, V]) {
+	for x != t.root && !x.isRed() {
+		if x == parent.left {
+			w := parent.right
+			if w.isRed() {
+				w.color, parent.color = BLACK, RED
+				t.rotateLeft(parent)
+				w = parent.right
+			}
+			if !w.left.isRed() && !w.right.isRed() {
+				w.color = RED
+				x, parent = parent, parent.parent
+				continue
+			}
+			if !w.right.isRed() {
+				w.left.color, w.color = BLACK, RED
+				t.rotateRight(w)
+				w = parent.right
+			}
+			w.color, parent.color = parent.color, BLACK
+			w.right.color = BLACK
+			t.rotateLeft(parent)
+		} else {
+			w := parent.left
+			if w.isRed() {
+				w.color, parent.color = BLACK, RED
+				t.rotateRight(parent)
+				w = parent.left
+			}
+			if !w.left.isRed() && !w.right.isRed() {
+				w.color = RED
+				x, parent = parent, parent.parent
+				continue
+			}
+			if !w.left.isRed() {
+				w.right.color, w.color = BLACK, RED
+				t.rotateLeft(w)
+				w = parent.left
+			}
+			w.color, parent.color = parent.color, BLACK
+			w.left.color = BLACK
+			t.rotateRight(parent)
+		}
+		x = t.root
+	}
+	if x != nil {
+		x.color = BLACK
+	}
+}
+
+func (t *inlineTree[K, V]) rotateLeft(x *inode[K, V]) {
+	y := x.right
+	x.right = y.left
+	if y.left != nil {
+		y.left.parent = x
+	}
+	t.transplant(x, y)
+	y.left = x
+	x.parent = y
+}
+
+func (t *inlineTree[K, V]) rotateRight(x *inode[K, V]) {
+	y := x.left
+	x.left = y.right
+	if y.right != nil {
+		y.right.parent = x
+	}
+	t.transplant(x, y)
+	y.right = x
+	x.parent = y
+}
+
+func (n *inode[K, V]) isRed() bool {
+	return n != nil && n.color == RED
+}
+
+func (t *inlineTree[K, V]) Len() int {
+	return t.size
+}
+
+func (t *inlineTree[K, V]) First() Cursor[K, V] {
+	n := t.root
+	for n != nil && n.left != nil {
+		n = n.left
+	}
+	return &inlineCursor[K, V]{n}
+}
+
+func (t *inlineTree[K, V]) Last() Cursor[K, V] {
+	n := t.root
+	for n != nil && n.right != nil {
+		n = n.right
+	}
+	return &inlineCursor[K, V]{n}
+}
+
+func (t *inlineTree[K, V]) LowerBound(key K) Cursor[K, V] {
+	var bound *inode[K, V]
+	for n := t.root; n != nil; {
+		if t.compare(n.key, key) >= 0 {
+			bound, n = n, n.left
+		} else {
+			n = n.right
+		}
+	}
+	return &inlineCursor[K, V]{bound}
+}
+
+func (t *inlineTree[K, V]) UpperBound(key K) Cursor[K, V] {
+	var bound *inode[K, V]
+	for n := t.root; n != nil; {
+		if t.compare(n.key, key) > 0 {
+			bound, n = n, n.left
+		} else {
+			n = n.right
+		}
+	}
+	return &inlineCursor[K, V]{bound}
+}
+
+type inlineCursor[K cmp.Ordered, V any] struct {
+	node *inode[K, V]
+}
+
+func (c *inlineCursor[K, V]) Valid() bool { return c.node != nil }
+func (c *inlineCursor[K, V]) Key() K      { return c.node.key }
+func (c *inlineCursor[K, V]) Value() V    { return c.node.value }
+
+func (c *inlineCursor[K, V]) Next() {
+	n := c.node
+	if n.right != nil {
+		for n = n.right; n.left != nil; n = n.left {
+		}
+		c.node = n
+		return
+	}
+	for n.parent != nil && n == n.parent.right {
+		n = n.parent
+	}
+	c.node = n.parent
+}
+
+func (c *inlineCursor[K, V]) Prev() {
+	n := c.node
+	if n.left != nil {
+		for n = n.left; n.right != nil; n = n.right {
+		}
+		c.node = n
+		return
+	}
+	for n.parent != nil && n == n.parent.left {
+		n = n.parent
+	}
+	c.node = n.parent
+}
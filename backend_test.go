@@ -0,0 +1,160 @@
+package odmap_test
+
+import (
+	"cmp"
+	"math/rand"
+	"runtime"
+	"testing"
+
+	odmap "github.com/RealFax/order-map"
+)
+
+var backends = map[string]func(compare func(int, int) int) odmap.Backend[int, int]{
+	"rbtree":   odmap.NewRBTreeBackend[int, int],
+	"inline":   odmap.NewInlineBackend[int, int],
+	"arena":    odmap.NewArenaBackend[int, int],
+	"btree":    odmap.NewBTreeBackend[int, int],
+	"skiplist": odmap.NewSkipListBackend[int, int],
+	"sorted":   odmap.NewSortedBackend[int, int],
+}
+
+func TestBackend_Model(t *testing.T) {
+	for name, newBackend := range backends {
+		t.Run(name, func(t *testing.T) {
+			// a reversed order checks that the backend uses the comparer it is given
+			reverse := func(a, b int) int { return cmp.Compare(b, a) }
+			b := newBackend(reverse)
+			model := odmap.NewRBTree[int, int](reverse)
+			r := rand.New(rand.NewSource(1))
+
+			for i := 0; i < 10000; i++ {
+				key := r.Intn(500)
+				node := model.FindNode(key)
+				switch r.Intn(3) {
+				case 0:
+					v, ok := b.Delete(key)
+					if ok != (node != nil) || (ok && v != node.Value()) {
+						t.Fatalf("Delete(%d): got %d, %v", key, v, ok)
+					}
+					model.Delete(node)
+				case 1:
+					v, ok := b.Insert(key, i)
+					if ok != (node != nil) || (ok && v != node.Value()) {
+						t.Fatalf("Insert(%d): got %d, %v", key, v, ok)
+					}
+					modelPut(model, key, i)
+				default:
+					v, ok := b.Find(key)
+					if ok != (node != nil) || (ok && v != node.Value()) {
+						t.Fatalf("Find(%d): got %d, %v", key, v, ok)
+					}
+				}
+
+				if c, want := b.LowerBound(key), model.FindLowerBoundNode(key); c.Valid() != (want != nil) ||
+					(want != nil && c.Key() != want.Key()) {
+					t.Fatalf("LowerBound(%d): got %d", key, c.Key())
+				}
+				if c, want := b.UpperBound(key), model.FindUpperBoundNode(key); c.Valid() != (want != nil) ||
+					(want != nil && c.Key() != want.Key()) {
+					t.Fatalf("UpperBound(%d): got %d", key, c.Key())
+				}
+				if i%500 == 0 {
+					checkBackend(t, b, model)
+				}
+			}
+			checkBackend(t, b, model)
+		})
+	}
+}
+
+// checkBackend fails unless b holds exactly the pairs of model, walking it both ways
+func checkBackend(t *testing.T, b odmap.Backend[int, int], model *odmap.RBTree[int, int]) {
+	t.Helper()
+	if b.Len() != model.Size() {
+		t.Fatalf("got len %d, want %d", b.Len(), model.Size())
+	}
+	node := model.First()
+	for c := b.First(); c.Valid(); c.Next() {
+		if node == nil || c.Key() != node.Key() || c.Value() != node.Value() {
+			t.Fatalf("unexpected pair %d=%d", c.Key(), c.Value())
+		}
+		node = node.Next()
+	}
+	if node != nil {
+		t.Fatalf("missing key %d", node.Key())
+	}
+	node = model.Last()
+	for c := b.Last(); c.Valid(); c.Prev() {
+		if node == nil || c.Key() != node.Key() {
+			t.Fatalf("unexpected key %d walking back", c.Key())
+		}
+		node = node.Prev()
+	}
+	if node != nil {
+		t.Fatalf("missing key %d walking back", node.Key())
+	}
+}
+
+func BenchmarkBackend_Insert(b *testing.B) {
+	for name, newBackend := range backends {
+		b.Run(name, func(b *testing.B) {
+			backend := newBackend(cmp.Compare[int])
+			r := rand.New(rand.NewSource(1))
+			for i := 0; i < b.N; i++ {
+				backend.Insert(r.Intn(1<<16), i)
+			}
+		})
+	}
+}
+
+// BenchmarkBackend_Layout compares the node layouts of the red-black trees on the
+// versioned values the single-threaded map stores
+func BenchmarkBackend_Layout(b *testing.B) {
+	const size = 1 << 16
+	layouts := map[string]func(compare func(int, int) int) odmap.Backend[int, odmap.Versioned[int]]{
+		"entry":  odmap.NewRBTreeBackend[int, odmap.Versioned[int]],
+		"inline": odmap.NewInlineBackend[int, odmap.Versioned[int]],
+	}
+	for name, newBackend := range layouts {
+		b.Run(name+"/insert", func(b *testing.B) {
+			backend := newBackend(cmp.Compare[int])
+			for i := 0; i < b.N; i++ {
+				backend.Insert(i, odmap.Versioned[int]{Value: i, Version: uint64(i)})
+			}
+		})
+		b.Run(name+"/find", func(b *testing.B) {
+			backend := newBackend(cmp.Compare[int])
+			for i := 0; i < size; i++ {
+				backend.Insert(i, odmap.Versioned[int]{Value: i})
+			}
+			r := rand.New(rand.NewSource(1))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				backend.Find(r.Intn(size))
+			}
+		})
+	}
+}
+
+// BenchmarkBackend_GC measures a collection with a million pairs in the heap, the
+// arena links its nodes by index so the collector has no node pointers to follow
+func BenchmarkBackend_GC(b *testing.B) {
+	const size = 1 << 20
+	layouts := map[string]func(compare func(int, int) int) odmap.Backend[int, odmap.Versioned[int]]{
+		"inline": odmap.NewInlineBackend[int, odmap.Versioned[int]],
+		"arena":  odmap.NewArenaBackend[int, odmap.Versioned[int]],
+	}
+	for name, newBackend := range layouts {
+		b.Run(name, func(b *testing.B) {
+			backend := newBackend(cmp.Compare[int])
+			for i := 0; i < size; i++ {
+				backend.Insert(i, odmap.Versioned[int]{Value: i})
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				runtime.GC()
+			}
+			runtime.KeepAlive(backend)
+		})
+	}
+}
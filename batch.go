@@ -0,0 +1,87 @@
+package odmap
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+)
+
+// ErrConditionFailed is wrapped by the *BatchError returned when a condition of a batch does not hold
+var ErrConditionFailed = errors.New("odmap: batch condition failed")
+
+// BatchError lists the keys whose conditions did not hold, none of the writes of the batch were applied
+type BatchError[K cmp.Ordered] struct {
+	Keys []K
+}
+
+func (e *BatchError[K]) Error() string {
+	return fmt.Sprintf("odmap: batch condition failed for keys %v", e.Keys)
+}
+
+func (e *BatchError[K]) Unwrap() error {
+	return ErrConditionFailed
+}
+
+// Batch is a group of writes guarded by conditions and applied atomically by Apply.
+// The zero value is an empty batch.
+type Batch[K cmp.Ordered, V any] struct {
+	conds []batchCond[K, V]
+	ops   []batchOp[K, V]
+}
+
+type batchCond[K cmp.Ordered, V any] struct {
+	key     K
+	value   V
+	present bool
+}
+
+type batchOp[K cmp.Ordered, V any] struct {
+	key    K
+	value  V
+	delete bool
+}
+
+// Put stores value for key
+func (b *Batch[K, V]) Put(key K, value V) *Batch[K, V] {
+	b.ops = append(b.ops, batchOp[K, V]{key: key, value: value})
+	return b
+}
+
+// Delete deletes key
+func (b *Batch[K, V]) Delete(key K) *Batch[K, V] {
+	b.ops = append(b.ops, batchOp[K, V]{key: key, delete: true})
+	return b
+}
+
+// Expect makes the batch apply only if key holds value.
+// As for CompareAndSwap, value must be of a comparable type.
+func (b *Batch[K, V]) Expect(key K, value V) *Batch[K, V] {
+	b.conds = append(b.conds, batchCond[K, V]{key: key, value: value, present: true})
+	return b
+}
+
+// ExpectAbsent makes the batch apply only if key is not in the map
+func (b *Batch[K, V]) ExpectAbsent(key K) *Batch[K, V] {
+	b.conds = append(b.conds, batchCond[K, V]{key: key})
+	return b
+}
+
+// Len returns the number of writes in the batch
+func (b *Batch[K, V]) Len() int {
+	return len(b.ops)
+}
+
+// check evaluates every condition against load, all of them are checked before any write
+func (b *Batch[K, V]) check(load func(K) (V, bool)) error {
+	var failed []K
+	for _, c := range b.conds {
+		v, ok := load(c.key)
+		if ok != c.present || (ok && any(v) != any(c.value)) {
+			failed = append(failed, c.key)
+		}
+	}
+	if len(failed) != 0 {
+		return &BatchError[K]{Keys: failed}
+	}
+	return nil
+}
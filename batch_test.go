@@ -0,0 +1,60 @@
+package odmap_test
+
+import (
+	"errors"
+	"testing"
+
+	odmap "github.com/RealFax/order-map"
+)
+
+func TestOrderedMap_Apply(t *testing.T) {
+	m := newMap[string, int]()
+	m.Store("from", 10)
+
+	// move the value of "from" to "to"
+	b := new(odmap.Batch[string, int]).
+		Expect("from", 10).
+		ExpectAbsent("to").
+		Delete("from").
+		Put("to", 10)
+	if err := m.Apply(b); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.Load("from"); ok {
+		t.Fatal("from is still present")
+	}
+	if v, ok := m.Load("to"); !ok || v != 10 {
+		t.Fatalf("to: got %d, %v, want 10", v, ok)
+	}
+
+	// the same batch cannot be applied twice
+	err := m.Apply(b)
+	var batchErr *odmap.BatchError[string]
+	if !errors.Is(err, odmap.ErrConditionFailed) || !errors.As(err, &batchErr) {
+		t.Fatalf("got %v, want a batch error", err)
+	}
+	if len(batchErr.Keys) != 2 || batchErr.Keys[0] != "from" || batchErr.Keys[1] != "to" {
+		t.Fatalf("got failed keys %v", batchErr.Keys)
+	}
+	if v, _ := m.Load("to"); v != 10 {
+		t.Fatalf("failed batch was applied, to = %d", v)
+	}
+}
+
+func TestOrderedMap_ApplyOrder(t *testing.T) {
+	m := newMap[int, int]()
+	var b odmap.Batch[int, int]
+	b.Put(1, 1).Put(1, 2).Put(2, 2).Delete(2).Put(3, 3)
+	if err := m.Apply(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []odmap.Pair[int, int]
+	m.Range(func(key int, value int) bool {
+		got = append(got, odmap.Pair[int, int]{Key: key, Value: value})
+		return true
+	})
+	if len(got) != 2 || got[0] != (odmap.Pair[int, int]{Key: 1, Value: 2}) || got[1] != (odmap.Pair[int, int]{Key: 3, Value: 3}) {
+		t.Fatalf("got %v", got)
+	}
+}
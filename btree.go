@@ -0,0 +1,424 @@
+package odmap
+
+import (
+	"cmp"
+	"slices"
+)
+
+// defaultBTreeDegree is the minimum degree of a B-tree, nodes hold 31 to 63 pairs
+const defaultBTreeDegree = 32
+
+// BTree is an in-memory B-tree. Every node holds its pairs in a sorted slice, which
+// takes far fewer allocations and pointer chases than one node per pair.
+type BTree[K cmp.Ordered, V any] struct {
+	degree  int
+	compare func(K, K) int
+	root    *bnode[K, V]
+	size    int
+	// mod counts the changes of the tree shape, iterators seek again after one
+	mod uint64
+}
+
+type bitem[K cmp.Ordered, V any] struct {
+	key   K
+	value V
+}
+
+// bnode is a node of a B-tree, children is nil for a leaf
+type bnode[K cmp.Ordered, V any] struct {
+	items    []bitem[K, V]
+	children []*bnode[K, V]
+}
+
+// NewBTree creates a B-tree of the given minimum degree, every node but the root
+// holds degree-1 to 2*degree-1 pairs. A degree below 2 selects the default of 32.
+func NewBTree[K cmp.Ordered, V any](degree int, compare func(K, K) int) *BTree[K, V] {
+	if degree < 2 {
+		degree = defaultBTreeDegree
+	}
+	return &BTree[K, V]{degree: degree, compare: compare}
+}
+
+func (n *bnode[K, V]) leaf() bool {
+	return n.children == nil
+}
+
+// search returns the index of the first pair of n not below key and whether it holds key
+func (t *BTree[K, V]) search(n *bnode[K, V], key K) (int, bool) {
+	return slices.BinarySearchFunc(n.items, key, func(item bitem[K, V], key K) int {
+		return t.compare(item.key, key)
+	})
+}
+
+// Len returns the number of pairs of the tree
+func (t *BTree[K, V]) Len() int {
+	return t.size
+}
+
+// Get returns the value of key
+func (t *BTree[K, V]) Get(key K) (V, bool) {
+	for n := t.root; n != nil; {
+		i, found := t.search(n, key)
+		if found {
+			return n.items[i].value, true
+		}
+		if n.leaf() {
+			break
+		}
+		n = n.children[i]
+	}
+	return empty[V](), false
+}
+
+// Put sets the value of key and returns the value it replaced
+func (t *BTree[K, V]) Put(key K, value V) (V, bool) {
+	if t.root == nil {
+		t.root = &bnode[K, V]{items: make([]bitem[K, V], 0, 2*t.degree-1)}
+	}
+	if len(t.root.items) == 2*t.degree-1 {
+		t.root = &bnode[K, V]{children: []*bnode[K, V]{t.root}}
+		t.split(t.root, 0)
+	}
+
+	n := t.root
+	for {
+		i, found := t.search(n, key)
+		if found {
+			previous := n.items[i].value
+			n.items[i].value = value
+			return previous, true
+		}
+		if n.leaf() {
+			n.items = slices.Insert(n.items, i, bitem[K, V]{key: key, value: value})
+			t.size++
+			t.mod++
+			return empty[V](), false
+		}
+		// split a full child before descending, so that it has room for key
+		if len(n.children[i].items) == 2*t.degree-1 {
+			t.split(n, i)
+			switch c := t.compare(key, n.items[i].key); {
+			case c == 0:
+				previous := n.items[i].value
+				n.items[i].value = value
+				return previous, true
+			case c > 0:
+				i++
+			}
+		}
+		n = n.children[i]
+	}
+}
+
+// split moves the upper half of the full child i of n to a new sibling and its
+// median pair to n
+func (t *BTree[K, V]) split(n *bnode[K, V], i int) {
+	child := n.children[i]
+	mid := t.degree - 1
+
+	right := &bnode[K, V]{items: append(make([]bitem[K, V], 0, 2*t.degree-1), child.items[mid+1:]...)}
+	if !child.leaf() {
+		right.children = append(make([]*bnode[K, V], 0, 2*t.degree), child.children[mid+1:]...)
+		clear(child.children[mid+1:])
+		child.children = child.children[:mid+1]
+	}
+	median := child.items[mid]
+	clear(child.items[mid:])
+	child.items = child.items[:mid]
+
+	n.items = slices.Insert(n.items, i, median)
+	n.children = slices.Insert(n.children, i+1, right)
+	t.mod++
+}
+
+// Delete removes key and returns its value
+func (t *BTree[K, V]) Delete(key K) (V, bool) {
+	if t.root == nil {
+		return empty[V](), false
+	}
+	item, ok := t.remove(t.root, key, removeKey)
+	if len(t.root.items) == 0 {
+		if t.root.leaf() {
+			t.root = nil
+		} else {
+			t.root = t.root.children[0]
+		}
+	}
+	t.mod++
+	if !ok {
+		return empty[V](), false
+	}
+	t.size--
+	return item.value, true
+}
+
+type removal int
+
+const (
+	removeKey removal = iota
+	removeMax
+)
+
+// remove deletes key, or the greatest pair, from the subtree of n. Every child is
+// given more than degree-1 pairs before descending into it, so a pair can always be
+// taken from the node reached.
+func (t *BTree[K, V]) remove(n *bnode[K, V], key K, what removal) (bitem[K, V], bool) {
+	for {
+		var (
+			i     int
+			found bool
+		)
+		if what == removeMax {
+			i = len(n.items)
+			if n.leaf() {
+				item := n.items[i-1]
+				n.items = deleteItem(n.items, i-1)
+				return item, true
+			}
+		} else {
+			i, found = t.search(n, key)
+			if n.leaf() {
+				if !found {
+					return bitem[K, V]{}, false
+				}
+				item := n.items[i]
+				n.items = deleteItem(n.items, i)
+				return item, true
+			}
+		}
+
+		if len(n.children[i].items) < t.degree {
+			// growing the child may move key into it, look for it again
+			t.grow(n, i)
+			continue
+		}
+		if found {
+			// replace the pair by its predecessor, the greatest pair of the left child
+			item := n.items[i]
+			n.items[i], _ = t.remove(n.children[i], key, removeMax)
+			return item, true
+		}
+		n = n.children[i]
+	}
+}
+
+// grow gives the child i of n one more pair, taken from a sibling through n or by
+// merging it with a sibling
+func (t *BTree[K, V]) grow(n *bnode[K, V], i int) {
+	child := n.children[i]
+	switch {
+	case i > 0 && len(n.children[i-1].items) >= t.degree:
+		left := n.children[i-1]
+		child.items = slices.Insert(child.items, 0, n.items[i-1])
+		n.items[i-1] = left.items[len(left.items)-1]
+		left.items = deleteItem(left.items, len(left.items)-1)
+		if !left.leaf() {
+			child.children = slices.Insert(child.children, 0, left.children[len(left.children)-1])
+			left.children = deleteChild(left.children, len(left.children)-1)
+		}
+	case i < len(n.items) && len(n.children[i+1].items) >= t.degree:
+		right := n.children[i+1]
+		child.items = append(child.items, n.items[i])
+		n.items[i] = right.items[0]
+		right.items = deleteItem(right.items, 0)
+		if !right.leaf() {
+			child.children = append(child.children, right.children[0])
+			right.children = deleteChild(right.children, 0)
+		}
+	default:
+		if i == len(n.items) {
+			i--
+			child = n.children[i]
+		}
+		right := n.children[i+1]
+		child.items = append(append(child.items, n.items[i]), right.items...)
+		if !child.leaf() {
+			child.children = append(child.children, right.children...)
+		}
+		n.items = deleteItem(n.items, i)
+		n.children = deleteChild(n.children, i+1)
+	}
+}
+
+// deleteItem removes the pair i of s, clearing the slot it frees
+func deleteItem[K cmp.Ordered, V any](s []bitem[K, V], i int) []bitem[K, V] {
+	copy(s[i:], s[i+1:])
+	s[len(s)-1] = bitem[K, V]{}
+	return s[:len(s)-1]
+}
+
+func deleteChild[K cmp.Ordered, V any](s []*bnode[K, V], i int) []*bnode[K, V] {
+	copy(s[i:], s[i+1:])
+	s[len(s)-1] = nil
+	return s[:len(s)-1]
+}
+
+// ---- iterator ----
+
+// BTreeIterator walks a B-tree in key order. It holds a copy of the pair it is at,
+// and seeks again from its key when the tree changed shape since it moved.
+type BTreeIterator[K cmp.Ordered, V any] struct {
+	tree  *BTree[K, V]
+	mod   uint64
+	stack []bframe[K, V]
+	item  bitem[K, V]
+}
+
+// bframe is a node on the path of an iterator. It is at the pair i of the last node,
+// and in the child i of the others.
+type bframe[K cmp.Ordered, V any] struct {
+	n *bnode[K, V]
+	i int
+}
+
+// First returns an iterator at the pair with the least key
+func (t *BTree[K, V]) First() *BTreeIterator[K, V] {
+	it := &BTreeIterator[K, V]{tree: t, mod: t.mod}
+	if t.root != nil {
+		it.leftmost(t.root)
+	}
+	return it.load()
+}
+
+// Last returns an iterator at the pair with the greatest key
+func (t *BTree[K, V]) Last() *BTreeIterator[K, V] {
+	it := &BTreeIterator[K, V]{tree: t, mod: t.mod}
+	if t.root != nil {
+		it.rightmost(t.root)
+	}
+	return it.load()
+}
+
+// LowerBound returns an iterator at the first pair whose key is equal or greater than key
+func (t *BTree[K, V]) LowerBound(key K) *BTreeIterator[K, V] {
+	return t.seek(key, false)
+}
+
+// UpperBound returns an iterator at the first pair whose key is greater than key
+func (t *BTree[K, V]) UpperBound(key K) *BTreeIterator[K, V] {
+	return t.seek(key, true)
+}
+
+func (t *BTree[K, V]) seek(key K, strict bool) *BTreeIterator[K, V] {
+	it := &BTreeIterator[K, V]{tree: t, mod: t.mod}
+	for n := t.root; n != nil; {
+		i, found := t.search(n, key)
+		if found && !strict {
+			it.stack = append(it.stack, bframe[K, V]{n: n, i: i})
+			return it.load()
+		}
+		if found {
+			i++
+		}
+		it.stack = append(it.stack, bframe[K, V]{n: n, i: i})
+		if n.leaf() {
+			break
+		}
+		n = n.children[i]
+	}
+	it.ascend()
+	return it.load()
+}
+
+// leftmost descends to the least pair of the subtree of n
+func (it *BTreeIterator[K, V]) leftmost(n *bnode[K, V]) {
+	for {
+		it.stack = append(it.stack, bframe[K, V]{n: n})
+		if n.leaf() {
+			return
+		}
+		n = n.children[0]
+	}
+}
+
+// rightmost descends to the greatest pair of the subtree of n
+func (it *BTreeIterator[K, V]) rightmost(n *bnode[K, V]) {
+	for !n.leaf() {
+		it.stack = append(it.stack, bframe[K, V]{n: n, i: len(n.children) - 1})
+		n = n.children[len(n.children)-1]
+	}
+	it.stack = append(it.stack, bframe[K, V]{n: n, i: len(n.items) - 1})
+}
+
+// ascend pops the frames past the end of their node, up to the next pair
+func (it *BTreeIterator[K, V]) ascend() {
+	for len(it.stack) != 0 {
+		top := it.stack[len(it.stack)-1]
+		if top.i < len(top.n.items) {
+			return
+		}
+		it.stack = it.stack[:len(it.stack)-1]
+	}
+}
+
+func (it *BTreeIterator[K, V]) load() *BTreeIterator[K, V] {
+	if it.IsValid() {
+		top := it.stack[len(it.stack)-1]
+		it.item = top.n.items[top.i]
+	}
+	return it
+}
+
+// IsValid returns true if the iterator is at a pair
+func (it *BTreeIterator[K, V]) IsValid() bool {
+	return len(it.stack) != 0
+}
+
+// Next moves the iterator to the next pair
+func (it *BTreeIterator[K, V]) Next() {
+	if !it.IsValid() {
+		return
+	}
+	if it.mod != it.tree.mod {
+		*it = *it.tree.seek(it.item.key, true)
+		return
+	}
+
+	top := &it.stack[len(it.stack)-1]
+	top.i++
+	if !top.n.leaf() {
+		it.leftmost(top.n.children[top.i])
+	} else {
+		it.ascend()
+	}
+	it.load()
+}
+
+// Prev moves the iterator to the previous pair
+func (it *BTreeIterator[K, V]) Prev() {
+	if !it.IsValid() {
+		return
+	}
+	if it.mod != it.tree.mod {
+		next := it.tree.seek(it.item.key, false)
+		if !next.IsValid() {
+			*it = *it.tree.Last()
+			return
+		}
+		*it = *next
+	}
+
+	top := &it.stack[len(it.stack)-1]
+	if !top.n.leaf() {
+		it.rightmost(top.n.children[top.i])
+		it.load()
+		return
+	}
+	for top.i--; top.i < 0; top.i-- {
+		if it.stack = it.stack[:len(it.stack)-1]; len(it.stack) == 0 {
+			return
+		}
+		top = &it.stack[len(it.stack)-1]
+	}
+	it.load()
+}
+
+// Key returns the key of the pair the iterator is at
+func (it *BTreeIterator[K, V]) Key() K {
+	return it.item.key
+}
+
+// Value returns the value of the pair the iterator is at, as of when it moved there
+func (it *BTreeIterator[K, V]) Value() V {
+	return it.item.value
+}
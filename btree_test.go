@@ -0,0 +1,156 @@
+package odmap_test
+
+import (
+	"cmp"
+	"math/rand"
+	"testing"
+
+	odmap "github.com/RealFax/order-map"
+)
+
+// checkBTree fails unless tree holds exactly the pairs of model, walking it both ways
+func checkBTree(t *testing.T, tree *odmap.BTree[int, int], model *odmap.RBTree[int, int]) {
+	t.Helper()
+	if tree.Len() != model.Size() {
+		t.Fatalf("got len %d, want %d", tree.Len(), model.Size())
+	}
+	node := model.First()
+	for it := tree.First(); it.IsValid(); it.Next() {
+		if node == nil || it.Key() != node.Key() || it.Value() != node.Value() {
+			t.Fatalf("unexpected pair %d=%d", it.Key(), it.Value())
+		}
+		node = node.Next()
+	}
+	if node != nil {
+		t.Fatalf("missing key %d", node.Key())
+	}
+	node = model.Last()
+	for it := tree.Last(); it.IsValid(); it.Prev() {
+		if node == nil || it.Key() != node.Key() {
+			t.Fatalf("unexpected key %d walking back", it.Key())
+		}
+		node = node.Prev()
+	}
+	if node != nil {
+		t.Fatalf("missing key %d walking back", node.Key())
+	}
+}
+
+func TestBTree_Model(t *testing.T) {
+	for _, degree := range []int{2, 3, 32} {
+		tree := odmap.NewBTree[int, int](degree, cmp.Compare[int])
+		model := odmap.NewRBTree[int, int](cmp.Compare[int])
+		r := rand.New(rand.NewSource(int64(degree)))
+
+		for i := 0; i < 20000; i++ {
+			key := r.Intn(2000)
+			node := model.FindNode(key)
+			switch r.Intn(3) {
+			case 0:
+				v, ok := tree.Delete(key)
+				if ok != (node != nil) || (ok && v != node.Value()) {
+					t.Fatalf("degree %d, Delete(%d): got %d, %v", degree, key, v, ok)
+				}
+				model.Delete(node)
+			case 1:
+				v, ok := tree.Put(key, i)
+				if ok != (node != nil) || (ok && v != node.Value()) {
+					t.Fatalf("degree %d, Put(%d): got %d, %v", degree, key, v, ok)
+				}
+				modelPut(model, key, i)
+			default:
+				v, ok := tree.Get(key)
+				if ok != (node != nil) || (ok && v != node.Value()) {
+					t.Fatalf("degree %d, Get(%d): got %d, %v", degree, key, v, ok)
+				}
+			}
+
+			if lower, want := tree.LowerBound(key), model.FindLowerBoundNode(key); lower.IsValid() != (want != nil) ||
+				(want != nil && lower.Key() != want.Key()) {
+				t.Fatalf("degree %d, LowerBound(%d): got %d", degree, key, lower.Key())
+			}
+			if upper, want := tree.UpperBound(key), model.FindUpperBoundNode(key); upper.IsValid() != (want != nil) ||
+				(want != nil && upper.Key() != want.Key()) {
+				t.Fatalf("degree %d, UpperBound(%d): got %d", degree, key, upper.Key())
+			}
+			if i%1000 == 0 {
+				checkBTree(t, tree, model)
+			}
+		}
+		checkBTree(t, tree, model)
+
+		// draining the tree merges every node back into the root
+		for node := model.First(); node != nil; node = model.First() {
+			if _, ok := tree.Delete(node.Key()); !ok {
+				t.Fatalf("degree %d: missing key %d", degree, node.Key())
+			}
+			model.Delete(node)
+		}
+		checkBTree(t, tree, model)
+	}
+}
+
+func TestBTree_IteratorAfterWrite(t *testing.T) {
+	tree := odmap.NewBTree[int, int](2, cmp.Compare[int])
+	for i := 0; i < 100; i++ {
+		tree.Put(i, i)
+	}
+
+	// deleting the visited keys and adding ones behind the iterator reshapes the
+	// tree, the iterator resumes after the key it was at
+	var got []int
+	for it := tree.First(); it.IsValid(); it.Next() {
+		got = append(got, it.Key())
+		tree.Delete(it.Key())
+		tree.Put(-it.Key()-1, 0)
+	}
+	if len(got) != 100 || got[99] != 99 {
+		t.Fatalf("visited %d keys", len(got))
+	}
+
+	it := tree.Last()
+	tree.Delete(it.Key())
+	if it.Prev(); !it.IsValid() || it.Key() != -2 {
+		t.Fatalf("Prev: got %d, %v", it.Key(), it.IsValid())
+	}
+}
+
+func BenchmarkBTree_Put(b *testing.B) {
+	tree := odmap.NewBTree[int, int](0, cmp.Compare[int])
+	for i := 0; i < b.N; i++ {
+		tree.Put(i, i)
+	}
+}
+
+func BenchmarkRBTree_Insert(b *testing.B) {
+	tree := odmap.NewRBTree[int, int](cmp.Compare[int])
+	for i := 0; i < b.N; i++ {
+		tree.Insert(i, i)
+	}
+}
+
+func BenchmarkBTree_Get(b *testing.B) {
+	const size = 1 << 20
+	tree := odmap.NewBTree[int, int](0, cmp.Compare[int])
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < size; i++ {
+		tree.Put(r.Int(), i)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tree.Get(r.Int())
+	}
+}
+
+func BenchmarkRBTree_FindNode(b *testing.B) {
+	const size = 1 << 20
+	tree := odmap.NewRBTree[int, int](cmp.Compare[int])
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < size; i++ {
+		tree.Insert(r.Int(), i)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tree.FindNode(r.Int())
+	}
+}
@@ -0,0 +1,326 @@
+package odmap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"sync"
+)
+
+// slabSize is the size of a slab, a larger pair gets a slab of its own
+const slabSize = 1 << 20
+
+// BytesMap is a concurrent ordered map of byte slices, ordered by bytes.Compare.
+// Keys and values are copied into large slabs and indexed by a tree allocated from an
+// arena, neither holds a pointer per pair, so the garbage collector has next to
+// nothing to scan however many pairs the map holds. The space of replaced and deleted
+// pairs is reclaimed by copying the live ones once it exceeds them.
+//
+// The slices passed to the fn of Range are only valid until fn returns, the other
+// methods return copies.
+type BytesMap struct {
+	mu sync.RWMutex
+	// index maps the reference of every key to the reference of its value
+	index *arenaTree[uint64, uint64]
+	slabs [][]byte
+	// live is the size of the records in use, garbage of the others
+	live    int
+	garbage int
+}
+
+func NewBytesMap() *BytesMap {
+	m := &BytesMap{}
+	m.index = m.newIndex()
+	return m
+}
+
+func (m *BytesMap) newIndex() *arenaTree[uint64, uint64] {
+	return &arenaTree[uint64, uint64]{compare: m.compareRefs, used: 1}
+}
+
+// a reference locates a record, a length prefixed byte slice, by its slab in the
+// upper half and its offset in the lower one
+
+func (m *BytesMap) record(ref uint64) []byte {
+	slab := m.slabs[ref>>32][uint32(ref):]
+	n, w := binary.Uvarint(slab)
+	return slab[w : w+int(n) : w+int(n)]
+}
+
+// recordSize returns the size of the record of b
+func recordSize(b []byte) int {
+	size := len(b) + 1
+	for n := len(b); n >= 0x80; n >>= 7 {
+		size++
+	}
+	return size
+}
+
+func (m *BytesMap) compareRefs(a, b uint64) int {
+	return bytes.Compare(m.record(a), m.record(b))
+}
+
+// write copies b to a new record and returns its reference
+func (m *BytesMap) write(b []byte) uint64 {
+	size := recordSize(b)
+	last := len(m.slabs) - 1
+	if last < 0 || len(m.slabs[last])+size > cap(m.slabs[last]) {
+		m.slabs = append(m.slabs, make([]byte, 0, max(slabSize, size)))
+		last++
+	}
+	slab := m.slabs[last]
+	ref := uint64(last)<<32 | uint64(len(slab))
+	slab = binary.AppendUvarint(slab, uint64(len(b)))
+	m.slabs[last] = append(slab, b...)
+	m.live += size
+	return ref
+}
+
+// drop accounts for a record that is no longer used
+func (m *BytesMap) drop(ref uint64) {
+	size := recordSize(m.record(ref))
+	m.live -= size
+	m.garbage += size
+}
+
+// compactLocked copies the live records to new slabs once the garbage exceeds them
+func (m *BytesMap) compactLocked() {
+	if m.garbage <= m.live || m.garbage < slabSize {
+		return
+	}
+
+	old := &BytesMap{index: m.index, slabs: m.slabs}
+	m.slabs, m.live, m.garbage = nil, 0, 0
+	m.index = m.newIndex()
+	for c := old.index.First(); c.Valid(); c.Next() {
+		m.index.Insert(m.write(old.record(c.Key())), m.write(old.record(c.Value())))
+	}
+}
+
+// probe returns the comparison of the keys of the index with key
+func (m *BytesMap) probe(key []byte) func(ref uint64) int {
+	return func(ref uint64) int {
+		return bytes.Compare(m.record(ref), key)
+	}
+}
+
+// find returns the node of key
+func (m *BytesMap) find(key []byte) int32 {
+	i := m.index.bound(m.probe(key), false)
+	if i != 0 && bytes.Equal(m.record(m.index.node(i).key), key) {
+		return i
+	}
+	return 0
+}
+
+// pair returns copies of the key and the value of node i
+func (m *BytesMap) pair(i int32) ([]byte, []byte) {
+	n := m.index.node(i)
+	return bytes.Clone(m.record(n.key)), bytes.Clone(m.record(n.value))
+}
+
+func (m *BytesMap) Load(key []byte) ([]byte, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	i := m.find(key)
+	if i == 0 {
+		return nil, false
+	}
+	return bytes.Clone(m.record(m.index.node(i).value)), true
+}
+
+func (m *BytesMap) Store(key, value []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.storeLocked(key, value)
+}
+
+func (m *BytesMap) storeLocked(key, value []byte) {
+	if i := m.find(key); i != 0 {
+		n := m.index.node(i)
+		m.drop(n.value)
+		n.value = m.write(value)
+		m.compactLocked()
+		return
+	}
+	m.index.Insert(m.write(key), m.write(value))
+}
+
+func (m *BytesMap) Swap(key, value []byte) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := m.find(key)
+	if i == 0 {
+		m.storeLocked(key, value)
+		return nil, false
+	}
+	_, previous := m.pair(i)
+	m.storeLocked(key, value)
+	return previous, true
+}
+
+func (m *BytesMap) LoadOrStore(key, value []byte) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if i := m.find(key); i != 0 {
+		_, actual := m.pair(i)
+		return actual, true
+	}
+	m.storeLocked(key, value)
+	return bytes.Clone(value), false
+}
+
+func (m *BytesMap) LoadAndDelete(key []byte) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := m.find(key)
+	if i == 0 {
+		return nil, false
+	}
+	_, value := m.pair(i)
+	m.deleteLocked(i)
+	return value, true
+}
+
+func (m *BytesMap) deleteLocked(i int32) {
+	n := m.index.node(i)
+	keyRef, valueRef := n.key, n.value
+	m.index.Delete(keyRef)
+	m.drop(keyRef)
+	m.drop(valueRef)
+	m.compactLocked()
+}
+
+func (m *BytesMap) Delete(key []byte) {
+	_, _ = m.LoadAndDelete(key)
+}
+
+func (m *BytesMap) CompareAndSwap(key, old, new []byte) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := m.find(key)
+	if i == 0 || !bytes.Equal(m.record(m.index.node(i).value), old) {
+		return false
+	}
+	m.storeLocked(key, new)
+	return true
+}
+
+func (m *BytesMap) CompareAndDelete(key, old []byte) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := m.find(key)
+	if i == 0 || !bytes.Equal(m.record(m.index.node(i).value), old) {
+		return false
+	}
+	m.deleteLocked(i)
+	return true
+}
+
+// Range calls fn for every pair in key order. The pairs are copied a batch at a time
+// and the map is not locked while fn runs, so fn may call any method of the map, but
+// as for sync.Map the pairs are not observed at a single point in time. The slices
+// passed to fn are only valid until it returns.
+func (m *BytesMap) Range(fn func(key, value []byte) bool) {
+	var (
+		buf []byte
+		// ends holds the end of every key and value in buf
+		ends []int
+		// after is the key of the last pair of the previous batch
+		after []byte
+	)
+	for first := true; first || len(ends) == 2*shardBatch; first = false {
+		m.mu.RLock()
+		c := m.index.First().(*arenaCursor[uint64, uint64])
+		if !first {
+			c.i = m.index.bound(m.probe(after), true)
+		}
+		buf, ends = buf[:0], ends[:0]
+		for ; c.Valid() && len(ends) < 2*shardBatch; c.Next() {
+			buf = append(buf, m.record(c.Key())...)
+			ends = append(ends, len(buf))
+			buf = append(buf, m.record(c.Value())...)
+			ends = append(ends, len(buf))
+		}
+		m.mu.RUnlock()
+
+		start := 0
+		for i := 0; i < len(ends); i += 2 {
+			key, value := buf[start:ends[i]:ends[i]], buf[ends[i]:ends[i+1]:ends[i+1]]
+			if i == len(ends)-2 {
+				after = append(after[:0], key...)
+			}
+			if !fn(key, value) {
+				return
+			}
+			start = ends[i+1]
+		}
+	}
+}
+
+// Floor returns the pair with the greatest key less than or equal to key
+func (m *BytesMap) Floor(key []byte) ([]byte, []byte, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	c := &arenaCursor[uint64, uint64]{tree: m.index, i: m.index.bound(m.probe(key), true)}
+	if c.Valid() {
+		c.Prev()
+	} else {
+		c = m.index.Last().(*arenaCursor[uint64, uint64])
+	}
+	if !c.Valid() {
+		return nil, nil, false
+	}
+	k, v := m.pair(c.i)
+	return k, v, true
+}
+
+// Ceiling returns the pair with the least key greater than or equal to key
+func (m *BytesMap) Ceiling(key []byte) ([]byte, []byte, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	i := m.index.bound(m.probe(key), false)
+	if i == 0 {
+		return nil, nil, false
+	}
+	k, v := m.pair(i)
+	return k, v, true
+}
+
+func (m *BytesMap) Len() int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return int64(m.index.Len())
+}
+
+func (m *BytesMap) Contains(key []byte) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.find(key) != 0
+}
+
+type bytesPair struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+func (m *BytesMap) MarshalJSON() ([]byte, error) {
+	m.mu.RLock()
+	s := make([]bytesPair, 0, m.index.Len())
+	for c := m.index.First(); c.Valid(); c.Next() {
+		s = append(s, bytesPair{Key: bytes.Clone(m.record(c.Key())), Value: bytes.Clone(m.record(c.Value()))})
+	}
+	m.mu.RUnlock()
+	return json.Marshal(s)
+}
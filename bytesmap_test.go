@@ -0,0 +1,213 @@
+package odmap_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"slices"
+	"testing"
+
+	odmap "github.com/RealFax/order-map"
+)
+
+func TestBytesMap_Model(t *testing.T) {
+	m := odmap.NewBytesMap()
+	model := map[string]string{}
+	r := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 20000; i++ {
+		key := []byte(fmt.Sprint(r.Intn(300)))
+		// large values make the map compact its slabs along the way
+		value := bytes.Repeat([]byte{byte(i)}, r.Intn(8000))
+		want, exists := model[string(key)]
+
+		switch r.Intn(5) {
+		case 0:
+			v, ok := m.LoadAndDelete(key)
+			if ok != exists || string(v) != want {
+				t.Fatalf("LoadAndDelete(%s): got %d bytes, %v", key, len(v), ok)
+			}
+			delete(model, string(key))
+		case 1:
+			v, ok := m.Swap(key, value)
+			if ok != exists || string(v) != want {
+				t.Fatalf("Swap(%s): got %d bytes, %v", key, len(v), ok)
+			}
+			model[string(key)] = string(value)
+		case 2:
+			if m.CompareAndSwap(key, []byte(want), value) != exists {
+				t.Fatalf("CompareAndSwap(%s) failed", key)
+			}
+			if exists {
+				model[string(key)] = string(value)
+			}
+		case 3:
+			v, loaded := m.LoadOrStore(key, value)
+			if loaded != exists || (loaded && string(v) != want) {
+				t.Fatalf("LoadOrStore(%s): got %d bytes, %v", key, len(v), loaded)
+			}
+			if !loaded {
+				model[string(key)] = string(value)
+			}
+		default:
+			m.Store(key, value)
+			model[string(key)] = string(value)
+		}
+
+		if i%1000 == 0 {
+			checkBytesMap(t, m, model)
+		}
+	}
+	checkBytesMap(t, m, model)
+}
+
+// checkBytesMap fails unless m holds exactly the pairs of model, in order
+func checkBytesMap(t *testing.T, m *odmap.BytesMap, model map[string]string) {
+	t.Helper()
+	keys := make([]string, 0, len(model))
+	for key := range model {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	if m.Len() != int64(len(keys)) {
+		t.Fatalf("got len %d, want %d", m.Len(), len(keys))
+	}
+	i := 0
+	m.Range(func(key, value []byte) bool {
+		if i >= len(keys) || string(key) != keys[i] || string(value) != model[keys[i]] {
+			t.Fatalf("unexpected key %s", key)
+		}
+		i++
+		return true
+	})
+	for _, key := range keys {
+		if v, ok := m.Load([]byte(key)); !ok || string(v) != model[key] {
+			t.Fatalf("Load(%s): got %d bytes, %v", key, len(v), ok)
+		}
+	}
+}
+
+func TestBytesMap_FloorCeiling(t *testing.T) {
+	m := odmap.NewBytesMap()
+	for _, key := range []string{"b", "d", "f"} {
+		m.Store([]byte(key), []byte(key+key))
+	}
+
+	tests := []struct {
+		key, floor, ceiling string
+	}{
+		{"a", "", "b"},
+		{"b", "b", "b"},
+		{"c", "b", "d"},
+		{"e", "d", "f"},
+		{"f", "f", "f"},
+		{"g", "f", ""},
+	}
+	for _, tt := range tests {
+		key, value, ok := m.Floor([]byte(tt.key))
+		if ok != (tt.floor != "") || string(key) != tt.floor || (ok && string(value) != tt.floor+tt.floor) {
+			t.Errorf("Floor(%s): got %s, %v", tt.key, key, ok)
+		}
+		key, value, ok = m.Ceiling([]byte(tt.key))
+		if ok != (tt.ceiling != "") || string(key) != tt.ceiling || (ok && string(value) != tt.ceiling+tt.ceiling) {
+			t.Errorf("Ceiling(%s): got %s, %v", tt.key, key, ok)
+		}
+	}
+}
+
+func TestBytesMap_Copies(t *testing.T) {
+	m := odmap.NewBytesMap()
+	key, value := []byte("key"), []byte("value")
+	m.Store(key, value)
+	key[0], value[0] = 'x', 'x'
+
+	v, ok := m.Load([]byte("key"))
+	if !ok || string(v) != "value" {
+		t.Fatalf("Load: got %s, %v", v, ok)
+	}
+	v[0] = 'x'
+	if v, _ = m.Load([]byte("key")); string(v) != "value" {
+		t.Fatalf("Load: got %s after writing to the copy", v)
+	}
+
+	// appending to a slice passed to Range must not write to the slabs
+	m.Store([]byte("next"), []byte("pair"))
+	m.Range(func(key, value []byte) bool {
+		_ = append(value, 'x')
+		return true
+	})
+	if v, _ = m.Load([]byte("next")); string(v) != "pair" {
+		t.Fatalf("Load: got %s after appending in Range", v)
+	}
+}
+
+func TestBytesMap_MarshalJSON(t *testing.T) {
+	m := odmap.NewBytesMap()
+	m.Store([]byte("b"), []byte("2"))
+	m.Store([]byte("a"), []byte("1"))
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `[{"key":"YQ==","value":"MQ=="},{"key":"Yg==","value":"Mg=="}]`; string(b) != want {
+		t.Fatalf("got %s, want %s", b, want)
+	}
+}
+
+func TestBytesMap_RangeReentrant(t *testing.T) {
+	m := odmap.NewBytesMap()
+	value := bytes.Repeat([]byte{'v'}, 2048)
+	for i := 0; i < 1000; i++ {
+		m.Store([]byte(fmt.Sprintf("%04d", i)), value)
+	}
+
+	// fn replaces every pair, which holding the read lock would deadlock, and the
+	// garbage it leaves compacts the slabs while Range goes on
+	n := 0
+	m.Range(func(key, v []byte) bool {
+		if !bytes.Equal(v, value) {
+			t.Fatalf("%s: got a replaced value", key)
+		}
+		m.Store(key, key)
+		n++
+		return true
+	})
+	if n != 1000 {
+		t.Fatalf("got %d pairs, want 1000", n)
+	}
+	if v, _ := m.Load([]byte("0999")); string(v) != "0999" {
+		t.Fatalf("got %.10s, want 0999", v)
+	}
+}
+
+// BenchmarkBytesMap_GC measures a collection with a million pairs in the heap, the
+// BytesMap holds them in slabs the collector does not scan
+func BenchmarkBytesMap_GC(b *testing.B) {
+	const size = 1 << 20
+	b.Run("map", func(b *testing.B) {
+		m := newMap[string, []byte]()
+		for i := 0; i < size; i++ {
+			m.Store(fmt.Sprint(i), []byte("value"))
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			runtime.GC()
+		}
+		runtime.KeepAlive(m)
+	})
+	b.Run("bytes", func(b *testing.B) {
+		m := odmap.NewBytesMap()
+		for i := 0; i < size; i++ {
+			m.Store([]byte(fmt.Sprint(i)), []byte("value"))
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			runtime.GC()
+		}
+		runtime.KeepAlive(m)
+	})
+}
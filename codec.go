@@ -0,0 +1,186 @@
+package odmap
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"unsafe"
+)
+
+var ErrCodec = errors.New("odmap: malformed encoding")
+
+// Codec converts the keys or the values of a map to bytes and back, for the formats
+// that store maps in files
+type Codec[T any] interface {
+	// Append appends the encoding of v to b
+	Append(b []byte, v T) ([]byte, error)
+	// Decode returns the value encoded by b, which it does not retain
+	Decode(b []byte) (T, error)
+	// Width returns the size of every encoding, or 0 if it varies
+	Width() int
+}
+
+// orderedCodec encodes integers and floats in big-endian order with the sign bit
+// flipped, and strings as is
+type orderedCodec[K cmp.Ordered] struct {
+	kind  reflect.Kind
+	width int
+}
+
+// KeyCodec returns the codec of K whose encodings compare by bytes.Compare as the
+// keys do by cmp.Compare. Numbers have fixed-width encodings, strings their bytes.
+func KeyCodec[K cmp.Ordered]() Codec[K] {
+	var key K
+	c := orderedCodec[K]{kind: reflect.TypeOf(key).Kind(), width: int(unsafe.Sizeof(key))}
+	if c.kind == reflect.String {
+		c.width = 0
+	}
+	return c
+}
+
+func (c orderedCodec[K]) Width() int { return c.width }
+
+func (c orderedCodec[K]) Append(b []byte, key K) ([]byte, error) {
+	p := unsafe.Pointer(&key)
+	var u uint64
+	switch c.kind {
+	case reflect.String:
+		return append(b, *(*string)(p)...), nil
+	case reflect.Float32:
+		f := *(*float32)(p)
+		u = orderFloat(uint64(math.Float32bits(f)), c.width, f != f, f == 0)
+	case reflect.Float64:
+		f := *(*float64)(p)
+		u = orderFloat(math.Float64bits(f), c.width, f != f, f == 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		u = uint64(loadInt(p, c.width)) ^ 1<<(8*c.width-1)
+	default:
+		u = loadUint(p, c.width)
+	}
+
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], u)
+	return append(b, buf[8-c.width:]...), nil
+}
+
+func (c orderedCodec[K]) Decode(b []byte) (K, error) {
+	var key K
+	p := unsafe.Pointer(&key)
+	if c.kind == reflect.String {
+		*(*string)(p) = string(b)
+		return key, nil
+	}
+	if len(b) != c.width {
+		return key, ErrCodec
+	}
+
+	var buf [8]byte
+	copy(buf[8-c.width:], b)
+	u := binary.BigEndian.Uint64(buf[:])
+	switch c.kind {
+	case reflect.Float32:
+		*(*float32)(p) = math.Float32frombits(uint32(unorderFloat(u, c.width)))
+	case reflect.Float64:
+		*(*float64)(p) = math.Float64frombits(unorderFloat(u, c.width))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		storeUint(p, c.width, u^1<<(8*c.width-1))
+	default:
+		storeUint(p, c.width, u)
+	}
+	return key, nil
+}
+
+// orderFloat returns the bits of a float of width bytes flipped so that they compare
+// as cmp.Compare orders the floats, both zeros alike and every NaN below -Inf
+func orderFloat(bits uint64, width int, nan, zero bool) uint64 {
+	sign := uint64(1) << (8*width - 1)
+	switch {
+	case nan:
+		return 0
+	case zero:
+		return sign
+	case bits&sign == 0:
+		return bits | sign
+	}
+	return ^bits & (sign<<1 - 1)
+}
+
+func unorderFloat(u uint64, width int) uint64 {
+	sign := uint64(1) << (8*width - 1)
+	if u&sign != 0 {
+		return u &^ sign
+	}
+	return ^u & (sign<<1 - 1)
+}
+
+func loadInt(p unsafe.Pointer, width int) int64 {
+	switch width {
+	case 1:
+		return int64(*(*int8)(p))
+	case 2:
+		return int64(*(*int16)(p))
+	case 4:
+		return int64(*(*int32)(p))
+	}
+	return *(*int64)(p)
+}
+
+func loadUint(p unsafe.Pointer, width int) uint64 {
+	switch width {
+	case 1:
+		return uint64(*(*uint8)(p))
+	case 2:
+		return uint64(*(*uint16)(p))
+	case 4:
+		return uint64(*(*uint32)(p))
+	}
+	return *(*uint64)(p)
+}
+
+func storeUint(p unsafe.Pointer, width int, u uint64) {
+	switch width {
+	case 1:
+		*(*uint8)(p) = uint8(u)
+	case 2:
+		*(*uint16)(p) = uint16(u)
+	case 4:
+		*(*uint32)(p) = uint32(u)
+	default:
+		*(*uint64)(p) = u
+	}
+}
+
+type bytesCodec[T ~string | ~[]byte] struct{}
+
+// BytesCodec returns the codec storing strings and byte slices as is
+func BytesCodec[T ~string | ~[]byte]() Codec[T] {
+	return bytesCodec[T]{}
+}
+
+func (bytesCodec[T]) Append(b []byte, v T) ([]byte, error) { return append(b, v...), nil }
+func (bytesCodec[T]) Decode(b []byte) (T, error)           { return T(bytes.Clone(b)), nil }
+func (bytesCodec[T]) Width() int                           { return 0 }
+
+type jsonCodec[T any] struct{}
+
+// JSONCodec returns the codec storing values as JSON
+func JSONCodec[T any]() Codec[T] {
+	return jsonCodec[T]{}
+}
+
+func (jsonCodec[T]) Append(b []byte, v T) ([]byte, error) {
+	data, err := json.Marshal(v)
+	return append(b, data...), err
+}
+
+func (jsonCodec[T]) Decode(b []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(b, &v)
+	return v, err
+}
+
+func (jsonCodec[T]) Width() int { return 0 }
@@ -0,0 +1,65 @@
+package odmap_test
+
+import (
+	"bytes"
+	"cmp"
+	"math"
+	"testing"
+
+	odmap "github.com/RealFax/order-map"
+)
+
+// checkKeyCodec fails unless the encodings of keys round-trip and compare as the keys
+func checkKeyCodec[K cmp.Ordered](t *testing.T, keys ...K) {
+	t.Helper()
+	c := odmap.KeyCodec[K]()
+	for _, a := range keys {
+		ea, err := c.Append(nil, a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Width() != 0 && len(ea) != c.Width() {
+			t.Fatalf("%v: got %d bytes, want %d", a, len(ea), c.Width())
+		}
+		if d, err := c.Decode(ea); err != nil || cmp.Compare(d, a) != 0 {
+			t.Fatalf("%v: decoded %v, %v", a, d, err)
+		}
+		for _, b := range keys {
+			eb, _ := c.Append(nil, b)
+			if got, want := bytes.Compare(ea, eb), cmp.Compare(a, b); got != want {
+				t.Fatalf("%v, %v: encodings compare %d, want %d", a, b, got, want)
+			}
+		}
+	}
+}
+
+func TestKeyCodec_Order(t *testing.T) {
+	type name string
+	checkKeyCodec(t, math.MinInt, -1000, -1, 0, 1, 255, math.MaxInt)
+	checkKeyCodec[int8](t, math.MinInt8, -1, 0, 1, math.MaxInt8)
+	checkKeyCodec[int16](t, math.MinInt16, -300, 0, 300, math.MaxInt16)
+	checkKeyCodec[int32](t, math.MinInt32, -1, 0, 1, math.MaxInt32)
+	checkKeyCodec[uint8](t, 0, 1, math.MaxUint8)
+	checkKeyCodec[uint32](t, 0, 1, 256, math.MaxUint32)
+	checkKeyCodec[uint64](t, 0, 1, math.MaxUint64)
+	checkKeyCodec(t, math.NaN(), math.Inf(-1), -math.MaxFloat64, -1.5, -math.SmallestNonzeroFloat64,
+		math.Copysign(0, -1), 0, math.SmallestNonzeroFloat64, 1.5, math.MaxFloat64, math.Inf(1))
+	checkKeyCodec(t, float32(math.NaN()), float32(math.Inf(-1)), -1.25, 0, 1e-40, 1.25, float32(math.Inf(1)))
+	checkKeyCodec(t, "", "a", "ab", "b", "\xff")
+	checkKeyCodec[name](t, "x", "y")
+}
+
+func TestKeyCodec_Malformed(t *testing.T) {
+	if _, err := odmap.KeyCodec[int32]().Decode([]byte{1, 2}); err != odmap.ErrCodec {
+		t.Fatalf("got %v, want ErrCodec", err)
+	}
+}
+
+func TestBytesCodec_Copies(t *testing.T) {
+	b := []byte("value")
+	v, _ := odmap.BytesCodec[[]byte]().Decode(b)
+	b[0] = 'x'
+	if string(v) != "value" {
+		t.Fatalf("got %s, the decoded value aliases its encoding", v)
+	}
+}
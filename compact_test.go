@@ -0,0 +1,35 @@
+package odmap_test
+
+import (
+	"testing"
+
+	odmap "github.com/RealFax/order-map"
+)
+
+func TestOrderedMap_Compact(t *testing.T) {
+	m := newMap[int, int](odmap.WithCompactionThreshold[int, int](0))
+	for i := 0; i < 1000; i++ {
+		m.Store(i, i)
+	}
+	for i := 0; i < 1000; i += 2 {
+		m.Delete(i)
+	}
+
+	if s := m.Stats(); s.Entries-s.Tombstones != 500 {
+		t.Fatalf("got %+v, want 500 live keys", s)
+	}
+	m.Compact()
+	if s := m.Stats(); s.Entries != 500 || s.Tombstones != 0 {
+		t.Fatalf("got %+v after compaction", s)
+	}
+
+	for i := 0; i < 1000; i++ {
+		if v, ok := m.Load(i); ok != (i%2 == 1) || (ok && v != i) {
+			t.Fatalf("key %d: got %d, %v", i, v, ok)
+		}
+	}
+	m.Store(0, 1)
+	if v, _ := m.Load(0); v != 1 {
+		t.Fatalf("compacted key 0 was not stored again, got %d", v)
+	}
+}
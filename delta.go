@@ -0,0 +1,250 @@
+package odmap
+
+import (
+	"cmp"
+	"io"
+	"slices"
+)
+
+// Checkpoint marks a point in the writes of a tracked map, a delta holds the keys
+// written after one
+type Checkpoint struct {
+	seq uint64
+}
+
+// TrackedMap is a map that tracks the keys written to it so that the changes since a
+// checkpoint can be written as a delta. A base snapshot and the chain of deltas that
+// follow it restore the map, CompactSnapshot folds them into a single snapshot.
+// Range copies every pair first in the default build.
+//
+// A delta is ordered by cmp.Compare, the chain of a map created WithComparer can be
+// replayed by ApplyDelta but not compacted.
+type TrackedMap[K cmp.Ordered, V any] struct {
+	hookedMap[K, V]
+	keys   Codec[K]
+	values Codec[V]
+
+	// the fields below are guarded by mu
+	seq     uint64
+	changes map[K]uint64
+}
+
+// NewTracked returns a tracked map created with opts
+func NewTracked[K cmp.Ordered, V any](keys Codec[K], values Codec[V], opts ...Option[K, V]) *TrackedMap[K, V] {
+	t := &TrackedMap[K, V]{keys: keys, values: values, changes: make(map[K]uint64)}
+	t.hookedMap = hookedMap[K, V]{m: newMap[K, V](opts...), hook: t.track}
+	return t
+}
+
+// track records the write of key, t.mu must be held
+func (t *TrackedMap[K, V]) track(_ byte, key K, _ V) {
+	t.seq++
+	t.changes[key] = t.seq
+}
+
+// Checkpoint returns the current checkpoint. The checkpoint a chain starts from is
+// taken before its base snapshot is written, so that the writes racing the snapshot
+// are in the first delta.
+func (t *TrackedMap[K, V]) Checkpoint() Checkpoint {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return Checkpoint{seq: t.seq}
+}
+
+// WriteDelta writes to w the keys written since the checkpoint since, with their
+// values or as deleted, and returns the checkpoint the delta reaches. The changes up
+// to since are forgotten, no delta can be written from an earlier checkpoint anymore.
+func (t *TrackedMap[K, V]) WriteDelta(since Checkpoint, w io.Writer, opts ...SnapshotOption) (Checkpoint, error) {
+	type change struct {
+		op    byte
+		key   K
+		value V
+	}
+
+	t.mu.Lock()
+	reached := Checkpoint{seq: t.seq}
+	changes := make([]change, 0, len(t.changes))
+	for key, seq := range t.changes {
+		if seq <= since.seq {
+			delete(t.changes, key)
+			continue
+		}
+		c := change{op: logDelete, key: key}
+		if value, ok := t.m.Load(key); ok {
+			c.op, c.value = logStore, value
+		}
+		changes = append(changes, c)
+	}
+	t.mu.Unlock()
+
+	slices.SortFunc(changes, func(a, b change) int {
+		return cmp.Compare(a.key, b.key)
+	})
+	sw, err := newSnapshotWriter(w, deltaMagic, opts)
+	if err != nil {
+		return Checkpoint{}, err
+	}
+	var key, value []byte
+	for _, c := range changes {
+		if key, err = t.keys.Append(key[:0], c.key); err != nil {
+			return Checkpoint{}, err
+		}
+		value = value[:0]
+		if c.op == logStore {
+			if value, err = t.values.Append(value, c.value); err != nil {
+				return Checkpoint{}, err
+			}
+		}
+		if err = sw.add(c.op, key, value); err != nil {
+			return Checkpoint{}, err
+		}
+	}
+	if err = sw.close(); err != nil {
+		return Checkpoint{}, err
+	}
+	return reached, nil
+}
+
+// ApplyDelta reads a delta from r and applies it atomically, nothing is applied if
+// the delta is malformed. Its keys are tracked as if they were written.
+func (t *TrackedMap[K, V]) ApplyDelta(r io.Reader) error {
+	var b Batch[K, V]
+	if err := readDelta(r, t.keys, t.values, &b); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.m.Apply(&b); err != nil {
+		return err
+	}
+	for _, op := range b.ops {
+		t.track(0, op.key, op.value)
+	}
+	return nil
+}
+
+// readDelta adds the writes of the delta read from r to b
+func readDelta[K cmp.Ordered, V any](r io.Reader, keys Codec[K], values Codec[V], b *Batch[K, V]) error {
+	sr, err := newSnapshotReader(r, deltaMagic)
+	if err != nil {
+		return err
+	}
+	for {
+		op, key, value, err := sr.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		k, err := keys.Decode(key)
+		if err != nil {
+			return err
+		}
+		switch op {
+		case logStore:
+			v, err := values.Decode(value)
+			if err != nil {
+				return err
+			}
+			b.Put(k, v)
+		case logDelete:
+			b.Delete(k)
+		default:
+			return ErrSnapshotFormat
+		}
+	}
+}
+
+// CompactSnapshot writes to w the snapshot of the map restored by base followed by
+// deltas, oldest first. It streams every input once and holds a pair of each at a
+// time, the keys are compared by cmp.Compare once decoded.
+func CompactSnapshot[K cmp.Ordered](w io.Writer, keys Codec[K], base io.Reader, deltas []io.Reader, opts ...SnapshotOption) error {
+	type head struct {
+		sr    *snapshotReader
+		op    byte
+		key   K
+		raw   []byte
+		value []byte
+		// started is set once key holds a pair
+		started, done bool
+	}
+
+	heads := make([]head, 0, 1+len(deltas))
+	for i, r := range append([]io.Reader{base}, deltas...) {
+		magic := deltaMagic
+		if i == 0 {
+			magic = snapshotMagic
+		}
+		sr, err := newSnapshotReader(r, magic)
+		if err != nil {
+			return err
+		}
+		heads = append(heads, head{sr: sr})
+	}
+	advance := func(h *head) error {
+		var err error
+		h.op, h.raw, h.value, err = h.sr.next()
+		if err == io.EOF {
+			h.done = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !h.sr.delta {
+			h.op = logStore
+		} else if h.op != logStore && h.op != logDelete {
+			return ErrSnapshotFormat
+		}
+		prev, started := h.key, h.started
+		if h.key, err = keys.Decode(h.raw); err != nil {
+			return err
+		}
+		if started && cmp.Compare(prev, h.key) >= 0 {
+			return ErrKeyOrder
+		}
+		h.started = true
+		return nil
+	}
+	for i := range heads {
+		if err := advance(&heads[i]); err != nil {
+			return err
+		}
+	}
+
+	sw, err := newSnapshotWriter(w, snapshotMagic, opts)
+	if err != nil {
+		return err
+	}
+	for {
+		// the newest input holding the least key wins it
+		newest := -1
+		for i := range heads {
+			if !heads[i].done && (newest < 0 || cmp.Compare(heads[i].key, heads[newest].key) <= 0) {
+				newest = i
+			}
+		}
+		if newest < 0 {
+			break
+		}
+
+		h := &heads[newest]
+		if h.op == logStore {
+			if err = sw.add(0, h.raw, h.value); err != nil {
+				return err
+			}
+		}
+		key := h.key
+		for i := range heads {
+			if !heads[i].done && cmp.Compare(heads[i].key, key) == 0 {
+				if err = advance(&heads[i]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return sw.close()
+}
@@ -0,0 +1,198 @@
+package odmap_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"sync"
+	"testing"
+
+	odmap "github.com/RealFax/order-map"
+)
+
+// restore returns the map restored by base and the deltas following it
+func restore(t *testing.T, base []byte, deltas [][]byte) *odmap.TrackedMap[int, int] {
+	t.Helper()
+	m, err := odmap.ReadSnapshot(bytes.NewReader(base), odmap.KeyCodec[int](), odmap.KeyCodec[int]())
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := odmap.NewTracked[int, int](odmap.KeyCodec[int](), odmap.KeyCodec[int]())
+	m.Range(func(key, value int) bool {
+		restored.Store(key, value)
+		return true
+	})
+	for _, delta := range deltas {
+		if err = restored.ApplyDelta(bytes.NewReader(delta)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return restored
+}
+
+func checkSame(t *testing.T, got, want interface{ MarshalJSON() ([]byte, error) }) {
+	t.Helper()
+	g, _ := got.MarshalJSON()
+	w, _ := want.MarshalJSON()
+	if !bytes.Equal(g, w) {
+		t.Fatalf("got %.200s, want %.200s", g, w)
+	}
+}
+
+func TestDelta_Chain(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	m := odmap.NewTracked[int, int](odmap.KeyCodec[int](), odmap.KeyCodec[int]())
+	for i := 0; i < 300; i++ {
+		m.Store(r.Intn(500), i)
+	}
+
+	checkpoint := m.Checkpoint()
+	var base bytes.Buffer
+	if err := odmap.WriteSnapshot(&base, m, odmap.KeyCodec[int](), odmap.KeyCodec[int]()); err != nil {
+		t.Fatal(err)
+	}
+
+	var deltas [][]byte
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 100; i++ {
+			switch key := r.Intn(500); r.Intn(4) {
+			case 0:
+				m.Delete(key)
+			case 1:
+				m.CompareAndSwap(key, i, -i)
+			default:
+				m.Store(key, round*1000+i)
+			}
+		}
+
+		var delta bytes.Buffer
+		next, err := m.WriteDelta(checkpoint, &delta, odmap.WithBlockSize(64))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkpoint = next
+		deltas = append(deltas, delta.Bytes())
+		checkSame(t, restore(t, base.Bytes(), deltas), m)
+	}
+
+	// the changes up to the last checkpoint are forgotten
+	var delta bytes.Buffer
+	if _, err := m.WriteDelta(checkpoint, &delta); err != nil {
+		t.Fatal(err)
+	}
+	empty := odmap.NewTracked[int, int](odmap.KeyCodec[int](), odmap.KeyCodec[int]())
+	if err := empty.ApplyDelta(&delta); err != nil {
+		t.Fatal(err)
+	}
+	if got := countPairs(empty); got != 0 {
+		t.Fatalf("empty delta applied %d pairs", got)
+	}
+
+	readers := make([]io.Reader, len(deltas))
+	for i, delta := range deltas {
+		readers[i] = bytes.NewReader(delta)
+	}
+	var compacted bytes.Buffer
+	err := odmap.CompactSnapshot(&compacted, odmap.KeyCodec[int](), bytes.NewReader(base.Bytes()), readers,
+		odmap.WithCompression(odmap.CompressionGzip))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSame(t, restore(t, compacted.Bytes(), nil), m)
+}
+
+func TestDelta_Concurrent(t *testing.T) {
+	m := odmap.NewTracked[int, int](odmap.KeyCodec[int](), odmap.KeyCodec[int]())
+	checkpoint := m.Checkpoint()
+	var base bytes.Buffer
+	if err := odmap.WriteSnapshot(&base, m, odmap.KeyCodec[int](), odmap.KeyCodec[int]()); err != nil {
+		t.Fatal(err)
+	}
+
+	const writers = 4
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < 20000; i += writers {
+				m.Store(i%500, i)
+				if i%7 == 0 {
+					m.Delete(i % 500)
+				}
+			}
+		}(w)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	// the deltas and the reads race the writes
+	var deltas [][]byte
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		m.Load(250)
+		countPairs(m)
+
+		var delta bytes.Buffer
+		var err error
+		if checkpoint, err = m.WriteDelta(checkpoint, &delta); err != nil {
+			t.Fatal(err)
+		}
+		deltas = append(deltas, delta.Bytes())
+	}
+	checkSame(t, restore(t, base.Bytes(), deltas), m)
+}
+
+func countPairs(m *odmap.TrackedMap[int, int]) int {
+	n := 0
+	m.Range(func(int, int) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+func TestDelta_Malformed(t *testing.T) {
+	m := odmap.NewTracked[int, int](odmap.KeyCodec[int](), odmap.KeyCodec[int]())
+	checkpoint := m.Checkpoint()
+	for i := 0; i < 100; i++ {
+		m.Store(i, i)
+	}
+	m.Delete(7)
+
+	var delta bytes.Buffer
+	if _, err := m.WriteDelta(checkpoint, &delta); err != nil {
+		t.Fatal(err)
+	}
+	data := delta.Bytes()
+
+	for _, damaged := range [][]byte{
+		data[:len(data)-1],
+		append(append([]byte(nil), data[:20]...), append([]byte{data[20] ^ 1}, data[21:]...)...),
+	} {
+		target := odmap.NewTracked[int, int](odmap.KeyCodec[int](), odmap.KeyCodec[int]())
+		if err := target.ApplyDelta(bytes.NewReader(damaged)); !errors.Is(err, odmap.ErrSnapshotFormat) {
+			t.Fatalf("got %v, want ErrSnapshotFormat", err)
+		}
+		if got := countPairs(target); got != 0 {
+			t.Fatalf("malformed delta applied %d pairs", got)
+		}
+	}
+
+	// a snapshot is not a delta
+	var base bytes.Buffer
+	if err := odmap.WriteSnapshot(&base, m, odmap.KeyCodec[int](), odmap.KeyCodec[int]()); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ApplyDelta(&base); !errors.Is(err, odmap.ErrSnapshotFormat) {
+		t.Fatalf("got %v, want ErrSnapshotFormat", err)
+	}
+}
@@ -0,0 +1,491 @@
+package odmap
+
+import (
+	"bufio"
+	"cmp"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+var (
+	ErrClosed     = errors.New("odmap: map is closed")
+	ErrLogCorrupt = errors.New("odmap: corrupt log record")
+)
+
+// SyncPolicy decides when the log of a durable map is flushed to stable storage
+type SyncPolicy int
+
+const (
+	// SyncAlways syncs the log before every write returns, no acknowledged write is
+	// lost by a crash
+	SyncAlways SyncPolicy = iota
+	// SyncInterval syncs the log periodically, a crash of the machine loses the
+	// writes of the last interval at most
+	SyncInterval
+	// SyncNever leaves the syncs to the system, a crash of the process loses nothing
+	// but one of the machine may
+	SyncNever
+)
+
+type durableConfig struct {
+	policy   SyncPolicy
+	interval time.Duration
+	keys     KeyProvider
+}
+
+type DurableOption func(c *durableConfig)
+
+// WithSync sets the sync policy of the log, SyncAlways by default
+func WithSync(policy SyncPolicy) DurableOption {
+	return func(c *durableConfig) {
+		c.policy = policy
+	}
+}
+
+// WithSyncInterval syncs the log every d, a second by default. An interval of 0 or
+// less syncs every write as SyncAlways does.
+func WithSyncInterval(d time.Duration) DurableOption {
+	return func(c *durableConfig) {
+		if d <= 0 {
+			c.policy = SyncAlways
+			return
+		}
+		c.policy, c.interval = SyncInterval, d
+	}
+}
+
+// WithLogEncryption encrypts the records of the log with the current key of keys
+func WithLogEncryption(keys KeyProvider) DurableOption {
+	return func(c *durableConfig) {
+		c.keys = keys
+	}
+}
+
+// The log is a sequence of records:
+//
+//	length   uint32, of the payload
+//	checksum uint32, CRC-32C of the length
+//	checksum uint32, CRC-32C of the length and the payload
+//	payload  op byte, uvarint key length, key, value
+//
+// all little-endian. A record cut short at the end of the file is the last write of
+// a crash and is dropped when the log is opened, so is one failing a checksum with
+// no intact record after it, the garbage or zeros a crash may leave at the end. A
+// record failing a checksum before an intact one is corruption, the log is not
+// opened: the length is checked before it is trusted, so that a damaged one cannot
+// pass a whole record for a torn one.
+//
+// The payload of an encrypted log is the id of the key that sealed it, a random nonce
+// of 12 bytes and the plain payload sealed by AES-GCM. It authenticates the index of
+// the record in the log, so that records cannot be altered, reordered or dropped but
+// from the end. A corrupt record fails with ErrAuthentication.
+const (
+	logStore byte = 1 + iota
+	logDelete
+)
+
+const (
+	logHeader = 12
+	logNonce  = 4 + 12
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// DurableMap is a map whose writes are appended to a write-ahead log before they are
+// acknowledged, the map is rebuilt from the log when it is opened again. Reads are
+// served by the map in memory, Range copies every pair first in the default build.
+//
+// A write the log fails to take is still applied in memory, the error is kept and
+// returned by Err, Sync and Close. No record is appended after it, so that the log
+// can be opened again with the writes before the failure.
+type DurableMap[K cmp.Ordered, V any] struct {
+	hookedMap[K, V]
+	keys   Codec[K]
+	values Codec[V]
+	policy SyncPolicy
+	path   string
+
+	// the fields below are guarded by mu, which orders the records of the log as the
+	// writes of the map
+	file *os.File
+	// ciphers encrypts the records if the log is, seq is the index of the next one
+	ciphers *ciphers
+	seq     uint64
+	key     []byte
+	record  []byte
+	err     error
+	closed  bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// OpenDurable opens the log at path, creating it if needed, and replays it
+func OpenDurable[K cmp.Ordered, V any](path string, keys Codec[K], values Codec[V], opts ...DurableOption) (*DurableMap[K, V], error) {
+	c := durableConfig{interval: time.Second}
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	d := &DurableMap[K, V]{keys: keys, values: values, policy: c.policy, path: path, file: file}
+	if c.keys != nil {
+		d.ciphers = newCiphers(c.keys)
+	}
+	d.hookedMap = hookedMap[K, V]{m: newMap[K, V](), hook: d.log}
+	if err = d.replay(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if c.policy == SyncInterval {
+		d.stop, d.done = make(chan struct{}), make(chan struct{})
+		go d.syncEvery(c.interval)
+	}
+	return d, nil
+}
+
+// replay applies the records of the log and drops a torn one ending it
+func (d *DurableMap[K, V]) replay() error {
+	info, err := d.file.Stat()
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(d.file)
+	var (
+		offset  int64
+		header  [logHeader]byte
+		payload []byte
+	)
+	// a header cut short ends the log like a payload cut short
+	for info.Size()-offset >= logHeader {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return err
+		}
+		if crc32.Checksum(header[:4], castagnoli) != binary.LittleEndian.Uint32(header[4:]) {
+			// a damaged length could pass a whole record for a torn one
+			if err := d.checkTail(offset, info.Size()); err != nil {
+				return err
+			}
+			break
+		}
+		n := binary.LittleEndian.Uint32(header[:])
+		end := offset + logHeader + int64(n)
+		if end > info.Size() {
+			break
+		}
+		payload = slices.Grow(payload[:0], int(n))[:n]
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return err
+		}
+		if crc32.Update(crc32.Checksum(header[:4], castagnoli), castagnoli, payload) != binary.LittleEndian.Uint32(header[8:]) {
+			// the records after it were acknowledged, they are not dropped with it
+			if err := d.checkTail(offset, info.Size()); err != nil {
+				return err
+			}
+			break
+		}
+		plain, err := d.open(payload)
+		if err != nil {
+			return err
+		}
+		if err := d.apply(plain); err != nil {
+			return err
+		}
+		offset = end
+		d.seq++
+	}
+
+	if err := d.file.Truncate(offset); err != nil {
+		return err
+	}
+	_, err = d.file.Seek(offset, io.SeekStart)
+	return err
+}
+
+// checkTail returns the error of the record failing its checksum at offset unless no
+// intact record follows it up to size, the record is then torn
+func (d *DurableMap[K, V]) checkTail(offset, size int64) error {
+	tail := make([]byte, size-offset)
+	if _, err := d.file.ReadAt(tail, offset); err != nil {
+		return err
+	}
+	for i := 1; len(tail)-i >= logHeader; i++ {
+		record := tail[i:]
+		crc := crc32.Checksum(record[:4], castagnoli)
+		if crc != binary.LittleEndian.Uint32(record[4:]) {
+			continue
+		}
+		n := uint64(binary.LittleEndian.Uint32(record))
+		if n > uint64(len(record)-logHeader) {
+			continue
+		}
+		if crc32.Update(crc, castagnoli, record[logHeader:logHeader+n]) == binary.LittleEndian.Uint32(record[8:]) {
+			return d.corrupt()
+		}
+	}
+	return nil
+}
+
+// corrupt returns the error of a record failing its checksum
+func (d *DurableMap[K, V]) corrupt() error {
+	if d.ciphers != nil {
+		return ErrAuthentication
+	}
+	return ErrLogCorrupt
+}
+
+// open returns the plain payload of a record
+func (d *DurableMap[K, V]) open(payload []byte) ([]byte, error) {
+	if d.ciphers == nil {
+		return payload, nil
+	}
+	if len(payload) < logNonce {
+		return nil, ErrAuthentication
+	}
+	aead, err := d.ciphers.get(binary.LittleEndian.Uint32(payload))
+	if err != nil {
+		return nil, err
+	}
+	plain, err := aead.Open(nil, payload[4:logNonce], payload[logNonce:], binary.LittleEndian.AppendUint64(nil, d.seq))
+	if err != nil {
+		return nil, ErrAuthentication
+	}
+	return plain, nil
+}
+
+func (d *DurableMap[K, V]) apply(payload []byte) error {
+	n, w := binary.Uvarint(payload[min(1, len(payload)):])
+	if len(payload) == 0 || w <= 0 || n > uint64(len(payload)-1-w) {
+		return ErrCodec
+	}
+	op, rest := payload[0], payload[1+w:]
+	key, err := d.keys.Decode(rest[:n])
+	if err != nil {
+		return err
+	}
+
+	switch op {
+	case logStore:
+		value, err := d.values.Decode(rest[n:])
+		if err != nil {
+			return err
+		}
+		d.m.Store(key, value)
+	case logDelete:
+		d.m.Delete(key)
+	default:
+		return ErrCodec
+	}
+	return nil
+}
+
+// log appends a record of the write, d.mu must be held
+func (d *DurableMap[K, V]) log(op byte, key K, value V) {
+	if d.closed {
+		d.fail(ErrClosed)
+		return
+	}
+	if d.err != nil {
+		// the record of the failed write may be partly in the log
+		return
+	}
+
+	var err error
+	if d.record, err = d.appendRecord(d.record[:0], op, key, value); err != nil {
+		d.fail(err)
+		return
+	}
+	if _, err = d.file.Write(d.record); err != nil {
+		d.fail(err)
+		return
+	}
+	if d.policy == SyncAlways {
+		d.fail(d.file.Sync())
+	}
+}
+
+// appendRecord appends the record of a write to b as the next of the log
+func (d *DurableMap[K, V]) appendRecord(b []byte, op byte, key K, value V) ([]byte, error) {
+	var err error
+	if d.key, err = d.keys.Append(d.key[:0], key); err != nil {
+		return b, err
+	}
+	start := len(b)
+	b = append(b, make([]byte, logHeader)...)
+	b = append(binary.AppendUvarint(append(b, op), uint64(len(d.key))), d.key...)
+	if op == logStore {
+		if b, err = d.values.Append(b, value); err != nil {
+			return b, err
+		}
+	}
+	if d.ciphers != nil {
+		if b, err = d.seal(b, start+logHeader); err != nil {
+			return b, err
+		}
+	}
+
+	binary.LittleEndian.PutUint32(b[start:], uint32(len(b)-start-logHeader))
+	crc := crc32.Checksum(b[start:start+4], castagnoli)
+	binary.LittleEndian.PutUint32(b[start+4:], crc)
+	binary.LittleEndian.PutUint32(b[start+8:], crc32.Update(crc, castagnoli, b[start+logHeader:]))
+	d.seq++
+	return b, nil
+}
+
+// seal encrypts the payload of the record starting b at offset, with the current key
+func (d *DurableMap[K, V]) seal(b []byte, offset int) ([]byte, error) {
+	id, aead, err := d.ciphers.current()
+	if err != nil {
+		return b, err
+	}
+	nonce := make([]byte, logNonce)
+	binary.LittleEndian.PutUint32(nonce, id)
+	if _, err = rand.Read(nonce[4:]); err != nil {
+		return b, err
+	}
+	plain := append([]byte(nil), b[offset:]...)
+	b = append(b[:offset], nonce...)
+	return aead.Seal(b, nonce[4:], plain, binary.LittleEndian.AppendUint64(nil, d.seq)), nil
+}
+
+// fail keeps the first error of the log
+func (d *DurableMap[K, V]) fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+}
+
+func (d *DurableMap[K, V]) syncEvery(interval time.Duration) {
+	defer close(d.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			_ = d.Sync()
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+// Err returns the first error the log met, without syncing it
+func (d *DurableMap[K, V]) Err() error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.err
+}
+
+// Sync flushes the log to stable storage and returns the first error the log met
+func (d *DurableMap[K, V]) Sync() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.closed {
+		d.fail(d.file.Sync())
+	}
+	return d.err
+}
+
+// Rewrite replaces the log by the records of the pairs the map holds, dropping those
+// of the writes they overwrote. The records of an encrypted log are sealed with the
+// current key, the keys sealing the log before are no longer needed once it returns.
+func (d *DurableMap[K, V]) Rewrite() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return ErrClosed
+	}
+
+	if err := d.rewriteLocked(); err != nil {
+		return err
+	}
+	// the rename only survives a crash once the directory is synced
+	return syncDir(filepath.Dir(d.path))
+}
+
+// rewriteLocked renames the new log over the old one, d.mu must be held
+func (d *DurableMap[K, V]) rewriteLocked() (err error) {
+	f, err := os.CreateTemp(filepath.Dir(d.path), filepath.Base(d.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	seq, w := d.seq, bufio.NewWriter(f)
+	defer func() {
+		if err != nil {
+			d.seq = seq
+		}
+	}()
+	d.seq = 0
+	d.m.Range(func(key K, value V) bool {
+		if d.record, err = d.appendRecord(d.record[:0], logStore, key, value); err != nil {
+			return false
+		}
+		_, err = w.Write(d.record)
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = os.Rename(f.Name(), d.path); err != nil {
+		return err
+	}
+	d.fail(d.file.Close())
+	d.file = f
+	return nil
+}
+
+// syncDir flushes the entries of dir to stable storage
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// Close syncs and closes the log, the map must not be written to afterwards. Closing
+// it again does nothing and returns nil.
+func (d *DurableMap[K, V]) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return nil
+	}
+	d.closed = true
+	if d.stop != nil {
+		// the syncing goroutine takes mu, it is stopped without it
+		d.mu.Unlock()
+		close(d.stop)
+		<-d.done
+		d.mu.Lock()
+	}
+	d.fail(d.file.Sync())
+	d.fail(d.file.Close())
+	return d.err
+}
@@ -0,0 +1,264 @@
+package odmap_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	odmap "github.com/RealFax/order-map"
+)
+
+func openDurable(t *testing.T, path string, opts ...odmap.DurableOption) *odmap.DurableMap[int, string] {
+	t.Helper()
+	d, err := odmap.OpenDurable(path, odmap.KeyCodec[int](), odmap.BytesCodec[string](), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestDurableMap_Replay(t *testing.T) {
+	policies := map[string]odmap.DurableOption{
+		"always":   odmap.WithSync(odmap.SyncAlways),
+		"interval": odmap.WithSyncInterval(time.Millisecond),
+		"never":    odmap.WithSync(odmap.SyncNever),
+	}
+	for name, policy := range policies {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "log")
+			d := openDurable(t, path, policy)
+			model := map[int]string{}
+			r := rand.New(rand.NewSource(1))
+
+			for i := 0; i < 2000; i++ {
+				key, value := r.Intn(100), string(rune('a'+i%26))
+				switch r.Intn(6) {
+				case 0:
+					d.Delete(key)
+					delete(model, key)
+				case 1:
+					if d.CompareAndSwap(key, model[key], value) {
+						model[key] = value
+					}
+				case 2:
+					if d.CompareAndDelete(key, model[key]) {
+						delete(model, key)
+					}
+				case 3:
+					if _, loaded := d.LoadOrStore(key, value); !loaded {
+						model[key] = value
+					}
+				case 4:
+					d.Swap(key, value)
+					model[key] = value
+				default:
+					d.Store(key, value)
+					model[key] = value
+				}
+			}
+			if err := d.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			d = openDurable(t, path, policy)
+			defer d.Close()
+			checkDurable(t, d, model)
+		})
+	}
+}
+
+// checkDurable fails unless d holds exactly the pairs of model
+func checkDurable(t *testing.T, d *odmap.DurableMap[int, string], model map[int]string) {
+	t.Helper()
+	n := 0
+	d.Range(func(int, string) bool {
+		n++
+		return true
+	})
+	if n != len(model) {
+		t.Fatalf("got %d pairs, want %d", n, len(model))
+	}
+	for key, want := range model {
+		if v, ok := d.Load(key); !ok || v != want {
+			t.Fatalf("Load(%d): got %s, %v, want %s", key, v, ok, want)
+		}
+	}
+}
+
+func TestDurableMap_Concurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	d := openDurable(t, path, odmap.WithSync(odmap.SyncNever))
+
+	const (
+		writers = 4
+		keys    = 1000
+	)
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < keys; i += writers {
+				d.Store(i, strconv.Itoa(i))
+			}
+		}(w)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	// the reads race the writes, which the map in memory does not allow by itself
+	r := rand.New(rand.NewSource(1))
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		key := r.Intn(keys)
+		if v, ok := d.Load(key); ok && v != strconv.Itoa(key) {
+			t.Fatalf("Load(%d): got %s", key, v)
+		}
+		d.Floor(key)
+		d.Contains(key)
+		d.Len()
+		prev := -1
+		d.Range(func(key int, _ string) bool {
+			if key <= prev {
+				t.Fatalf("Range: got %d after %d", key, prev)
+			}
+			prev = key
+			return true
+		})
+	}
+
+	model := make(map[int]string, keys)
+	for i := 0; i < keys; i++ {
+		model[i] = strconv.Itoa(i)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	d = openDurable(t, path)
+	defer d.Close()
+	checkDurable(t, d, model)
+}
+
+// logHeader is the size of the header of a log record, its length and checksums
+const logHeader = 12
+
+// writeLog returns the log of two stores, with the offset of its second record
+func writeLog(t *testing.T) (data []byte, second int) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "log")
+	d := openDurable(t, path)
+	d.Store(1, "one")
+	d.Store(2, "two")
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data, logHeader + int(binary.LittleEndian.Uint32(data))
+}
+
+func TestDurableMap_TornRecord(t *testing.T) {
+	data, second := writeLog(t)
+
+	flip := func(offset int) []byte {
+		damaged := bytes.Clone(data)
+		damaged[offset] ^= 0x40
+		return damaged
+	}
+	for name, torn := range map[string][]byte{
+		"payload": data[:len(data)-2],
+		"header":  data[:second+logHeader/2],
+		// a last record failing a checksum is torn as well
+		"last payload":  flip(len(data) - 1),
+		"last checksum": flip(second + 8),
+		"last length":   flip(second + 3),
+		"zeros":         append(bytes.Clone(data[:second]), make([]byte, len(data)-second)...),
+	} {
+		t.Run(name, func(t *testing.T) {
+			damaged := filepath.Join(t.TempDir(), "log")
+			if err := os.WriteFile(damaged, torn, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			// the torn record is dropped and the log goes on after the first one
+			d := openDurable(t, damaged)
+			checkDurable(t, d, map[int]string{1: "one"})
+			d.Store(3, "three")
+			if err := d.Close(); err != nil {
+				t.Fatal(err)
+			}
+			d = openDurable(t, damaged)
+			defer d.Close()
+			checkDurable(t, d, map[int]string{1: "one", 3: "three"})
+		})
+	}
+}
+
+func TestDurableMap_CorruptRecord(t *testing.T) {
+	data, _ := writeLog(t)
+
+	// a record failing a checksum before an intact one is not a torn write
+	for name, offset := range map[string]int{
+		"payload":  logHeader,
+		"checksum": 8,
+		// a length pointing past the end of the file would drop the whole log as torn
+		"length": 3,
+	} {
+		t.Run(name, func(t *testing.T) {
+			damaged := bytes.Clone(data)
+			damaged[offset] ^= 0x40
+			path := filepath.Join(t.TempDir(), "log")
+			if err := os.WriteFile(path, damaged, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := odmap.OpenDurable(path, odmap.KeyCodec[int](), odmap.BytesCodec[string]()); !errors.Is(err, odmap.ErrLogCorrupt) {
+				t.Fatalf("got %v, want ErrLogCorrupt", err)
+			}
+			if info, err := os.Stat(path); err != nil || info.Size() != int64(len(data)) {
+				t.Fatalf("the log was truncated: %v", err)
+			}
+		})
+	}
+}
+
+func TestDurableMap_SyncIntervalZero(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	d := openDurable(t, path, odmap.WithSyncInterval(0))
+	d.Store(1, "one")
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	d = openDurable(t, path)
+	defer d.Close()
+	checkDurable(t, d, map[int]string{1: "one"})
+}
+
+func TestDurableMap_Closed(t *testing.T) {
+	d := openDurable(t, filepath.Join(t.TempDir(), "log"), odmap.WithSyncInterval(time.Millisecond))
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	d.Store(1, "one")
+	if err := d.Sync(); !errors.Is(err, odmap.ErrClosed) {
+		t.Fatalf("got %v, want ErrClosed", err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatalf("closed twice: got %v", err)
+	}
+}
@@ -0,0 +1,294 @@
+package odmap
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+)
+
+var (
+	ErrAuthentication = errors.New("odmap: encrypted data failed authentication")
+	ErrUnknownKey     = errors.New("odmap: unknown encryption key")
+)
+
+// KeyProvider supplies the AES keys, of 16, 24 or 32 bytes, that encrypt snapshots
+// and logs. Encrypted data names the key that encrypted it, so data written before a
+// rotation is still decrypted once another key is current.
+type KeyProvider interface {
+	// CurrentKey returns the key to encrypt with and its id
+	CurrentKey() (id uint32, key []byte, err error)
+	// Key returns the key of id
+	Key(id uint32) ([]byte, error)
+}
+
+// Keyring is a KeyProvider holding its keys in memory. The zero value holds no key.
+type Keyring struct {
+	mu      sync.RWMutex
+	keys    map[uint32][]byte
+	current uint32
+}
+
+// Rotate adds key as id and makes it current, the keys added before are kept
+func (k *Keyring) Rotate(id uint32, key []byte) error {
+	if _, err := aes.NewCipher(key); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.keys == nil {
+		k.keys = make(map[uint32][]byte)
+	}
+	k.keys[id], k.current = append([]byte(nil), key...), id
+	return nil
+}
+
+func (k *Keyring) CurrentKey() (uint32, []byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	key, ok := k.keys[k.current]
+	if !ok {
+		return 0, nil, ErrUnknownKey
+	}
+	return k.current, key, nil
+}
+
+func (k *Keyring) Key(id uint32) ([]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return key, nil
+}
+
+// ciphers caches the AES-GCM ciphers of the keys of a provider
+type ciphers struct {
+	keys  KeyProvider
+	aeads map[uint32]cipher.AEAD
+}
+
+func newCiphers(keys KeyProvider) *ciphers {
+	return &ciphers{keys: keys, aeads: make(map[uint32]cipher.AEAD)}
+}
+
+func (c *ciphers) current() (uint32, cipher.AEAD, error) {
+	id, key, err := c.keys.CurrentKey()
+	if err != nil {
+		return 0, nil, err
+	}
+	if aead, ok := c.aeads[id]; ok {
+		return id, aead, nil
+	}
+	aead, err := c.cache(id, key)
+	return id, aead, err
+}
+
+func (c *ciphers) get(id uint32) (cipher.AEAD, error) {
+	if aead, ok := c.aeads[id]; ok {
+		return aead, nil
+	}
+	key, err := c.keys.Key(id)
+	if err != nil {
+		return nil, err
+	}
+	return c.cache(id, key)
+}
+
+func (c *ciphers) cache(id uint32, key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	c.aeads[id] = aead
+	return aead, nil
+}
+
+// An encrypted stream starts with a header:
+//
+//	magic   [4]byte "ODME"
+//	version uint16
+//	unused  uint16
+//	key id  uint32
+//	nonce   [12]byte, random
+//
+// and is a sequence of frames, each of a little-endian uint32 length of its plaintext
+// with the top bit set on the last frame, then the plaintext sealed by AES-GCM. The
+// nonce of a frame is that of the header xored with its index, and it authenticates
+// the header and its length: frames cannot be altered, reordered, dropped or cut
+// without failing authentication.
+const (
+	encryptedMagic   = "ODME"
+	encryptedVersion = 1
+	encryptedHeader  = 24
+	lastFrame        = 1 << 31
+	frameSize        = 64 << 10
+)
+
+// encryptWriter encrypts the stream written to it, Close writes its last frame
+type encryptWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	header [encryptedHeader]byte
+	index  uint64
+	frame  []byte
+	closed bool
+}
+
+// NewEncryptWriter returns a writer encrypting to w with the current key of keys.
+// It must be closed to complete the stream, which does not close w.
+func NewEncryptWriter(w io.Writer, keys KeyProvider) (io.WriteCloser, error) {
+	id, aead, err := newCiphers(keys).current()
+	if err != nil {
+		return nil, err
+	}
+	e := &encryptWriter{w: w, aead: aead}
+	copy(e.header[:], encryptedMagic)
+	binary.LittleEndian.PutUint16(e.header[4:], encryptedVersion)
+	binary.LittleEndian.PutUint32(e.header[8:], id)
+	if _, err = rand.Read(e.header[12:]); err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(e.header[:]); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *encryptWriter) Write(b []byte) (int, error) {
+	if e.closed {
+		return 0, ErrClosed
+	}
+	// a full frame is sealed once more follows, so that the last frame holds the end of
+	// the stream and reading it through authenticates the last frame
+	n := len(b)
+	for len(b) > 0 {
+		if len(e.frame) == frameSize {
+			if err := e.seal(false); err != nil {
+				return 0, err
+			}
+		}
+		chunk := min(len(b), frameSize-len(e.frame))
+		e.frame, b = append(e.frame, b[:chunk]...), b[chunk:]
+	}
+	return n, nil
+}
+
+func (e *encryptWriter) Close() error {
+	if e.closed {
+		return ErrClosed
+	}
+	e.closed = true
+	return e.seal(true)
+}
+
+func (e *encryptWriter) seal(last bool) error {
+	length := uint32(len(e.frame))
+	if last {
+		length |= lastFrame
+	}
+	nonce, ad := frameNonce(e.header[12:], e.index), frameData(e.header[:], length)
+	out := binary.LittleEndian.AppendUint32(make([]byte, 0, 4+len(e.frame)+e.aead.Overhead()), length)
+	out = e.aead.Seal(out, nonce, e.frame, ad)
+	if _, err := e.w.Write(out); err != nil {
+		return err
+	}
+	e.frame, e.index = e.frame[:0], e.index+1
+	return nil
+}
+
+func frameNonce(base []byte, index uint64) []byte {
+	nonce := append([]byte(nil), base...)
+	binary.BigEndian.PutUint64(nonce[4:], binary.BigEndian.Uint64(nonce[4:])^index)
+	return nonce
+}
+
+func frameData(header []byte, length uint32) []byte {
+	return binary.LittleEndian.AppendUint32(append([]byte(nil), header...), length)
+}
+
+// decryptReader decrypts the stream read from it a frame at a time
+type decryptReader struct {
+	r      io.Reader
+	aead   cipher.AEAD
+	header [encryptedHeader]byte
+	index  uint64
+	sealed []byte
+	// plain holds the plaintext of the frame not read yet
+	plain []byte
+	last  bool
+}
+
+// NewDecryptReader returns a reader decrypting the stream written by an encrypt writer
+// to r, with the key of keys that encrypted it. A stream that was altered or cut short
+// fails with ErrAuthentication.
+func NewDecryptReader(r io.Reader, keys KeyProvider) (io.Reader, error) {
+	d := &decryptReader{r: r}
+	if _, err := io.ReadFull(r, d.header[:]); err != nil {
+		return nil, ErrAuthentication
+	}
+	if string(d.header[:4]) != encryptedMagic || binary.LittleEndian.Uint16(d.header[4:]) != encryptedVersion {
+		return nil, ErrAuthentication
+	}
+	aead, err := newCiphers(keys).get(binary.LittleEndian.Uint32(d.header[8:]))
+	if err != nil {
+		return nil, err
+	}
+	d.aead = aead
+	return d, nil
+}
+
+func (d *decryptReader) Read(b []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.last {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(b, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *decryptReader) open() error {
+	var prefix [4]byte
+	if _, err := io.ReadFull(d.r, prefix[:]); err != nil {
+		return frameError(err)
+	}
+	length := binary.LittleEndian.Uint32(prefix[:])
+	if length&^lastFrame > frameSize {
+		return ErrAuthentication
+	}
+
+	d.sealed = append(d.sealed[:0], make([]byte, int(length&^lastFrame)+d.aead.Overhead())...)
+	if _, err := io.ReadFull(d.r, d.sealed); err != nil {
+		return frameError(err)
+	}
+	plain, err := d.aead.Open(d.sealed[:0], frameNonce(d.header[12:], d.index), d.sealed, frameData(d.header[:], length))
+	if err != nil {
+		return ErrAuthentication
+	}
+	d.plain, d.last, d.index = plain, length&lastFrame != 0, d.index+1
+	return nil
+}
+
+// frameError reports a stream cut short as failing authentication
+func frameError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrAuthentication
+	}
+	return err
+}
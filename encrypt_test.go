@@ -0,0 +1,210 @@
+package odmap_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	odmap "github.com/RealFax/order-map"
+)
+
+func keyring(t *testing.T, ids ...uint32) *odmap.Keyring {
+	t.Helper()
+	var k odmap.Keyring
+	for _, id := range ids {
+		if err := k.Rotate(id, bytes.Repeat([]byte{byte(id)}, 32)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &k
+}
+
+func readEncrypted(r io.Reader, keys odmap.KeyProvider) (odmap.Map[string, string], error) {
+	plain, err := odmap.NewDecryptReader(r, keys)
+	if err != nil {
+		return nil, err
+	}
+	return odmap.ReadSnapshot(plain, odmap.KeyCodec[string](), odmap.BytesCodec[string]())
+}
+
+func TestEncryption_Snapshot(t *testing.T) {
+	m := newMap[string, string]()
+	for i := 0; i < 5000; i++ {
+		m.Store(fmt.Sprintf("key-%05d", i), fmt.Sprintf("secret-%d", i))
+	}
+
+	keys := keyring(t, 1)
+	for name, compression := range compressions {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := odmap.WriteSnapshot(&buf, m, odmap.KeyCodec[string](), odmap.BytesCodec[string](),
+				odmap.WithCompression(compression), odmap.WithEncryption(keys))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(buf.Bytes(), []byte("secret-")) {
+				t.Fatal("snapshot holds plaintext")
+			}
+			loaded, err := readEncrypted(&buf, keys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkSame(t, loaded, m)
+		})
+	}
+}
+
+func TestEncryption_Rotation(t *testing.T) {
+	m := newMap[string, string]()
+	m.Store("a", "1")
+
+	keys := keyring(t, 1)
+	var before bytes.Buffer
+	if err := odmap.WriteSnapshot(&before, m, odmap.KeyCodec[string](), odmap.BytesCodec[string](), odmap.WithEncryption(keys)); err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.Rotate(2, bytes.Repeat([]byte{2}, 16)); err != nil {
+		t.Fatal(err)
+	}
+	var after bytes.Buffer
+	if err := odmap.WriteSnapshot(&after, m, odmap.KeyCodec[string](), odmap.BytesCodec[string](), odmap.WithEncryption(keys)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, data := range [][]byte{before.Bytes(), after.Bytes()} {
+		loaded, err := readEncrypted(bytes.NewReader(data), keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkSame(t, loaded, m)
+	}
+	if _, err := readEncrypted(bytes.NewReader(before.Bytes()), keyring(t, 2)); !errors.Is(err, odmap.ErrUnknownKey) {
+		t.Fatalf("got %v, want ErrUnknownKey", err)
+	}
+	if err := keys.Rotate(3, []byte("short")); err == nil {
+		t.Fatal("Rotate accepted a key of 5 bytes")
+	}
+}
+
+func TestEncryption_Tampered(t *testing.T) {
+	m := newMap[string, string]()
+	// the snapshot spans several frames
+	for i := 0; i < 10000; i++ {
+		m.Store(fmt.Sprintf("key-%05d", i), fmt.Sprintf("secret-%d", i))
+	}
+	keys := keyring(t, 1)
+	var buf bytes.Buffer
+	if err := odmap.WriteSnapshot(&buf, m, odmap.KeyCodec[string](), odmap.BytesCodec[string](), odmap.WithEncryption(keys)); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	for i := 24; i < len(data); i += 997 {
+		damaged := bytes.Clone(data)
+		damaged[i] ^= 1
+		if _, err := readEncrypted(bytes.NewReader(damaged), keys); !errors.Is(err, odmap.ErrAuthentication) {
+			t.Fatalf("flipped byte %d: got %v, want ErrAuthentication", i, err)
+		}
+	}
+	for _, n := range []int{0, 10, 24, 30, len(data) / 2, len(data) - 1} {
+		if _, err := readEncrypted(bytes.NewReader(data[:n]), keys); !errors.Is(err, odmap.ErrAuthentication) {
+			t.Fatalf("cut at %d: got %v, want ErrAuthentication", n, err)
+		}
+	}
+}
+
+func TestEncryption_Log(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	keys := keyring(t, 1)
+	open := func(keys odmap.KeyProvider) (*odmap.DurableMap[int, string], error) {
+		return odmap.OpenDurable(path, odmap.KeyCodec[int](), odmap.BytesCodec[string](), odmap.WithLogEncryption(keys))
+	}
+
+	d, err := open(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	model := map[int]string{}
+	for i := 0; i < 100; i++ {
+		if i == 50 {
+			if err = keys.Rotate(2, bytes.Repeat([]byte{2}, 32)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		d.Store(i%30, fmt.Sprint("secret-", i))
+		model[i%30] = fmt.Sprint("secret-", i)
+	}
+	d.Delete(3)
+	delete(model, 3)
+	if err = d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("secret-")) {
+		t.Fatal("log holds plaintext")
+	}
+
+	// the records sealed before the rotation need the first key
+	if _, err = open(keyring(t, 2)); !errors.Is(err, odmap.ErrUnknownKey) {
+		t.Fatalf("got %v, want ErrUnknownKey", err)
+	}
+	if d, err = open(keys); err != nil {
+		t.Fatal(err)
+	}
+	checkDurable(t, d, model)
+	if err = d.Rewrite(); err != nil {
+		t.Fatal(err)
+	}
+	d.Store(100, "secret-100")
+	model[100] = "secret-100"
+	if err = d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// once rewritten, the log only needs the current key
+	if d, err = open(keyring(t, 2)); err != nil {
+		t.Fatal(err)
+	}
+	checkDurable(t, d, model)
+	if err = d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a record altered along with its checksum fails authentication, so does one
+	// dropped from the middle of the log
+	data, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := logHeader + int(binary.LittleEndian.Uint32(data))
+	altered := bytes.Clone(data)
+	altered[first-1] ^= 1
+	castagnoli := crc32.MakeTable(crc32.Castagnoli)
+	binary.LittleEndian.PutUint32(altered[8:], crc32.Update(crc32.Checksum(altered[:4], castagnoli), castagnoli, altered[logHeader:first]))
+	// a record altered without its checksum is not taken for a torn one
+	flipped := bytes.Clone(data)
+	flipped[first-1] ^= 1
+	// neither is one whose length was damaged to reach past the end of the file
+	length := bytes.Clone(data)
+	length[3] ^= 0x40
+	for name, damaged := range map[string][]byte{"altered": altered, "flipped": flipped, "length": length, "dropped": data[first:]} {
+		if err = os.WriteFile(path, damaged, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = open(keys); !errors.Is(err, odmap.ErrAuthentication) {
+			t.Fatalf("%s: got %v, want ErrAuthentication", name, err)
+		}
+		if info, err := os.Stat(path); err != nil || info.Size() != int64(len(damaged)) {
+			t.Fatalf("%s: the log was truncated: %v", name, err)
+		}
+	}
+}
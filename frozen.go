@@ -0,0 +1,207 @@
+package odmap
+
+import (
+	"cmp"
+	"encoding/json"
+)
+
+// FrozenMap is an immutable copy of the pairs of a map, made by Freeze. It keeps the
+// keys and the values in two parallel slices and searches them without any lock, so
+// it can be shared by any number of goroutines.
+//
+// The slices are sorted, or in Eytzinger order with FreezeEytzinger: the pairs are
+// then laid out as the breadth-first walk of a balanced search tree, the first steps
+// of every search read the same few cache lines.
+type FrozenMap[K cmp.Ordered, V any] struct {
+	compare func(K, K) int
+	keys    []K
+	values  []V
+	// ranks holds the rank of every pair in Eytzinger order, it is nil when sorted
+	ranks []int32
+}
+
+type freezeConfig struct {
+	eytzinger bool
+}
+
+type FreezeOption func(c *freezeConfig)
+
+// FreezeEytzinger lays the pairs out in Eytzinger order, which searches large maps
+// faster than sorted slices but scans them slower
+func FreezeEytzinger() FreezeOption {
+	return func(c *freezeConfig) {
+		c.eytzinger = true
+	}
+}
+
+// newFrozenMap returns the frozen map of sorted keys and values, which it takes
+// ownership of
+func newFrozenMap[K cmp.Ordered, V any](compare func(K, K) int, keys []K, values []V, opts []FreezeOption) *FrozenMap[K, V] {
+	var c freezeConfig
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	f := &FrozenMap[K, V]{compare: compare, keys: keys, values: values}
+	if !c.eytzinger {
+		return f
+	}
+
+	// the in-order walk of the tree visits the positions in key order
+	f.keys, f.values, f.ranks = make([]K, len(keys)), make([]V, len(values)), make([]int32, len(keys))
+	rank := 0
+	for p := f.first(); p != -1; p = f.next(p) {
+		f.keys[p], f.values[p], f.ranks[p] = keys[rank], values[rank], int32(rank)
+		rank++
+	}
+	return f
+}
+
+// Positions index the slices. In Eytzinger order the children of the node at
+// position p are at 2p+1 and 2p+2, -1 is the position of no pair.
+
+func (f *FrozenMap[K, V]) first() int {
+	if len(f.keys) == 0 {
+		return -1
+	}
+	if f.ranks == nil {
+		return 0
+	}
+	p := 0
+	for 2*p+1 < len(f.keys) {
+		p = 2*p + 1
+	}
+	return p
+}
+
+func (f *FrozenMap[K, V]) next(p int) int {
+	if f.ranks == nil {
+		if p++; p == len(f.keys) {
+			return -1
+		}
+		return p
+	}
+
+	if 2*p+2 < len(f.keys) {
+		for p = 2*p + 2; 2*p+1 < len(f.keys); p = 2*p + 1 {
+		}
+		return p
+	}
+	// climb while p is a right child, its parent is then the next pair
+	for p > 0 && p%2 == 0 {
+		p = (p - 1) / 2
+	}
+	if p == 0 {
+		return -1
+	}
+	return (p - 1) / 2
+}
+
+// seek returns the position of the first key not below key, nor at it when strict,
+// and the position before it
+func (f *FrozenMap[K, V]) seek(key K, strict bool) (before, bound int) {
+	before, bound = -1, -1
+	if f.ranks == nil {
+		lo, hi := 0, len(f.keys)
+		for lo < hi {
+			mid := int(uint(lo+hi) >> 1)
+			if c := f.compare(f.keys[mid], key); c > 0 || (!strict && c == 0) {
+				hi = mid
+			} else {
+				lo = mid + 1
+			}
+		}
+		if lo < len(f.keys) {
+			bound = lo
+		}
+		return lo - 1, bound
+	}
+
+	for p := 0; p < len(f.keys); {
+		if c := f.compare(f.keys[p], key); c > 0 || (!strict && c == 0) {
+			bound, p = p, 2*p+1
+		} else {
+			before, p = p, 2*p+2
+		}
+	}
+	return before, bound
+}
+
+func (f *FrozenMap[K, V]) rank(p int) int {
+	if p == -1 {
+		return len(f.keys)
+	}
+	if f.ranks == nil {
+		return p
+	}
+	return int(f.ranks[p])
+}
+
+func (f *FrozenMap[K, V]) Load(key K) (V, bool) {
+	_, p := f.seek(key, false)
+	if p == -1 || f.compare(f.keys[p], key) != 0 {
+		return empty[V](), false
+	}
+	return f.values[p], true
+}
+
+func (f *FrozenMap[K, V]) Contains(key K) bool {
+	_, ok := f.Load(key)
+	return ok
+}
+
+func (f *FrozenMap[K, V]) Len() int64 {
+	return int64(len(f.keys))
+}
+
+// Floor returns the pair with the greatest key less than or equal to key
+func (f *FrozenMap[K, V]) Floor(key K) (K, V, bool) {
+	p, _ := f.seek(key, true)
+	if p == -1 {
+		return empty[K](), empty[V](), false
+	}
+	return f.keys[p], f.values[p], true
+}
+
+// Ceiling returns the pair with the least key greater than or equal to key
+func (f *FrozenMap[K, V]) Ceiling(key K) (K, V, bool) {
+	_, p := f.seek(key, false)
+	if p == -1 {
+		return empty[K](), empty[V](), false
+	}
+	return f.keys[p], f.values[p], true
+}
+
+// Rank returns the number of keys less than key
+func (f *FrozenMap[K, V]) Rank(key K) int {
+	_, p := f.seek(key, false)
+	return f.rank(p)
+}
+
+// Range calls fn for every pair in key order
+func (f *FrozenMap[K, V]) Range(fn func(key K, value V) bool) {
+	for p := f.first(); p != -1; p = f.next(p) {
+		if !fn(f.keys[p], f.values[p]) {
+			return
+		}
+	}
+}
+
+// Scan calls fn for every pair with a key in [lo, hi), in key order
+func (f *FrozenMap[K, V]) Scan(lo, hi K, fn func(key K, value V) bool) {
+	_, p := f.seek(lo, false)
+	for ; p != -1 && f.compare(f.keys[p], hi) < 0; p = f.next(p) {
+		if !fn(f.keys[p], f.values[p]) {
+			return
+		}
+	}
+}
+
+func (f *FrozenMap[K, V]) MarshalJSON() ([]byte, error) {
+	s := make([]Pair[K, V], 0, len(f.keys))
+	f.Range(func(key K, value V) bool {
+		s = append(s, Pair[K, V]{Key: key, Value: value})
+		return true
+	})
+	return json.Marshal(s)
+}
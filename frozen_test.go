@@ -0,0 +1,152 @@
+package odmap_test
+
+import (
+	"encoding/json"
+	"math/rand"
+	"slices"
+	"sync"
+	"testing"
+
+	odmap "github.com/RealFax/order-map"
+)
+
+var freezeLayouts = map[string][]odmap.FreezeOption{
+	"sorted":    nil,
+	"eytzinger": {odmap.FreezeEytzinger()},
+}
+
+func TestFrozenMap_Model(t *testing.T) {
+	for name, opts := range freezeLayouts {
+		t.Run(name, func(t *testing.T) {
+			// every size up to a few complete trees, to cover the partial last levels
+			for size := 0; size < 70; size++ {
+				m := newMap[int, int]()
+				keys := make([]int, 0, size)
+				for i := 0; i < size; i++ {
+					// even keys leave room to probe between them
+					keys = append(keys, 2*i)
+					m.Store(2*i, -i)
+				}
+
+				f := m.Freeze(opts...)
+				// the frozen map does not change with the map
+				m.Store(1, 1)
+				checkFrozen(t, f, keys)
+			}
+		})
+	}
+}
+
+// checkFrozen fails unless f holds the sorted keys, the value of every key k being -k/2
+func checkFrozen(t *testing.T, f *odmap.FrozenMap[int, int], keys []int) {
+	t.Helper()
+	if f.Len() != int64(len(keys)) {
+		t.Fatalf("got len %d, want %d", f.Len(), len(keys))
+	}
+	i := 0
+	f.Range(func(key, value int) bool {
+		if i >= len(keys) || key != keys[i] || value != -key/2 {
+			t.Fatalf("unexpected pair %d=%d", key, value)
+		}
+		i++
+		return true
+	})
+	if i != len(keys) {
+		t.Fatalf("got %d pairs, want %d", i, len(keys))
+	}
+
+	for probe := -1; probe <= 2*len(keys); probe++ {
+		rank, found := slices.BinarySearch(keys, probe)
+		if v, ok := f.Load(probe); ok != found || (ok && v != -probe/2) {
+			t.Fatalf("Load(%d): got %d, %v", probe, v, ok)
+		}
+		if got := f.Rank(probe); got != rank {
+			t.Fatalf("Rank(%d): got %d, want %d", probe, got, rank)
+		}
+
+		floor := rank - 1
+		if found {
+			floor = rank
+		}
+		if k, _, ok := f.Floor(probe); ok != (floor >= 0) || (ok && k != keys[floor]) {
+			t.Fatalf("Floor(%d): got %d, %v", probe, k, ok)
+		}
+		if k, _, ok := f.Ceiling(probe); ok != (rank < len(keys)) || (ok && k != keys[rank]) {
+			t.Fatalf("Ceiling(%d): got %d, %v", probe, k, ok)
+		}
+
+		var scanned []int
+		f.Scan(probe, probe+5, func(key, _ int) bool {
+			scanned = append(scanned, key)
+			return true
+		})
+		end, _ := slices.BinarySearch(keys, probe+5)
+		if want := keys[rank:end]; !slices.Equal(scanned, want) {
+			t.Fatalf("Scan(%d, %d): got %v, want %v", probe, probe+5, scanned, want)
+		}
+	}
+}
+
+func TestFrozenMap_MarshalJSON(t *testing.T) {
+	m := newMap[int, string]()
+	for _, key := range []int{3, 1, 2} {
+		m.Store(key, "v")
+	}
+	for name, opts := range freezeLayouts {
+		b, err := json.Marshal(m.Freeze(opts...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := `[{"key":1,"value":"v"},{"key":2,"value":"v"},{"key":3,"value":"v"}]`; string(b) != want {
+			t.Errorf("%s: got %s, want %s", name, b, want)
+		}
+	}
+}
+
+func TestFrozenMap_Concurrent(t *testing.T) {
+	m := newMap[int, int]()
+	for i := 0; i < 1000; i++ {
+		m.Store(i, i)
+	}
+	f := m.Freeze(odmap.FreezeEytzinger())
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < 1000; i += 4 {
+				if v, ok := f.Load(i); !ok || v != i {
+					t.Errorf("Load(%d): got %d, %v", i, v, ok)
+				}
+				if rank := f.Rank(i); rank != i {
+					t.Errorf("Rank(%d): got %d", i, rank)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+}
+
+func BenchmarkFrozenMap_Load(b *testing.B) {
+	const size = 1 << 20
+	m := newMap[int, int]()
+	for i := 0; i < size; i++ {
+		m.Store(i, i)
+	}
+	b.Run("map", func(b *testing.B) {
+		r := rand.New(rand.NewSource(1))
+		for i := 0; i < b.N; i++ {
+			m.Load(r.Intn(size))
+		}
+	})
+	for name, opts := range freezeLayouts {
+		f := m.Freeze(opts...)
+		b.Run(name, func(b *testing.B) {
+			r := rand.New(rand.NewSource(1))
+			for i := 0; i < b.N; i++ {
+				f.Load(r.Intn(size))
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package odmap
+
+import (
+	"cmp"
+	"sync"
+)
+
+// hookedMap serializes the writes to a map and passes every one that changed it to
+// hook, in the order they were applied. The reads share mu, the map of the default
+// build is not safe for concurrent use.
+type hookedMap[K cmp.Ordered, V any] struct {
+	m fullMap[K, V]
+	// hook is called with mu held
+	hook func(op byte, key K, value V)
+	mu   sync.RWMutex
+}
+
+func (h *hookedMap[K, V]) Store(key K, value V) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.m.Store(key, value)
+	h.hook(logStore, key, value)
+}
+
+func (h *hookedMap[K, V]) Swap(key K, value V) (V, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	previous, loaded := h.m.Swap(key, value)
+	h.hook(logStore, key, value)
+	return previous, loaded
+}
+
+func (h *hookedMap[K, V]) LoadOrStore(key K, value V) (V, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	actual, loaded := h.m.LoadOrStore(key, value)
+	if !loaded {
+		h.hook(logStore, key, value)
+	}
+	return actual, loaded
+}
+
+func (h *hookedMap[K, V]) LoadAndDelete(key K) (V, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	value, loaded := h.m.LoadAndDelete(key)
+	if loaded {
+		h.hook(logDelete, key, value)
+	}
+	return value, loaded
+}
+
+func (h *hookedMap[K, V]) Delete(key K) {
+	_, _ = h.LoadAndDelete(key)
+}
+
+func (h *hookedMap[K, V]) CompareAndSwap(key K, old, new V) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.m.CompareAndSwap(key, old, new) {
+		return false
+	}
+	h.hook(logStore, key, new)
+	return true
+}
+
+func (h *hookedMap[K, V]) CompareAndDelete(key K, old V) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.m.CompareAndDelete(key, old) {
+		return false
+	}
+	h.hook(logDelete, key, old)
+	return true
+}
+
+func (h *hookedMap[K, V]) Load(key K) (V, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.m.Load(key)
+}
+
+// Range calls fn for every pair in key order as of the moment it is called, fn may
+// write to the map meanwhile. It reads a snapshot of the map: with safety_map one
+// that is pinned in constant time, in the default build a copy of every pair, which
+// costs the time and memory of the whole map on every call.
+func (h *hookedMap[K, V]) Range(fn func(key K, value V) bool) {
+	h.mu.RLock()
+	snap := h.m.Snapshot()
+	h.mu.RUnlock()
+
+	defer snap.Close()
+	snap.Range(fn)
+}
+
+func (h *hookedMap[K, V]) Floor(key K) (K, V, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.m.Floor(key)
+}
+
+func (h *hookedMap[K, V]) Ceiling(key K) (K, V, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.m.Ceiling(key)
+}
+
+func (h *hookedMap[K, V]) Len() int64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.m.Len()
+}
+
+func (h *hookedMap[K, V]) Contains(key K) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.m.Contains(key)
+}
+
+func (h *hookedMap[K, V]) MarshalJSON() ([]byte, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.m.MarshalJSON()
+}
@@ -0,0 +1,299 @@
+package lsm
+
+import (
+	"bytes"
+	"os"
+	"slices"
+	"sort"
+)
+
+// schedule wakes the background goroutine up
+func (db *DB) schedule() {
+	select {
+	case db.work <- struct{}{}:
+	default:
+	}
+}
+
+// background flushes the immutable memtables and compacts the levels that need it,
+// its first error fails the store
+func (db *DB) background() {
+	defer close(db.done)
+	for {
+		select {
+		case <-db.stop:
+			return
+		case <-db.work:
+		}
+
+		err := db.flushAll()
+		for err == nil && !db.stopped() {
+			var compacted bool
+			if compacted, err = db.compact(); !compacted {
+				break
+			}
+		}
+		if err != nil {
+			db.fail(err)
+			return
+		}
+	}
+}
+
+func (db *DB) stopped() bool {
+	select {
+	case <-db.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// flushAll writes the immutable memtables to level 0, oldest first
+func (db *DB) flushAll() error {
+	for !db.stopped() {
+		db.mu.RLock()
+		if len(db.imm) == 0 {
+			db.mu.RUnlock()
+			return nil
+		}
+		mem := db.imm[0]
+		db.mu.RUnlock()
+
+		tables, err := db.writeTables(mem.iter(nil), false, false)
+		if err != nil {
+			return err
+		}
+
+		db.mu.Lock()
+		levels := db.current.levels
+		levels[0] = append(tables, levels[0]...)
+		log := db.mem.id
+		if len(db.imm) > 1 {
+			log = db.imm[1].id
+		}
+		if err = db.install(levels, tables, nil, log); err != nil {
+			db.mu.Unlock()
+			return err
+		}
+		db.imm = db.imm[1:]
+		db.cond.Broadcast()
+		db.mu.Unlock()
+
+		// the writes of the log are in the table now, an error it met no longer matters
+		_ = mem.Close()
+		if err = os.Remove(db.path(mem.id, logExt)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compact merges the tables of the level that needs it most into the next one, it
+// reports false if no level does
+func (db *DB) compact() (bool, error) {
+	db.mu.RLock()
+	v := db.current
+	db.mu.RUnlock()
+
+	level, inputs := db.pick(v)
+	if level < 0 {
+		return false, nil
+	}
+	lo, hi := bounds(inputs)
+	var overlaps []*table
+	for _, t := range v.levels[level+1] {
+		if t.overlaps(lo, hi) {
+			overlaps = append(overlaps, t)
+		}
+	}
+
+	levels := v.levels
+	levels[level] = slices.DeleteFunc(slices.Clone(levels[level]), func(t *table) bool {
+		return slices.Contains(inputs, t)
+	})
+	if level > 0 && len(overlaps) == 0 {
+		// the table moves down as is
+		levels[level+1] = insertTables(levels[level+1], inputs)
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		return true, db.install(levels, nil, nil, db.logID())
+	}
+
+	// the deletions are dropped once no deeper level may hold the keys they delete
+	all := append(slices.Clone(inputs), overlaps...)
+	lo, hi = bounds(all)
+	last := true
+	for _, tables := range v.levels[level+2:] {
+		for _, t := range tables {
+			last = last && !t.overlaps(lo, hi)
+		}
+	}
+	var its []iterator
+	if level == 0 {
+		for _, t := range inputs {
+			its = append(its, t.iter(nil))
+		}
+	} else {
+		its = append(its, newLevelIter(inputs, nil))
+	}
+	its = append(its, newLevelIter(overlaps, nil))
+	outputs, err := db.writeTables(newMergeIter(its), true, last)
+	if err != nil {
+		return false, err
+	}
+
+	levels[level+1] = slices.DeleteFunc(slices.Clone(levels[level+1]), func(t *table) bool {
+		return slices.Contains(overlaps, t)
+	})
+	levels[level+1] = insertTables(levels[level+1], outputs)
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return true, db.install(levels, outputs, all, db.logID())
+}
+
+// pick returns the level to compact and its tables to merge into the next level, or
+// -1 if no level needs it. A level is never picked without tables. Level 0 is compacted as a whole once it holds enough
+// tables, the others a table at a time once they outgrow their size, taking turns
+// over their keys.
+func (db *DB) pick(v *version) (int, []*table) {
+	if n := len(v.levels[0]); n > 0 && n >= db.c.level0Tables {
+		return 0, v.levels[0]
+	}
+	limit := 10 * int64(db.c.tableSize)
+	for level := 1; level < numLevels-1; level, limit = level+1, limit*10 {
+		tables := v.levels[level]
+		var size int64
+		for _, t := range tables {
+			size += t.size
+		}
+		if size <= limit || len(tables) == 0 {
+			continue
+		}
+
+		i := 0
+		if pointer := db.pointers[level]; pointer != nil {
+			i = sort.Search(len(tables), func(i int) bool {
+				return bytes.Compare(tables[i].smallest, pointer) > 0
+			})
+			if i == len(tables) {
+				i = 0
+			}
+		}
+		db.pointers[level] = tables[i].largest
+		return level, tables[i : i+1]
+	}
+	return -1, nil
+}
+
+// bounds returns the smallest and the largest key of tables
+func bounds(tables []*table) ([]byte, []byte) {
+	lo, hi := tables[0].smallest, tables[0].largest
+	for _, t := range tables[1:] {
+		if bytes.Compare(t.smallest, lo) < 0 {
+			lo = t.smallest
+		}
+		if bytes.Compare(t.largest, hi) > 0 {
+			hi = t.largest
+		}
+	}
+	return lo, hi
+}
+
+// insertTables returns the tables of a level with tables added in key order
+func insertTables(level, tables []*table) []*table {
+	level = append(slices.Clone(level), tables...)
+	slices.SortFunc(level, func(a, b *table) int {
+		return bytes.Compare(a.smallest, b.smallest)
+	})
+	return level
+}
+
+// writeTables writes the entries of it to new tables, a single one unless split
+// cuts them to the table size, without the deletions if drop is set
+func (db *DB) writeTables(it iterator, split, drop bool) (tables []*table, err error) {
+	var w *tableWriter
+	defer func() {
+		if err != nil {
+			if w != nil {
+				w.abort()
+			}
+			for _, t := range tables {
+				t.discard()
+			}
+		}
+	}()
+
+	finish := func() error {
+		t, err := w.finish(db.c.bitsPerKey)
+		if err != nil {
+			return err
+		}
+		tables, w = append(tables, t), nil
+		return nil
+	}
+	for ; it.valid(); it.next() {
+		e := it.entry()
+		if drop && e.deleted {
+			continue
+		}
+		if w == nil {
+			id := db.newID()
+			if w, err = createTable(db.path(id, tableExt), id, db.c.blockSize); err != nil {
+				return nil, err
+			}
+		}
+		if err = w.add(it.key(), e); err != nil {
+			return nil, err
+		}
+		if split && w.size() >= uint64(db.c.tableSize) {
+			if err = finish(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err = it.err(); err != nil {
+		return nil, err
+	}
+	if w != nil {
+		if err = finish(); err != nil {
+			return nil, err
+		}
+	}
+	return tables, nil
+}
+
+// logID returns the id of the oldest log not flushed, db.mu must be held
+func (db *DB) logID() uint64 {
+	if len(db.imm) > 0 {
+		return db.imm[0].id
+	}
+	return db.mem.id
+}
+
+// install records levels in the manifest and makes them current, the tables added
+// are discarded if it fails and the ones removed are once no reader holds them.
+// db.mu must be held.
+func (db *DB) install(levels [numLevels][]*table, added, removed []*table, log uint64) error {
+	m := manifest{Next: db.next, Log: log, Levels: make([][]uint64, numLevels)}
+	for level, tables := range levels {
+		m.Levels[level] = make([]uint64, 0, len(tables))
+		for _, t := range tables {
+			m.Levels[level] = append(m.Levels[level], t.id)
+		}
+	}
+	if err := writeManifest(db.dir, m); err != nil {
+		for _, t := range added {
+			t.discard()
+		}
+		return err
+	}
+
+	for _, t := range removed {
+		t.obsolete.Store(true)
+	}
+	old := db.current
+	db.current = newVersion(levels)
+	old.unref()
+	return nil
+}
@@ -0,0 +1,110 @@
+package lsm
+
+import (
+	"bytes"
+	"sort"
+)
+
+// iterator walks over entries in increasing key order. The key and the entry are
+// only valid until next is called.
+type iterator interface {
+	valid() bool
+	key() []byte
+	entry() entry
+	next()
+	// err returns the error that made the iterator invalid, if any
+	err() error
+}
+
+// levelIter iterates over the tables of a level, which do not overlap and are
+// ordered by key
+type levelIter struct {
+	tables []*table
+	it     *tableIter
+}
+
+func newLevelIter(tables []*table, lo []byte) *levelIter {
+	i := sort.Search(len(tables), func(i int) bool {
+		return bytes.Compare(tables[i].largest, lo) >= 0
+	})
+	l := &levelIter{tables: tables[i:]}
+	if len(l.tables) > 0 {
+		l.it = l.tables[0].iter(lo)
+		l.skip()
+	}
+	return l
+}
+
+// skip moves to the next table while the current one is exhausted
+func (l *levelIter) skip() {
+	for !l.it.valid() && l.it.err() == nil && len(l.tables) > 1 {
+		l.tables = l.tables[1:]
+		l.it = l.tables[0].iter(nil)
+	}
+}
+
+func (l *levelIter) valid() bool  { return l.it != nil && l.it.valid() }
+func (l *levelIter) key() []byte  { return l.it.key() }
+func (l *levelIter) entry() entry { return l.it.entry() }
+
+func (l *levelIter) next() {
+	l.it.next()
+	l.skip()
+}
+
+func (l *levelIter) err() error {
+	if l.it == nil {
+		return nil
+	}
+	return l.it.err()
+}
+
+// mergeIter merges iterators ordered newest first, a key is read from the newest
+// iterator holding it
+type mergeIter struct {
+	its     []iterator
+	current int
+	k       []byte
+	e       error
+}
+
+func newMergeIter(its []iterator) *mergeIter {
+	m := &mergeIter{its: its}
+	m.pick()
+	return m
+}
+
+// pick makes the newest of the iterators at the least key current
+func (m *mergeIter) pick() {
+	m.current = -1
+	for i, it := range m.its {
+		if !it.valid() {
+			if err := it.err(); err != nil {
+				m.current, m.e = -1, err
+				return
+			}
+			continue
+		}
+		if m.current < 0 || bytes.Compare(it.key(), m.its[m.current].key()) < 0 {
+			m.current = i
+		}
+	}
+	if m.current >= 0 {
+		m.k = append(m.k[:0], m.its[m.current].key()...)
+	}
+}
+
+func (m *mergeIter) valid() bool  { return m.current >= 0 }
+func (m *mergeIter) key() []byte  { return m.k }
+func (m *mergeIter) entry() entry { return m.its[m.current].entry() }
+func (m *mergeIter) err() error   { return m.e }
+
+// next moves every iterator at the current key past it
+func (m *mergeIter) next() {
+	for _, it := range m.its {
+		if it.valid() && bytes.Equal(it.key(), m.k) {
+			it.next()
+		}
+	}
+	m.pick()
+}
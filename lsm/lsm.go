@@ -0,0 +1,493 @@
+// Package lsm is a small embedded key-value store built as a log-structured merge
+// tree. Writes go to an ordered map, the memtable, and to its write-ahead log. A full
+// memtable is flushed to an immutable table file sorted by key, with a block index
+// and a bloom filter, and a background compaction merges the tables into levels of
+// growing size, so that the data may exceed the memory.
+package lsm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	odmap "github.com/RealFax/order-map"
+)
+
+const (
+	numLevels = 7
+	// maxImmutable memtables may wait for their flush before writes wait for them
+	maxImmutable = 2
+
+	tableExt = ".sst"
+	logExt   = ".wal"
+)
+
+// ErrInvalidOption is returned by Open for an option out of its range
+var ErrInvalidOption = errors.New("lsm: invalid option")
+
+type config struct {
+	memtableSize int
+	blockSize    int
+	tableSize    int
+	level0Tables int
+	bitsPerKey   int
+	logOpts      []odmap.DurableOption
+}
+
+type Option func(c *config)
+
+// WithMemtableSize sets the size a memtable grows to before it is flushed, 4 MiB by
+// default, it must be positive
+func WithMemtableSize(size int) Option {
+	return func(c *config) {
+		c.memtableSize = size
+	}
+}
+
+// WithBlockSize sets the size of the blocks of the tables, 4 KiB by default. It must
+// be positive.
+func WithBlockSize(size int) Option {
+	return func(c *config) {
+		c.blockSize = size
+	}
+}
+
+// WithTableSize sets the size of the tables written by compactions, 2 MiB by default,
+// it must be positive. Level 1 holds ten tables, every next level ten times more.
+func WithTableSize(size int) Option {
+	return func(c *config) {
+		c.tableSize = size
+	}
+}
+
+// WithLevel0Tables sets the number of flushed tables that are compacted together
+// into level 1, 4 by default and at least 1
+func WithLevel0Tables(n int) Option {
+	return func(c *config) {
+		c.level0Tables = n
+	}
+}
+
+// WithLogOptions sets the options of the write-ahead logs of the memtables, which
+// sync every write by default
+func WithLogOptions(opts ...odmap.DurableOption) Option {
+	return func(c *config) {
+		c.logOpts = opts
+	}
+}
+
+// version is the set of tables of every level, readers hold it while they read them
+type version struct {
+	levels [numLevels][]*table
+	refs   atomic.Int32
+}
+
+// newVersion returns a version of levels, holding a reference to each of its tables
+func newVersion(levels [numLevels][]*table) *version {
+	v := &version{levels: levels}
+	v.refs.Store(1)
+	for _, tables := range levels {
+		for _, t := range tables {
+			t.ref()
+		}
+	}
+	return v
+}
+
+func (v *version) ref() { v.refs.Add(1) }
+
+func (v *version) unref() {
+	if v.refs.Add(-1) == 0 {
+		for _, tables := range v.levels {
+			for _, t := range tables {
+				t.unref()
+			}
+		}
+	}
+}
+
+// DB is a key-value store in a directory, it may be used by any number of goroutines
+type DB struct {
+	dir string
+	c   config
+
+	// mu guards the fields below, cond is signalled when a memtable was flushed or
+	// the store failed or closed
+	mu      sync.RWMutex
+	cond    *sync.Cond
+	mem     *memtable
+	imm     []*memtable
+	current *version
+	next    uint64
+	err     error
+	closed  bool
+
+	// pointers hold the largest key last compacted of every level, they are only
+	// used by the background goroutine
+	pointers [numLevels][]byte
+
+	work chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Open opens the store in dir, creating it if needed. The logs of the memtables that
+// were not flushed are replayed.
+func Open(dir string, opts ...Option) (*DB, error) {
+	c := config{
+		memtableSize: 4 << 20,
+		blockSize:    4 << 10,
+		tableSize:    2 << 20,
+		level0Tables: 4,
+		bitsPerKey:   10,
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	if c.memtableSize <= 0 || c.blockSize <= 0 || c.tableSize <= 0 || c.level0Tables < 1 {
+		return nil, ErrInvalidOption
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	m, err := readManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(m.Levels) > numLevels {
+		return nil, ErrCorrupt
+	}
+
+	db := &DB{dir: dir, c: c, next: m.Next, work: make(chan struct{}, 1), stop: make(chan struct{}), done: make(chan struct{})}
+	db.cond = sync.NewCond(&db.mu)
+	var (
+		levels [numLevels][]*table
+		live   = make(map[uint64]bool)
+	)
+	closeAll := func() {
+		for _, tables := range levels {
+			for _, t := range tables {
+				t.file.Close()
+			}
+		}
+		for _, mem := range db.imm {
+			mem.Close()
+		}
+	}
+	for level, ids := range m.Levels {
+		for _, id := range ids {
+			t, err := openTable(db.path(id, tableExt), id)
+			if err != nil {
+				closeAll()
+				return nil, err
+			}
+			levels[level], live[id] = append(levels[level], t), true
+		}
+	}
+
+	// drop the tables of compactions and flushes a crash interrupted, and the logs
+	// that were flushed, then replay the others
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+	var logs []uint64
+	for _, file := range files {
+		name, ext := file.Name(), filepath.Ext(file.Name())
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, ext), 10, 64)
+		if err != nil || (ext != tableExt && ext != logExt) {
+			continue
+		}
+		db.next = max(db.next, id+1)
+		switch {
+		case ext == tableExt && !live[id], ext == logExt && id < m.Log:
+			os.Remove(filepath.Join(dir, name))
+		case ext == logExt:
+			logs = append(logs, id)
+		}
+	}
+	slices.Sort(logs)
+	for _, id := range logs {
+		mem, err := openMemtable(db.path(id, logExt), id, c.logOpts)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		db.imm = append(db.imm, mem)
+	}
+	if db.mem, err = db.newMemtable(); err != nil {
+		closeAll()
+		return nil, err
+	}
+	db.current = newVersion(levels)
+
+	go db.background()
+	db.schedule()
+	return db, nil
+}
+
+func (db *DB) path(id uint64, ext string) string {
+	return filepath.Join(db.dir, fmt.Sprintf("%06d%s", id, ext))
+}
+
+// newID returns the id of a new table or log
+func (db *DB) newID() uint64 {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.next++
+	return db.next - 1
+}
+
+// newMemtable opens the memtable of a new log, db.mu must be held
+func (db *DB) newMemtable() (*memtable, error) {
+	id := db.next
+	db.next++
+	return openMemtable(db.path(id, logExt), id, db.c.logOpts)
+}
+
+// Put stores value for key
+func (db *DB) Put(key, value []byte) error {
+	return db.write(key, entry{value: append([]byte{}, value...)})
+}
+
+// Delete deletes key
+func (db *DB) Delete(key []byte) error {
+	return db.write(key, entry{deleted: true})
+}
+
+func (db *DB) write(key []byte, e entry) error {
+	db.mu.RLock()
+	if db.closed || db.err != nil {
+		defer db.mu.RUnlock()
+		if db.closed {
+			return odmap.ErrClosed
+		}
+		return db.err
+	}
+	mem := db.mem
+	mem.Store(string(key), e)
+	if err := mem.Err(); err != nil {
+		// the write is not durable, and the log may end with a part of its record
+		db.mu.RUnlock()
+		db.fail(err)
+		return err
+	}
+	full := mem.size.Add(int64(len(key)+len(e.value))) >= int64(db.c.memtableSize)
+	db.mu.RUnlock()
+
+	if full {
+		return db.rotate(mem)
+	}
+	return nil
+}
+
+// fail keeps the first error of the store, which fails every write from then on
+func (db *DB) fail(err error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.err == nil {
+		db.err = err
+	}
+	db.cond.Broadcast()
+}
+
+// rotate makes mem immutable and schedules its flush, it waits while too many
+// memtables wait for theirs
+func (db *DB) rotate(mem *memtable) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for db.mem == mem && len(db.imm) >= maxImmutable && db.err == nil && !db.closed {
+		db.cond.Wait()
+	}
+	switch {
+	case db.closed:
+		return odmap.ErrClosed
+	case db.err != nil:
+		return db.err
+	case db.mem != mem:
+		return nil
+	}
+	next, err := db.newMemtable()
+	if err != nil {
+		return err
+	}
+	db.imm, db.mem = append(db.imm, mem), next
+	db.schedule()
+	return nil
+}
+
+// Flush flushes the memtable to a table and returns once every memtable is flushed
+func (db *DB) Flush() error {
+	db.mu.RLock()
+	mem, empty := db.mem, db.mem.size.Load() == 0
+	db.mu.RUnlock()
+	if !empty {
+		if err := db.rotate(mem); err != nil {
+			return err
+		}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for len(db.imm) > 0 && db.err == nil && !db.closed {
+		db.cond.Wait()
+	}
+	if db.closed {
+		return odmap.ErrClosed
+	}
+	return db.err
+}
+
+// acquire returns the memtables newest first and the current version, which must be
+// released by unref
+func (db *DB) acquire() ([]*memtable, *version, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return nil, nil, odmap.ErrClosed
+	}
+	mems := append(make([]*memtable, 0, 1+len(db.imm)), db.mem)
+	for i := len(db.imm) - 1; i >= 0; i-- {
+		mems = append(mems, db.imm[i])
+	}
+	db.current.ref()
+	return mems, db.current, nil
+}
+
+// Get returns the value of key, reading the memtables then the levels newest first
+func (db *DB) Get(key []byte) ([]byte, bool, error) {
+	mems, v, err := db.acquire()
+	if err != nil {
+		return nil, false, err
+	}
+	defer v.unref()
+
+	for _, mem := range mems {
+		if e, ok := mem.Load(string(key)); ok {
+			return result(e)
+		}
+	}
+	for _, t := range v.levels[0] {
+		if e, ok, err := t.get(key); err != nil || ok {
+			if err != nil {
+				return nil, false, err
+			}
+			return result(e)
+		}
+	}
+	for _, tables := range v.levels[1:] {
+		i := sort.Search(len(tables), func(i int) bool {
+			return bytes.Compare(tables[i].largest, key) >= 0
+		})
+		if i == len(tables) {
+			continue
+		}
+		if e, ok, err := tables[i].get(key); err != nil || ok {
+			if err != nil {
+				return nil, false, err
+			}
+			return result(e)
+		}
+	}
+	return nil, false, nil
+}
+
+func result(e entry) ([]byte, bool, error) {
+	if e.deleted {
+		return nil, false, nil
+	}
+	return bytes.Clone(e.value), true, nil
+}
+
+// Scan calls fn for every pair with a key in [lo, hi) in key order, a nil hi bounds
+// nothing. It reads the tables as of the moment it is called and the memtables as it
+// goes, the writes made meanwhile may be seen or not. The slices passed to fn are only
+// valid until it returns.
+func (db *DB) Scan(lo, hi []byte, fn func(key, value []byte) bool) error {
+	mems, v, err := db.acquire()
+	if err != nil {
+		return err
+	}
+	defer v.unref()
+
+	its := make([]iterator, 0, len(mems)+len(v.levels[0])+numLevels-1)
+	for _, mem := range mems {
+		its = append(its, mem.iter(lo))
+	}
+	for _, t := range v.levels[0] {
+		its = append(its, t.iter(lo))
+	}
+	for _, tables := range v.levels[1:] {
+		its = append(its, newLevelIter(tables, lo))
+	}
+
+	it := newMergeIter(its)
+	for ; it.valid(); it.next() {
+		if hi != nil && bytes.Compare(it.key(), hi) >= 0 {
+			break
+		}
+		if e := it.entry(); !e.deleted && !fn(it.key(), e.value) {
+			break
+		}
+	}
+	return it.err()
+}
+
+// LevelStats describes the tables of a level
+type LevelStats struct {
+	Tables int
+	Size   int64
+}
+
+// Levels returns the stats of every level
+func (db *DB) Levels() []LevelStats {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	stats := make([]LevelStats, numLevels)
+	for level, tables := range db.current.levels {
+		for _, t := range tables {
+			stats[level].Tables++
+			stats[level].Size += t.size
+		}
+	}
+	return stats
+}
+
+// Close stops the background work and closes the logs, the memtables that were not
+// flushed are replayed by the next Open. Closing it again does nothing and returns
+// nil.
+func (db *DB) Close() error {
+	db.mu.Lock()
+	if db.closed {
+		db.mu.Unlock()
+		return nil
+	}
+	db.closed = true
+	db.cond.Broadcast()
+	db.mu.Unlock()
+
+	close(db.stop)
+	<-db.done
+
+	err := db.mem.Close()
+	for _, mem := range db.imm {
+		if e := mem.Close(); err == nil {
+			err = e
+		}
+	}
+	db.current.unref()
+	return err
+}
@@ -0,0 +1,353 @@
+package lsm_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	odmap "github.com/RealFax/order-map"
+	"github.com/RealFax/order-map/lsm"
+)
+
+// small sizes make a few thousand writes flush and compact many times
+func open(t *testing.T, dir string) *lsm.DB {
+	t.Helper()
+	db, err := lsm.Open(dir,
+		lsm.WithMemtableSize(4<<10),
+		lsm.WithTableSize(4<<10),
+		lsm.WithBlockSize(256),
+		lsm.WithLevel0Tables(2),
+		lsm.WithLogOptions(odmap.WithSync(odmap.SyncNever)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func key(i int) []byte {
+	return []byte(fmt.Sprintf("key-%05d", i))
+}
+
+// check fails unless db holds exactly the pairs of model
+func check(t *testing.T, db *lsm.DB, model map[string]string, keys int) {
+	t.Helper()
+	for i := 0; i < keys; i++ {
+		value, ok, err := db.Get(key(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, found := model[string(key(i))]
+		if ok != found || string(value) != want {
+			t.Fatalf("Get(%s): got %q, %v, want %q, %v", key(i), value, ok, want, found)
+		}
+	}
+
+	want := make([]string, 0, len(model))
+	for k := range model {
+		want = append(want, k)
+	}
+	slices.Sort(want)
+	var got []string
+	err := db.Scan(nil, nil, func(k, v []byte) bool {
+		if model[string(k)] != string(v) {
+			t.Fatalf("Scan: got %s=%q, want %q", k, v, model[string(k)])
+		}
+		got = append(got, string(k))
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("Scan: got %d keys, want %d", len(got), len(want))
+	}
+}
+
+func TestDB_Model(t *testing.T) {
+	dir := t.TempDir()
+	db := open(t, dir)
+	model := map[string]string{}
+	r := rand.New(rand.NewSource(1))
+
+	const keys = 2000
+	for i := 0; i < 20000; i++ {
+		k := key(r.Intn(keys))
+		if r.Intn(4) == 0 {
+			if err := db.Delete(k); err != nil {
+				t.Fatal(err)
+			}
+			delete(model, string(k))
+			continue
+		}
+		v := fmt.Sprint("value-", i)
+		if err := db.Put(k, []byte(v)); err != nil {
+			t.Fatal(err)
+		}
+		model[string(k)] = v
+	}
+	check(t, db, model, keys)
+
+	if err := db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for db.Levels()[0].Tables >= 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	levels := db.Levels()
+	if levels[0].Tables >= 2 || levels[1].Tables == 0 {
+		t.Fatalf("levels were not compacted: %+v", levels)
+	}
+	check(t, db, model, keys)
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db = open(t, dir)
+	defer db.Close()
+	check(t, db, model, keys)
+}
+
+func TestDB_Replay(t *testing.T) {
+	dir := t.TempDir()
+	db, err := lsm.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if err = db.Put(key(i), key(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	// these stay in the log of the memtable
+	if err = db.Delete(key(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Put(key(2), []byte("two")); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Put(key(3), nil); !errors.Is(err, odmap.ErrClosed) {
+		t.Fatalf("got %v, want ErrClosed", err)
+	}
+	if err = db.Close(); err != nil {
+		t.Fatalf("closed twice: got %v", err)
+	}
+
+	if db, err = lsm.Open(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, ok, _ := db.Get(key(1)); ok {
+		t.Fatal("deleted key found")
+	}
+	if value, _, _ := db.Get(key(2)); string(value) != "two" {
+		t.Fatalf("got %q, want two", value)
+	}
+	if value, _, _ := db.Get(key(3)); !bytes.Equal(value, key(3)) {
+		t.Fatalf("got %q, want %s", value, key(3))
+	}
+}
+
+func TestDB_Scan(t *testing.T) {
+	db := open(t, t.TempDir())
+	defer db.Close()
+	for i := 0; i < 1000; i++ {
+		if err := db.Put(key(i), nil); err != nil {
+			t.Fatal(err)
+		}
+		if i%3 == 0 {
+			if err := db.Delete(key(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	var got []string
+	err := db.Scan(key(100), key(110), func(k, _ []byte) bool {
+		got = append(got, string(k))
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"key-00100", "key-00101", "key-00103", "key-00104", "key-00106", "key-00107", "key-00109"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestDB_Concurrent(t *testing.T) {
+	db := open(t, t.TempDir())
+	defer db.Close()
+
+	const (
+		writers = 4
+		keys    = 2000
+	)
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < keys; i += writers {
+				if err := db.Put(key(i), key(i)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(w)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	// the readers race the writes to the memtable and its flushes
+	r := rand.New(rand.NewSource(1))
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		if value, ok, err := db.Get(key(r.Intn(keys))); err != nil || (ok && !bytes.HasPrefix(value, []byte("key-"))) {
+			t.Fatalf("Get: got %q, %v", value, err)
+		}
+		var prev []byte
+		err := db.Scan(nil, nil, func(k, v []byte) bool {
+			if bytes.Compare(k, prev) <= 0 || !bytes.Equal(k, v) {
+				t.Fatalf("Scan: got %s=%s after %s", k, v, prev)
+			}
+			prev = append(prev[:0], k...)
+			return true
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	model := make(map[string]string, keys)
+	for i := 0; i < keys; i++ {
+		model[string(key(i))] = string(key(i))
+	}
+	check(t, db, model, keys)
+}
+
+func TestDB_CorruptTable(t *testing.T) {
+	dir := t.TempDir()
+	db := open(t, dir)
+	for i := 0; i < 100; i++ {
+		if err := db.Put(key(i), bytes.Repeat([]byte{'v'}, 100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tables, err := filepath.Glob(filepath.Join(dir, "*.sst"))
+	if err != nil || len(tables) == 0 {
+		t.Fatalf("no table written: %v", err)
+	}
+	for _, table := range tables {
+		data, err := os.ReadFile(table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the first block of every table
+		data[20] ^= 1
+		if err = os.WriteFile(table, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db = open(t, dir)
+	defer db.Close()
+	if err = db.Scan(nil, nil, func(_, _ []byte) bool { return true }); !errors.Is(err, lsm.ErrCorrupt) {
+		t.Fatalf("got %v, want ErrCorrupt", err)
+	}
+}
+
+func TestDB_CorruptFilter(t *testing.T) {
+	dir := t.TempDir()
+	db := open(t, dir)
+	for i := 0; i < 100; i++ {
+		if err := db.Put(key(i), key(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tables, err := filepath.Glob(filepath.Join(dir, "*.sst"))
+	if err != nil || len(tables) == 0 {
+		t.Fatalf("no table written: %v", err)
+	}
+	data, err := os.ReadFile(tables[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the last byte of the filter, before the footer of 32 bytes
+	data[len(data)-33] ^= 1
+	if err = os.WriteFile(tables[0], data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = lsm.Open(dir); !errors.Is(err, lsm.ErrCorrupt) {
+		t.Fatalf("got %v, want ErrCorrupt", err)
+	}
+}
+
+func TestDB_LogError(t *testing.T) {
+	// the log of the memtable fails every write, the keyring holds no key
+	db, err := lsm.Open(t.TempDir(), lsm.WithLogOptions(odmap.WithLogEncryption(&odmap.Keyring{})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err = db.Put(key(1), key(1)); !errors.Is(err, odmap.ErrUnknownKey) {
+		t.Fatalf("Put: got %v, want ErrUnknownKey", err)
+	}
+	// the store is failed, not only the write
+	if err = db.Delete(key(2)); !errors.Is(err, odmap.ErrUnknownKey) {
+		t.Fatalf("Delete: got %v, want ErrUnknownKey", err)
+	}
+	if err = db.Flush(); !errors.Is(err, odmap.ErrUnknownKey) {
+		t.Fatalf("Flush: got %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestDB_InvalidOptions(t *testing.T) {
+	for name, opt := range map[string]lsm.Option{
+		"memtable size": lsm.WithMemtableSize(0),
+		"block size":    lsm.WithBlockSize(-1),
+		"table size":    lsm.WithTableSize(0),
+		"level 0":       lsm.WithLevel0Tables(0),
+	} {
+		if _, err := lsm.Open(t.TempDir(), opt); !errors.Is(err, lsm.ErrInvalidOption) {
+			t.Fatalf("%s: got %v, want ErrInvalidOption", name, err)
+		}
+	}
+}
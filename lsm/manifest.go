@@ -0,0 +1,80 @@
+package lsm
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// manifest records the tables of every level, it is replaced as a whole whenever
+// they change
+type manifest struct {
+	// Next is the id of the next table or log
+	Next uint64 `json:"next"`
+	// Log is the id of the oldest log not flushed to a table
+	Log uint64 `json:"log"`
+	// Levels lists the ids of the tables of every level, newest first in level 0 and
+	// by key in the others
+	Levels [][]uint64 `json:"levels"`
+}
+
+const manifestName = "MANIFEST"
+
+func readManifest(dir string) (manifest, error) {
+	var m manifest
+	data, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return m, err
+	}
+	if err = json.Unmarshal(data, &m); err != nil {
+		return m, ErrCorrupt
+	}
+	return m, nil
+}
+
+// writeManifest replaces the manifest of dir once the new one is on stable storage,
+// the directory is synced before it returns so that the files the manifest no longer
+// needs can be removed
+func writeManifest(dir string, m manifest) (err error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, manifestName+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	if _, err = f.Write(data); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(f.Name(), filepath.Join(dir, manifestName)); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir flushes the entries of dir to stable storage
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
@@ -0,0 +1,88 @@
+package lsm
+
+import (
+	"sync/atomic"
+
+	odmap "github.com/RealFax/order-map"
+)
+
+// entry is the value of a key or its deletion, which shadows the older values of the
+// key until a compaction into the last level drops it
+type entry struct {
+	value   []byte
+	deleted bool
+}
+
+// entryCodec encodes an entry as its kind followed by the value
+type entryCodec struct{}
+
+func (entryCodec) Append(b []byte, e entry) ([]byte, error) {
+	if e.deleted {
+		return append(b, kindDelete), nil
+	}
+	return append(append(b, kindPut), e.value...), nil
+}
+
+func (entryCodec) Decode(b []byte) (entry, error) {
+	if len(b) == 0 {
+		return entry{}, odmap.ErrCodec
+	}
+	switch b[0] {
+	case kindPut:
+		return entry{value: append([]byte{}, b[1:]...)}, nil
+	case kindDelete:
+		return entry{deleted: true}, nil
+	}
+	return entry{}, odmap.ErrCodec
+}
+
+func (entryCodec) Width() int { return 0 }
+
+// memtable holds the latest writes in an ordered map, each logged to its own
+// write-ahead log until the memtable is flushed to a table
+type memtable struct {
+	*odmap.DurableMap[string, entry]
+	id   uint64
+	size atomic.Int64
+}
+
+func openMemtable(path string, id uint64, opts []odmap.DurableOption) (*memtable, error) {
+	m, err := odmap.OpenDurable(path, odmap.KeyCodec[string](), odmap.Codec[entry](entryCodec{}), opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &memtable{DurableMap: m, id: id}, nil
+}
+
+// iter returns an iterator at the first entry with a key not below lo
+func (m *memtable) iter(lo []byte) *memIter {
+	it := &memIter{m: m}
+	it.seek(string(lo))
+	return it
+}
+
+// memIter iterates over the entries of a memtable, seeing the writes made meanwhile
+// to the keys it has not reached
+type memIter struct {
+	m  *memtable
+	k  []byte
+	e  entry
+	ok bool
+}
+
+func (it *memIter) seek(key string) {
+	var k string
+	k, it.e, it.ok = it.m.Ceiling(key)
+	it.k = []byte(k)
+}
+
+func (it *memIter) valid() bool  { return it.ok }
+func (it *memIter) key() []byte  { return it.k }
+func (it *memIter) entry() entry { return it.e }
+func (it *memIter) err() error   { return nil }
+
+// next moves to the least key above the current one, which is the key followed by
+// a zero byte
+func (it *memIter) next() {
+	it.seek(string(append(it.k, 0)))
+}
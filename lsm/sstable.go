@@ -0,0 +1,404 @@
+package lsm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"hash/fnv"
+	"os"
+	"sort"
+	"sync/atomic"
+)
+
+var ErrCorrupt = errors.New("lsm: corrupt table")
+
+// A table is an immutable file of entries in increasing key order:
+//
+//	data blocks entries, then the CRC-32C of the entries
+//	index       uvarint length and smallest key, then for every block a uvarint
+//	            length and its last key, its offset uint64 and length uint32
+//	filter      the bloom filter of the keys
+//	footer      index offset uint64, index length uint32, filter offset uint64,
+//	            filter length uint32, CRC-32C of the index, the filter and the
+//	            fields above, magic "ODMT"
+//
+// all little-endian. An entry is its kind, a uvarint key length and the key, then for
+// a put a uvarint value length and the value.
+const (
+	tableMagic  = "ODMT"
+	tableFooter = 32
+
+	kindPut    byte = 1
+	kindDelete byte = 2
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+type blockHandle struct {
+	last   []byte
+	offset uint64
+	length uint32
+}
+
+// table is an open table file. It is referenced by every version holding it and
+// closed once none does, its file is removed then if a compaction dropped it.
+type table struct {
+	id       uint64
+	file     *os.File
+	size     int64
+	smallest []byte
+	largest  []byte
+	index    []blockHandle
+	filter   bloom
+
+	refs     atomic.Int32
+	obsolete atomic.Bool
+}
+
+func (t *table) ref() { t.refs.Add(1) }
+
+func (t *table) unref() {
+	if t.refs.Add(-1) == 0 {
+		t.file.Close()
+		if t.obsolete.Load() {
+			os.Remove(t.file.Name())
+		}
+	}
+}
+
+// discard removes a table no version holds
+func (t *table) discard() {
+	t.file.Close()
+	os.Remove(t.file.Name())
+}
+
+// overlaps reports whether the table may hold keys in [lo, hi], a nil bound is open
+func (t *table) overlaps(lo, hi []byte) bool {
+	return (hi == nil || bytes.Compare(t.smallest, hi) <= 0) && (lo == nil || bytes.Compare(t.largest, lo) >= 0)
+}
+
+// tableWriter writes a table, entries must be added in increasing key order
+type tableWriter struct {
+	id        uint64
+	file      *os.File
+	w         *bufio.Writer
+	blockSize int
+
+	offset   uint64
+	block    []byte
+	last     []byte
+	smallest []byte
+	index    []blockHandle
+	hashes   []uint32
+}
+
+func createTable(path string, id uint64, blockSize int) (*tableWriter, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &tableWriter{id: id, file: f, w: bufio.NewWriter(f), blockSize: blockSize}, nil
+}
+
+func (t *tableWriter) add(key []byte, e entry) error {
+	if t.smallest == nil {
+		t.smallest = bytes.Clone(key)
+	}
+	t.last = append(t.last[:0], key...)
+	t.hashes = append(t.hashes, hashKey(key))
+
+	kind := kindPut
+	if e.deleted {
+		kind = kindDelete
+	}
+	t.block = append(binary.AppendUvarint(append(t.block, kind), uint64(len(key))), key...)
+	if !e.deleted {
+		t.block = append(binary.AppendUvarint(t.block, uint64(len(e.value))), e.value...)
+	}
+	if len(t.block) >= t.blockSize {
+		return t.flush()
+	}
+	return nil
+}
+
+// size returns the bytes written so far
+func (t *tableWriter) size() uint64 {
+	return t.offset + uint64(len(t.block))
+}
+
+func (t *tableWriter) flush() error {
+	if len(t.block) == 0 {
+		return nil
+	}
+	t.block = binary.LittleEndian.AppendUint32(t.block, crc32.Checksum(t.block, castagnoli))
+	if _, err := t.w.Write(t.block); err != nil {
+		return err
+	}
+	t.index = append(t.index, blockHandle{last: bytes.Clone(t.last), offset: t.offset, length: uint32(len(t.block))})
+	t.offset += uint64(len(t.block))
+	t.block = t.block[:0]
+	return nil
+}
+
+// finish completes the table, syncs it and opens it for reading
+func (t *tableWriter) finish(bitsPerKey int) (*table, error) {
+	if err := t.flush(); err != nil {
+		return nil, err
+	}
+
+	index := append(binary.AppendUvarint(nil, uint64(len(t.smallest))), t.smallest...)
+	for _, h := range t.index {
+		index = append(binary.AppendUvarint(index, uint64(len(h.last))), h.last...)
+		index = binary.LittleEndian.AppendUint64(index, h.offset)
+		index = binary.LittleEndian.AppendUint32(index, h.length)
+	}
+	filter := newBloom(t.hashes, bitsPerKey)
+
+	footer := binary.LittleEndian.AppendUint64(nil, t.offset)
+	footer = binary.LittleEndian.AppendUint32(footer, uint32(len(index)))
+	footer = binary.LittleEndian.AppendUint64(footer, t.offset+uint64(len(index)))
+	footer = binary.LittleEndian.AppendUint32(footer, uint32(len(filter)))
+	crc := crc32.Update(crc32.Checksum(index, castagnoli), castagnoli, filter)
+	footer = binary.LittleEndian.AppendUint32(footer, crc32.Update(crc, castagnoli, footer))
+	footer = append(footer, tableMagic...)
+	for _, b := range [][]byte{index, filter, footer} {
+		if _, err := t.w.Write(b); err != nil {
+			return nil, err
+		}
+	}
+	if err := t.w.Flush(); err != nil {
+		return nil, err
+	}
+	if err := t.file.Sync(); err != nil {
+		return nil, err
+	}
+	return loadTable(t.file, t.id)
+}
+
+// abort removes the table being written
+func (t *tableWriter) abort() {
+	t.file.Close()
+	os.Remove(t.file.Name())
+}
+
+func openTable(path string, id uint64) (*table, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	t, err := loadTable(f, id)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+// loadTable reads the index and the filter of the table in f
+func loadTable(f *os.File, id uint64) (*table, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	if size < tableFooter {
+		return nil, ErrCorrupt
+	}
+	footer := make([]byte, tableFooter)
+	if _, err = f.ReadAt(footer, size-tableFooter); err != nil {
+		return nil, err
+	}
+	if string(footer[28:]) != tableMagic {
+		return nil, ErrCorrupt
+	}
+	indexOffset, indexLength := binary.LittleEndian.Uint64(footer), uint64(binary.LittleEndian.Uint32(footer[8:]))
+	filterOffset, filterLength := binary.LittleEndian.Uint64(footer[12:]), uint64(binary.LittleEndian.Uint32(footer[20:]))
+	if indexOffset > filterOffset || indexOffset+indexLength != filterOffset || filterOffset+filterLength != uint64(size-tableFooter) {
+		return nil, ErrCorrupt
+	}
+	meta := make([]byte, indexLength+filterLength)
+	if _, err = f.ReadAt(meta, int64(indexOffset)); err != nil {
+		return nil, err
+	}
+	// the checksum covers the index and the filter, which are trusted from now on
+	if binary.LittleEndian.Uint32(footer[24:]) != crc32.Update(crc32.Checksum(meta, castagnoli), castagnoli, footer[:24]) {
+		return nil, ErrCorrupt
+	}
+
+	t := &table{id: id, file: f, size: size, filter: bloom(meta[indexLength:])}
+	b, ok := meta[:indexLength], true
+	if t.smallest, b, ok = readField(b); !ok {
+		return nil, ErrCorrupt
+	}
+	for len(b) > 0 {
+		var h blockHandle
+		if h.last, b, ok = readField(b); !ok || len(b) < 12 {
+			return nil, ErrCorrupt
+		}
+		h.offset, h.length, b = binary.LittleEndian.Uint64(b), binary.LittleEndian.Uint32(b[8:]), b[12:]
+		if h.offset+uint64(h.length) > indexOffset || h.length < 4 {
+			return nil, ErrCorrupt
+		}
+		t.index = append(t.index, h)
+	}
+	if len(t.index) == 0 {
+		return nil, ErrCorrupt
+	}
+	t.largest = t.index[len(t.index)-1].last
+	return t, nil
+}
+
+// readBlock reads and checks the entries of the block i
+func (t *table) readBlock(i int) ([]byte, error) {
+	h := t.index[i]
+	b := make([]byte, h.length)
+	if _, err := t.file.ReadAt(b, int64(h.offset)); err != nil {
+		return nil, err
+	}
+	entries := b[:len(b)-4]
+	if crc32.Checksum(entries, castagnoli) != binary.LittleEndian.Uint32(b[len(entries):]) {
+		return nil, ErrCorrupt
+	}
+	return entries, nil
+}
+
+// get returns the entry of key if the table holds one
+func (t *table) get(key []byte) (entry, bool, error) {
+	if !t.overlaps(key, key) || !t.filter.mayContain(hashKey(key)) {
+		return entry{}, false, nil
+	}
+	it := t.iter(key)
+	if it.valid() && bytes.Equal(it.key(), key) {
+		return it.entry(), true, nil
+	}
+	return entry{}, false, it.err()
+}
+
+// iter returns an iterator at the first entry with a key not below lo
+func (t *table) iter(lo []byte) *tableIter {
+	it := &tableIter{t: t}
+	it.block = sort.Search(len(t.index), func(i int) bool {
+		return bytes.Compare(t.index[i].last, lo) >= 0
+	})
+	it.load()
+	for it.valid() && bytes.Compare(it.key(), lo) < 0 {
+		it.next()
+	}
+	return it
+}
+
+// tableIter iterates over the entries of a table
+type tableIter struct {
+	t     *table
+	block int
+	data  []byte
+	k, v  []byte
+	del   bool
+	ok    bool
+	e     error
+}
+
+// load reads the block it.block and moves to its first entry
+func (it *tableIter) load() {
+	it.ok = false
+	if it.block >= len(it.t.index) {
+		return
+	}
+	if it.data, it.e = it.t.readBlock(it.block); it.e != nil {
+		return
+	}
+	it.decode()
+}
+
+func (it *tableIter) decode() {
+	b, ok := it.data, true
+	if len(b) == 0 {
+		it.ok, it.e = false, ErrCorrupt
+		return
+	}
+	kind := b[0]
+	if it.k, b, ok = readField(b[1:]); !ok {
+		it.ok, it.e = false, ErrCorrupt
+		return
+	}
+	switch kind {
+	case kindPut:
+		if it.v, b, ok = readField(b); !ok {
+			it.ok, it.e = false, ErrCorrupt
+			return
+		}
+		it.del = false
+	case kindDelete:
+		it.v, it.del = nil, true
+	default:
+		it.ok, it.e = false, ErrCorrupt
+		return
+	}
+	it.data, it.ok = b, true
+}
+
+func (it *tableIter) valid() bool  { return it.ok }
+func (it *tableIter) key() []byte  { return it.k }
+func (it *tableIter) entry() entry { return entry{value: it.v, deleted: it.del} }
+func (it *tableIter) err() error   { return it.e }
+
+func (it *tableIter) next() {
+	if len(it.data) > 0 {
+		it.decode()
+		return
+	}
+	it.block++
+	it.load()
+}
+
+// readField returns the length-prefixed field starting b and the bytes following it
+func readField(b []byte) ([]byte, []byte, bool) {
+	n, w := binary.Uvarint(b)
+	if w <= 0 || n > uint64(len(b)-w) {
+		return nil, nil, false
+	}
+	return b[w : w+int(n) : w+int(n)], b[w+int(n):], true
+}
+
+// bloom is a bloom filter of k probes, its last byte holds k
+type bloom []byte
+
+func hashKey(key []byte) uint32 {
+	h := fnv.New32a()
+	h.Write(key)
+	return h.Sum32()
+}
+
+func newBloom(hashes []uint32, bitsPerKey int) bloom {
+	// k = ln 2 * bits per key minimizes the false positives
+	k := min(max(bitsPerKey*69/100, 1), 30)
+	n := max(len(hashes)*bitsPerKey, 64)
+	b := make(bloom, (n+7)/8+1)
+	bits := uint32(len(b)-1) * 8
+	for _, h := range hashes {
+		delta := h>>17 | h<<15
+		for i := 0; i < k; i++ {
+			b[h%bits/8] |= 1 << (h % bits % 8)
+			h += delta
+		}
+	}
+	b[len(b)-1] = byte(k)
+	return b
+}
+
+func (b bloom) mayContain(h uint32) bool {
+	if len(b) < 2 {
+		return true
+	}
+	k, bits := int(b[len(b)-1]), uint32(len(b)-1)*8
+	delta := h>>17 | h<<15
+	for i := 0; i < k; i++ {
+		if b[h%bits/8]&(1<<(h%bits%8)) == 0 {
+			return false
+		}
+		h += delta
+	}
+	return true
+}
@@ -2,6 +2,7 @@ package odmap
 
 import (
 	"cmp"
+	"context"
 	"encoding/json"
 )
 
@@ -28,7 +29,139 @@ type feature[K cmp.Ordered, V any] interface {
 	json.Marshaler
 }
 
+// Versioner is implemented by the maps that version their entries
+type Versioner[K cmp.Ordered, V any] interface {
+	// LoadVersioned returns the value of key with its version. Versions come from a
+	// counter of the map that every write increases, they are never reused for a key,
+	// not even after it was deleted, and are never 0.
+	LoadVersioned(key K) (value V, version uint64, ok bool)
+
+	// CompareVersionAndSwap stores new for key if its version is still version
+	CompareVersionAndSwap(key K, version uint64, new V) bool
+
+	// CompareVersionAndDelete deletes key if its version is still version
+	CompareVersionAndDelete(key K, version uint64) bool
+}
+
+// Historian is implemented by the maps that can be read as of an earlier version
+type Historian[K cmp.Ordered, V any] interface {
+	// Version returns the version of the last write, the map as of now can be read
+	// later by passing it to LoadAt and RangeAt
+	Version() uint64
+
+	// LoadAt returns the value key had at version. Reads are exact for versions at
+	// or after the one passed to the last Prune. Without WithMVCC no history is kept
+	// and only the current version is exact.
+	LoadAt(key K, version uint64) (V, bool)
+
+	// RangeAt calls fn for every pair the map held at version, in key order
+	RangeAt(version uint64, fn func(key K, value V) bool)
+
+	// Prune drops the history that is only visible before version
+	Prune(version uint64)
+}
+
+// Stats describes the entries held by a map
+type Stats struct {
+	// Entries counts the keys held, tombstones included
+	Entries int64
+	// Tombstones counts the deleted keys held until the next compaction
+	Tombstones int64
+}
+
+// Compactor is implemented by the maps that hold deleted keys until a compaction
+type Compactor interface {
+	// Compact removes the deleted keys that are still held
+	Compact()
+	Stats() Stats
+}
+
+// Snapshot is a read-only view of a map as of the moment it was taken. It keeps
+// the versions it needs until Close, which must be called.
+type Snapshot[K cmp.Ordered, V any] interface {
+	Load(key K) (V, bool)
+	// Range calls fn for every pair in key order
+	Range(fn func(key K, value V) bool)
+	// Version returns the version of the map the snapshot was taken at
+	Version() uint64
+	Close()
+}
+
+// Snapshotter is implemented by the maps that can be read at a point in time
+type Snapshotter[K cmp.Ordered, V any] interface {
+	Snapshot() Snapshot[K, V]
+
+	// RangeSnapshot calls fn for every pair in key order as of the moment it is
+	// called, fn may write to the map meanwhile
+	RangeSnapshot(fn func(key K, value V) bool)
+}
+
+// Navigator is implemented by the maps that look up the neighbours of a key
+type Navigator[K cmp.Ordered, V any] interface {
+	// Floor returns the pair with the greatest key less than or equal to key
+	Floor(key K) (K, V, bool)
+	// Ceiling returns the pair with the least key greater than or equal to key
+	Ceiling(key K) (K, V, bool)
+}
+
+// Watcher is implemented by the maps that notify subscribers of their changes
+type Watcher[K cmp.Ordered, V any] interface {
+	// Watch calls fn, on a goroutine owned by the subscription, for every change
+	// matching opts until the returned cancel func is called. The changes come in
+	// the order the map applied them, a subscription whose fn falls further behind
+	// than WatchBuffer allows ends with an EventOverflow.
+	// Without WatchRange or WatchPrefix every key is watched. With safety_map the
+	// writers of a watched key take turns on a lock, the others are not held up.
+	Watch(fn func(Event[K, V]), opts ...WatchOption[K]) (cancel func())
+
+	// WaitFor returns the value of key as soon as cond holds for it, waiting for
+	// a write that makes it true or until ctx is done. cond may be called from
+	// the writing goroutines. Unsupported without safety_map, where no other
+	// goroutine may write: only the current value is checked, WaitFor fails with
+	// errors.ErrUnsupported if cond does not hold for it.
+	WaitFor(ctx context.Context, key K, cond func(V) bool) (V, error)
+}
+
+// Batcher is implemented by the maps that apply several writes atomically
+type Batcher[K cmp.Ordered, V any] interface {
+	// Apply checks every condition of b and, only if all of them hold, performs its
+	// writes in order. Readers observe either none or all of the writes.
+	Apply(b *Batch[K, V]) error
+}
+
+// Transactor is implemented by the maps that run optimistic transactions
+type Transactor[K cmp.Ordered, V any] interface {
+	// Txn runs fn in a transaction and commits its writes atomically if nothing fn
+	// read was modified meanwhile. A conflicting transaction is run again, Txn gives
+	// up with ErrConflict after a few attempts. An error returned by fn aborts the
+	// transaction and is returned as is.
+	Txn(fn func(tx Tx[K, V]) error) error
+}
+
+// Freezer is implemented by the maps that copy themselves into a FrozenMap
+type Freezer[K cmp.Ordered, V any] interface {
+	// Freeze returns an immutable copy of the pairs of the map as of the moment it
+	// is called, for data that is built once and then only read
+	Freeze(opts ...FreezeOption) *FrozenMap[K, V]
+}
+
+// Map is the core of every map of the package. The other features are optional
+// interfaces a Map may implement, the maps returned by New implement all of them.
 type Map[K cmp.Ordered, V any] interface {
 	internal[K, V]
 	feature[K, V]
 }
+
+// fullMap is a map implementing every optional interface, as returned by New
+type fullMap[K cmp.Ordered, V any] interface {
+	Map[K, V]
+	Navigator[K, V]
+	Compactor
+	Versioner[K, V]
+	Historian[K, V]
+	Snapshotter[K, V]
+	Watcher[K, V]
+	Batcher[K, V]
+	Transactor[K, V]
+	Freezer[K, V]
+}
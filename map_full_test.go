@@ -0,0 +1,25 @@
+package odmap_test
+
+import (
+	"cmp"
+
+	odmap "github.com/RealFax/order-map"
+)
+
+// fullMap is the map returned by New, which implements every optional interface
+type fullMap[K cmp.Ordered, V any] interface {
+	odmap.Map[K, V]
+	odmap.Navigator[K, V]
+	odmap.Compactor
+	odmap.Versioner[K, V]
+	odmap.Historian[K, V]
+	odmap.Snapshotter[K, V]
+	odmap.Watcher[K, V]
+	odmap.Batcher[K, V]
+	odmap.Transactor[K, V]
+	odmap.Freezer[K, V]
+}
+
+func newMap[K cmp.Ordered, V any](opts ...odmap.Option[K, V]) fullMap[K, V] {
+	return odmap.New[K, V](opts...).(fullMap[K, V])
+}
@@ -4,115 +4,118 @@ package odmap
 
 import (
 	"cmp"
+	"context"
 	"encoding/json"
+	"math"
+	"runtime"
 	"sync"
 	"sync/atomic"
 )
 
-type readonly[K cmp.Ordered, V any] struct {
-	m       *RBTree[K, V]
-	amended bool
-}
-
 type safetyMap[K cmp.Ordered, V any] struct {
 	compare func(K, K) int
 
+	// mu serializes the writers that replace keys
 	mu sync.Mutex
 
-	read atomic.Pointer[readonly[K, V]]
-
-	dirty *RBTree[K, V]
-
-	misses int
+	// keys maps every key to its slot. It is never modified, writers adding or removing
+	// a key publish a new tree sharing all but O(log n) nodes with the previous one.
+	keys atomic.Pointer[Persistent[K, *slot[V]]]
+
+	// clock is the last sequence number a write was stamped with
+	clock atomic.Uint64
+	// pinned counts the readers observing the map at each sequence number, oldest is
+	// the least of them. pinning counts the readers still picking theirs.
+	pinMu   sync.Mutex
+	pinned  map[uint64]int
+	oldest  atomic.Uint64
+	pinning atomic.Int64
+	// writers counts the lock-free writers in progress, exclusive sends new ones to the lock
+	writers   atomic.Int64
+	exclusive atomic.Bool
+
+	// mvcc keeps the versions written after horizon, horizon is the version passed to Prune
+	mvcc    bool
+	horizon atomic.Uint64
+
+	// dead counts the keys of the tree that are deleted. The tree is compacted once
+	// they reach threshold of its size and compactAt.
+	dead      atomic.Int64
+	compactAt atomic.Int64
+	threshold float64
+
+	watchers watchers[K, V]
 }
 
-func (m *safetyMap[K, V]) loadReadonly() readonly[K, V] {
-	if p := m.read.Load(); p != nil {
-		return *p
-	}
-	return readonly[K, V]{}
+// find returns the slot of key
+func (m *safetyMap[K, V]) find(key K) (*slot[V], bool) {
+	return m.keys.Load().Load(key)
 }
 
-func (m *safetyMap[K, V]) missLocked() {
-	m.misses++
-	if m.misses < m.dirty.Size() {
-		return
+// slotLocked returns the slot of key, adding an empty one for a missing key. The
+// slot is published before any cell is written to it, so that a reader observing
+// the write also finds the key.
+func (m *safetyMap[K, V]) slotLocked(key K) *slot[V] {
+	keys := m.keys.Load()
+	if s, ok := keys.Load(key); ok {
+		return s
 	}
-	m.read.Store(&readonly[K, V]{m: m.dirty})
-	m.dirty = nil
-	m.misses = 0
+	s := new(slot[V])
+	s.Store(newCell(empty[V](), cellDeleted, 0, nil))
+	m.keys.Store(keys.With(key, s))
+	m.dead.Add(1)
+	return s
 }
 
-func (m *safetyMap[K, V]) dirtyLocked() {
-	if m.dirty != nil {
-		return
+// update writes the existing slot of key without the lock, unless a batch is being
+// applied or key is watched. notify is called for a write before the next one may be
+// applied.
+func (m *safetyMap[K, V]) update(key K, s *slot[V], next func(cur *cell[V]) *cell[V], notify func(cur *cell[V])) (cur *cell[V], written bool) {
+	if m.enter(key) {
+		if cur, written, _ = m.write(s, next); written {
+			notify(cur)
+		}
+		m.leave()
+		return cur, written
 	}
 
-	read := m.loadReadonly()
-	m.dirty = NewRBTree[K, V](m.compare)
-
-	for iter := read.m.IterFirst(); iter.IsValid(); iter.Next() {
-		if !iter.node.tryExpungeLocked() {
-			m.dirty.put(iter.node.Key(), iter.node.Value())
-		}
+	m.mu.Lock()
+	if cur, written, _ = m.write(s, next); written {
+		notify(cur)
 	}
+	m.mu.Unlock()
+	return cur, written
 }
 
 func (m *safetyMap[K, V]) Load(key K) (V, bool) {
-	read := m.loadReadonly()
-	e, ok := read.m.get(key)
-	if !ok && read.amended {
-		m.mu.Lock()
-		read = m.loadReadonly()
-		e, ok = read.m.get(key)
-		if !ok && read.amended {
-			e, ok = m.dirty.get(key)
-			m.missLocked()
-		}
-		m.mu.Unlock()
-	}
+	s, ok := m.find(key)
 	if !ok {
 		return empty[V](), false
 	}
-	return e.load()
+	return m.current(s).load()
 }
 
 func (m *safetyMap[K, V]) Swap(key K, value V) (previous V, loaded bool) {
-	read := m.loadReadonly()
-	if e, ok := read.m.get(key); ok {
-		if v, ok := e.trySwap(&value); ok {
-			if v == nil {
-				return empty[V](), false
-			}
-			return *v, true
-		}
+	next := func(*cell[V]) *cell[V] {
+		return &cell[V]{value: value}
 	}
 
-	m.mu.Lock()
-	read = m.loadReadonly()
-	if e, ok := read.m.get(key); ok {
-		if e.unexpungeLocked() {
-			m.dirty.put(key, e.Value())
-		}
-
-		if v := e.swapLocked(&value); v != nil {
-			loaded = true
-			previous = *v
-		}
-	} else if e, ok := m.dirty.get(key); ok {
-		if v := e.swapLocked(&value); v != nil {
-			loaded = true
-			previous = *v
+	if s, ok := m.find(key); ok && m.enter(key) {
+		cur, _, ok := m.write(s, next)
+		if ok {
+			m.watchers.notify(EventStore, key, value)
 		}
-	} else {
-		if !read.amended {
-			m.dirtyLocked()
-			m.read.Store(&readonly[K, V]{m: read.m, amended: true})
+		m.leave()
+		if ok {
+			return cur.load()
 		}
-		m.dirty.put(key, value)
 	}
+
+	m.mu.Lock()
+	cur, _, _ := m.write(m.slotLocked(key), next)
+	m.watchers.notify(EventStore, key, value)
 	m.mu.Unlock()
-	return previous, loaded
+	return cur.load()
 }
 
 func (m *safetyMap[K, V]) Store(key K, value V) {
@@ -120,133 +123,473 @@ func (m *safetyMap[K, V]) Store(key K, value V) {
 }
 
 func (m *safetyMap[K, V]) LoadOrStore(key K, value V) (actual V, loaded bool) {
-	read := m.loadReadonly()
-	if e, ok := read.m.get(key); ok {
-		actual, loaded, ok := e.tryLoadOrStore(value)
-		if ok {
-			return actual, loaded
+	next := func(cur *cell[V]) *cell[V] {
+		if cur.live() {
+			return nil
 		}
+		return &cell[V]{value: value}
 	}
 
-	m.mu.Lock()
-	read = m.loadReadonly()
-	if e, ok := read.m.get(key); ok {
-		if e.unexpungeLocked() {
-			m.dirty.put(key, e.Value())
+	s, ok := m.find(key)
+	if ok && m.enter(key) {
+		cur, written, ok := m.write(s, next)
+		if written {
+			m.watchers.notify(EventStore, key, value)
 		}
-		actual, loaded, _ = e.tryLoadOrStore(value)
-	} else if e, ok := m.dirty.get(key); ok {
-		actual, loaded, _ = e.tryLoadOrStore(value)
-		m.missLocked()
-	} else {
-		if !read.amended {
-			m.dirtyLocked()
-			m.read.Store(&readonly[K, V]{m: read.m, amended: true})
+		m.leave()
+		if ok {
+			if written {
+				return value, false
+			}
+			return cur.value, true
 		}
-		m.dirty.put(key, value)
-		actual, loaded = value, false
+	}
+
+	m.mu.Lock()
+	cur, written, _ := m.write(m.slotLocked(key), next)
+	if written {
+		m.watchers.notify(EventStore, key, value)
 	}
 	m.mu.Unlock()
 
-	return actual, loaded
+	if written {
+		return value, false
+	}
+	return cur.value, true
 }
 
 func (m *safetyMap[K, V]) LoadAndDelete(key K) (V, bool) {
-	read := m.loadReadonly()
-	e, ok := read.m.get(key)
-	if !ok && read.amended {
-		m.mu.Lock()
-		read = m.loadReadonly()
-		e, ok = read.m.get(key)
-		if !ok && read.amended {
-			e, ok = m.dirty.get(key)
-			m.dirty.del(key)
-			m.missLocked()
-		}
-		m.mu.Unlock()
+	s, ok := m.find(key)
+	if !ok {
+		return empty[V](), false
 	}
-	if ok {
-		return e.delete()
+
+	cur, deleted := m.update(key, s, func(cur *cell[V]) *cell[V] {
+		if !cur.live() {
+			return nil
+		}
+		return &cell[V]{state: cellDeleted}
+	}, func(cur *cell[V]) {
+		m.watchers.notify(EventDelete, key, cur.value)
+	})
+	if !deleted {
+		return empty[V](), false
 	}
-	return empty[V](), false
+	return cur.value, true
 }
 
 func (m *safetyMap[K, V]) Delete(key K) {
 	_, _ = m.LoadAndDelete(key)
 }
 
-func (m *safetyMap[K, V]) CompareAndSwap(key K, old, new V) (swapped bool) {
-	read := m.loadReadonly()
-	if e, ok := read.m.get(key); ok {
-		return e.tryCompareAndSwap(old, new)
-	} else if !read.amended {
+func (m *safetyMap[K, V]) CompareAndSwap(key K, old, new V) bool {
+	s, ok := m.find(key)
+	if !ok {
 		return false
 	}
 
-	m.mu.Lock()
-	read = m.loadReadonly()
-	if e, ok := read.m.get(key); ok {
-		swapped = e.tryCompareAndSwap(old, new)
-	} else if e, ok := m.dirty.get(key); ok {
-		swapped = e.tryCompareAndSwap(old, new)
-		m.missLocked()
-	}
-	m.mu.Unlock()
-
+	_, swapped := m.update(key, s, func(cur *cell[V]) *cell[V] {
+		if !cur.live() || any(cur.value) != any(old) {
+			return nil
+		}
+		return &cell[V]{value: new}
+	}, func(*cell[V]) {
+		m.watchers.notify(EventStore, key, new)
+	})
 	return swapped
 }
 
 func (m *safetyMap[K, V]) CompareAndDelete(key K, old V) bool {
-	read := m.loadReadonly()
-	e, ok := read.m.get(key)
-	if !ok && read.amended {
-		m.mu.Lock()
-		read = m.loadReadonly()
-		e, ok = read.m.get(key)
-		if !ok && read.amended {
-			e, ok = m.dirty.get(key)
-			m.missLocked()
-		}
-		m.mu.Unlock()
+	s, ok := m.find(key)
+	if !ok {
+		return false
 	}
 
-	for ok {
-		p := e.value.Load()
-		if p == nil || p == e.expunged || any(*p) != any(old) {
-			return false
+	_, deleted := m.update(key, s, func(cur *cell[V]) *cell[V] {
+		if !cur.live() || any(cur.value) != any(old) {
+			return nil
 		}
+		return &cell[V]{state: cellDeleted}
+	}, func(*cell[V]) {
+		m.watchers.notify(EventDelete, key, old)
+	})
+	return deleted
+}
+
+func (m *safetyMap[K, V]) LoadVersioned(key K) (V, uint64, bool) {
+	s, ok := m.find(key)
+	if !ok {
+		return empty[V](), 0, false
+	}
+	c := m.current(s)
+	if !c.live() {
+		return empty[V](), 0, false
+	}
+	return c.value, c.seq.Load(), true
+}
+
+func (m *safetyMap[K, V]) CompareVersionAndSwap(key K, version uint64, new V) bool {
+	s, ok := m.find(key)
+	if !ok {
+		return false
+	}
 
-		if e.value.CompareAndSwap(p, nil) {
-			return true
+	_, swapped := m.update(key, s, func(cur *cell[V]) *cell[V] {
+		if !cur.live() || cur.seq.Load() != version {
+			return nil
 		}
+		return &cell[V]{value: new}
+	}, func(*cell[V]) {
+		m.watchers.notify(EventStore, key, new)
+	})
+	return swapped
+}
+
+func (m *safetyMap[K, V]) CompareVersionAndDelete(key K, version uint64) bool {
+	s, ok := m.find(key)
+	if !ok {
+		return false
 	}
-	return false
+
+	_, deleted := m.update(key, s, func(cur *cell[V]) *cell[V] {
+		if !cur.live() || cur.seq.Load() != version {
+			return nil
+		}
+		return &cell[V]{state: cellDeleted}
+	}, func(cur *cell[V]) {
+		m.watchers.notify(EventDelete, key, cur.value)
+	})
+	return deleted
 }
 
 func (m *safetyMap[K, V]) Range(fc func(key K, value V) bool) {
-	read := m.loadReadonly()
-	if read.amended {
-		m.mu.Lock()
-		read = m.loadReadonly()
-		if read.amended {
-			read = readonly[K, V]{m: m.dirty}
-			m.read.Store(&read)
-			m.dirty = nil
-			m.misses = 0
+	m.scan(interval[K]{}, fc)
+}
+
+func (m *safetyMap[K, V]) Floor(key K) (k K, v V, ok bool) {
+	snap := m.snapshot()
+	defer snap.Close()
+
+	// deleted keys are skipped
+	snap.keys.descend(interval[K]{hi: key, hasHi: true, closed: true}, func(key K, s *slot[V]) bool {
+		c := m.head(s).at(snap.seq)
+		if c.live() {
+			k, v, ok = key, c.value, true
 		}
-		m.mu.Unlock()
+		return !ok
+	})
+	return
+}
+
+func (m *safetyMap[K, V]) Ceiling(key K) (k K, v V, ok bool) {
+	snap := m.snapshot()
+	defer snap.Close()
+
+	snap.cells(interval[K]{lo: key, hasLo: true}, func(key K, c *cell[V]) bool {
+		k, v, ok = key, c.value, true
+		return false
+	})
+	return
+}
+
+// scan calls fn for every pair of r in key order, as of the moment scan is called
+func (m *safetyMap[K, V]) scan(r interval[K], fn func(key K, value V) bool) {
+	snap := m.snapshot()
+	defer snap.Close()
+
+	snap.cells(r, func(key K, c *cell[V]) bool {
+		return fn(key, c.value)
+	})
+}
+
+func (m *safetyMap[K, V]) Watch(fn func(Event[K, V]), opts ...WatchOption[K]) (cancel func()) {
+	return m.watchers.watch(fn, opts, m.scan)
+}
+
+func (m *safetyMap[K, V]) WaitFor(ctx context.Context, key K, cond func(V) bool) (V, error) {
+	return m.watchers.waitFor(ctx, key, cond, m.Load)
+}
+
+// Apply writes every cell of the batch with the same sequence number and publishes it
+// once they are all in place, so readers see either none or all of them. Lock-free
+// writers are held off meanwhile.
+func (m *safetyMap[K, V]) Apply(b *Batch[K, V]) error {
+	m.lockExclusive()
+	defer m.unlockExclusive()
+
+	return m.applyLocked(b)
+}
+
+// lockExclusive takes the lock and waits for the lock-free writers to leave, their
+// notifications included
+func (m *safetyMap[K, V]) lockExclusive() {
+	m.mu.Lock()
+	m.exclusive.Store(true)
+	for m.writers.Load() != 0 {
+		runtime.Gosched()
+	}
+}
+
+func (m *safetyMap[K, V]) unlockExclusive() {
+	m.exclusive.Store(false)
+	m.mu.Unlock()
+}
+
+// applyLocked notifies the writes of the batch once they are published, in order
+func (m *safetyMap[K, V]) applyLocked(b *Batch[K, V]) error {
+	if err := b.check(m.loadLocked); err != nil {
+		return err
 	}
 
-	for iter := read.m.IterFirst(); iter.IsValid(); iter.Next() {
-		v, ok := iter.node.load()
-		if !ok {
+	seq := m.clock.Load() + 1
+	installed := make([]*cell[V], 0, len(b.ops))
+	events := make([]Event[K, V], 0, len(b.ops))
+	for _, op := range b.ops {
+		var s *slot[V]
+		if op.delete {
+			if s, _ = m.find(op.key); s == nil {
+				continue
+			}
+		} else {
+			s = m.slotLocked(op.key)
+		}
+		cur := s.Load()
+
+		var c *cell[V]
+		switch {
+		case op.delete && !cur.live():
 			continue
+		case op.delete:
+			c = newCell(empty[V](), cellDeleted, seq, cur)
+			events = append(events, Event[K, V]{Kind: EventDelete, Key: op.key, Value: cur.value})
+		default:
+			c = newCell(op.value, cellLive, seq, cur)
+			events = append(events, Event[K, V]{Kind: EventStore, Key: op.key, Value: op.value})
+		}
+
+		s.Store(c)
+		m.account(cur, c)
+		installed = append(installed, c)
+	}
+
+	m.clock.Store(seq)
+	for _, c := range installed {
+		m.release(c)
+	}
+	for _, ev := range events {
+		m.watchers.notify(ev.Kind, ev.Key, ev.Value)
+	}
+	return nil
+}
+
+func (m *safetyMap[K, V]) loadLocked(key K) (V, bool) {
+	s, ok := m.find(key)
+	if !ok {
+		return empty[V](), false
+	}
+	return m.head(s).load()
+}
+
+// Txn reads from a snapshot pinned for the duration of fn and validates the versions
+// it read while committing, writers are only held off during the commit
+func (m *safetyMap[K, V]) Txn(fn func(tx Tx[K, V]) error) error {
+	for attempt := 0; attempt < maxTxnAttempts; attempt++ {
+		tx, err := m.read(fn)
+		if err != nil {
+			return err
 		}
 
-		if !fc(iter.Key(), v) {
-			break
+		m.lockExclusive()
+		valid := tx.validate(m.versionLocked, m.versionsLocked)
+		if valid {
+			_ = m.applyLocked(tx.batch())
 		}
+		m.unlockExclusive()
+
+		if valid {
+			return nil
+		}
+	}
+	return ErrConflict
+}
+
+// read runs fn on a transaction reading from a snapshot, which is released even if fn
+// panics so that it does not hold off compaction
+func (m *safetyMap[K, V]) read(fn func(tx Tx[K, V]) error) (*txn[K, V], error) {
+	snap := m.snapshot()
+	defer snap.Close()
+
+	tx := newTxn[K, V](m.compare, snap)
+	return tx, fn(tx)
+}
+
+// versionLocked returns the version of a live key
+func (m *safetyMap[K, V]) versionLocked(key K) (uint64, bool) {
+	s, ok := m.find(key)
+	if !ok {
+		return 0, false
+	}
+	if c := m.head(s); c.live() {
+		return c.seq.Load(), true
+	}
+	return 0, false
+}
+
+// versionsLocked calls fn for every live key of r in key order, with its version
+func (m *safetyMap[K, V]) versionsLocked(r interval[K], fn func(key K, seq uint64) bool) {
+	m.keys.Load().ascend(r, func(key K, s *slot[V]) bool {
+		c := m.head(s)
+		return !c.live() || fn(key, c.seq.Load())
+	})
+}
+
+// snapshot is the map as of a pinned sequence number, it also serves as the source
+// of a transaction
+type snapshot[K cmp.Ordered, V any] struct {
+	m      *safetyMap[K, V]
+	keys   *Persistent[K, *slot[V]]
+	seq    uint64
+	pin    uint64
+	closed atomic.Bool
+}
+
+// snapshot pins the map before picking the tree, every key visible at the pinned
+// sequence number is then in it
+func (m *safetyMap[K, V]) snapshot() *snapshot[K, V] {
+	seq := m.pin()
+	return &snapshot[K, V]{m: m, keys: m.keys.Load(), seq: seq, pin: seq}
+}
+
+func (m *safetyMap[K, V]) Snapshot() Snapshot[K, V] {
+	return m.snapshot()
+}
+
+// RangeSnapshot calls fn for every pair as of the moment it is called, writers are
+// not held off meanwhile
+func (m *safetyMap[K, V]) RangeSnapshot(fn func(key K, value V) bool) {
+	m.scan(interval[K]{}, fn)
+}
+
+// Freeze copies the pairs of a snapshot, writers are not held off meanwhile
+func (m *safetyMap[K, V]) Freeze(opts ...FreezeOption) *FrozenMap[K, V] {
+	var keys []K
+	var values []V
+	m.RangeSnapshot(func(key K, value V) bool {
+		keys, values = append(keys, key), append(values, value)
+		return true
+	})
+	return newFrozenMap(m.compare, keys, values, opts)
+}
+
+func (s *snapshot[K, V]) cell(key K) *cell[V] {
+	p, ok := s.keys.Load(key)
+	if !ok {
+		return nil
+	}
+	return s.m.head(p).at(s.seq)
+}
+
+// cells calls fn for every key of r that is live in the snapshot, with its version
+func (s *snapshot[K, V]) cells(r interval[K], fn func(key K, c *cell[V]) bool) {
+	s.keys.ascend(r, func(key K, p *slot[V]) bool {
+		c := s.m.head(p).at(s.seq)
+		return !c.live() || fn(key, c)
+	})
+}
+
+func (s *snapshot[K, V]) Load(key K) (V, bool) {
+	return s.cell(key).load()
+}
+
+func (s *snapshot[K, V]) Range(fn func(key K, value V) bool) {
+	s.cells(interval[K]{}, func(key K, c *cell[V]) bool {
+		return fn(key, c.value)
+	})
+}
+
+func (s *snapshot[K, V]) Version() uint64 {
+	return s.seq
+}
+
+func (s *snapshot[K, V]) Close() {
+	if s.closed.CompareAndSwap(false, true) {
+		s.m.unpin(s.pin)
+	}
+}
+
+func (s *snapshot[K, V]) get(key K) (V, uint64, bool) {
+	c := s.cell(key)
+	if !c.live() {
+		return empty[V](), 0, false
+	}
+	return c.value, c.seq.Load(), true
+}
+
+func (s *snapshot[K, V]) scan(r interval[K], fn func(key K, value V, seq uint64) bool) {
+	s.cells(r, func(key K, c *cell[V]) bool {
+		return fn(key, c.value, c.seq.Load())
+	})
+}
+
+func (m *safetyMap[K, V]) Version() uint64 {
+	return m.clock.Load()
+}
+
+func (m *safetyMap[K, V]) LoadAt(key K, version uint64) (V, bool) {
+	s, ok := m.find(key)
+	if !ok {
+		return empty[V](), false
+	}
+	// cells of a batch being applied are newer than the clock
+	return m.head(s).at(min(version, m.clock.Load())).load()
+}
+
+func (m *safetyMap[K, V]) RangeAt(version uint64, fn func(key K, value V) bool) {
+	snap := m.snapshot()
+	defer snap.Close()
+
+	snap.seq = min(version, snap.seq)
+	snap.Range(fn)
+}
+
+// Prune drops the cells hidden by a newer one written at or before version. Cells
+// that a concurrent reader may still need are left to the writes that follow.
+func (m *safetyMap[K, V]) Prune(version uint64) {
+	if !m.mvcc {
+		return
+	}
+
+	m.lockExclusive()
+	defer m.unlockExclusive()
+
+	version = min(version, m.clock.Load())
+	if version <= m.horizon.Load() {
+		return
 	}
+	m.horizon.Store(version)
+
+	// readers pinned from now on observe the clock, which writers cannot move meanwhile
+	seq := min(version, m.visible())
+	m.keys.Load().ascend(interval[K]{}, func(key K, s *slot[V]) bool {
+		trim(m.head(s), seq)
+		return true
+	})
+}
+
+// loadSorted fills the empty map with pairs in increasing key order, stamped with
+// consecutive versions
+func (m *safetyMap[K, V]) loadSorted(keys []K, values []V) error {
+	if !sortedKeys(m.compare, keys) {
+		return ErrKeyOrder
+	}
+	slots := make([]*slot[V], len(values))
+	for i, value := range values {
+		slots[i] = new(slot[V])
+		slots[i].Store(newCell(value, cellLive, uint64(i+1), nil))
+	}
+	m.keys.Store(persistentOf(m.compare, keys, slots))
+	m.clock.Store(uint64(len(keys)))
+	return nil
 }
 
 func (m *safetyMap[K, V]) Len() int64 { return 0 }
@@ -256,8 +599,11 @@ func (m *safetyMap[K, V]) Contains(key K) bool {
 }
 
 func (m *safetyMap[K, V]) MarshalJSON() ([]byte, error) {
+	snap := m.snapshot()
+	defer snap.Close()
+
 	s := make([]Pair[K, V], 0, 1024)
-	m.Range(func(key K, value V) bool {
+	snap.Range(func(key K, value V) bool {
 		s = append(s, Pair[K, V]{Key: key, Value: value})
 		return true
 	})
@@ -265,7 +611,13 @@ func (m *safetyMap[K, V]) MarshalJSON() ([]byte, error) {
 }
 
 func New[K cmp.Ordered, V any](opts ...Option[K, V]) Map[K, V] {
-	m := &safetyMap[K, V]{}
+	return newMap[K, V](opts...)
+}
+
+// newMap returns the map New returns, with every optional interface
+func newMap[K cmp.Ordered, V any](opts ...Option[K, V]) fullMap[K, V] {
+	m := &safetyMap[K, V]{threshold: defaultCompactionThreshold, pinned: make(map[uint64]int)}
+	m.oldest.Store(math.MaxUint64)
 
 	for _, opt := range opts {
 		opt(m)
@@ -277,8 +629,15 @@ func New[K cmp.Ordered, V any](opts ...Option[K, V]) Map[K, V] {
 		}
 	}
 
-	m.read.Store(&readonly[K, V]{m: NewRBTree[K, V](m.compare), amended: true})
-	m.dirty = NewRBTree[K, V](m.compare)
+	m.watchers.compare = m.compare
+	m.watchers.hold = func(fn func()) {
+		m.lockExclusive()
+		defer m.unlockExclusive()
+		fn()
+	}
+
+	m.keys.Store(NewPersistentFunc[K, *slot[V]](m.compare))
+	m.compactAt.Store(minCompaction)
 
 	return m
 }
@@ -0,0 +1,202 @@
+//go:build safety_map
+
+package odmap
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+type cellState uint8
+
+const (
+	cellLive cellState = iota
+	cellDeleted
+	// cellExpunged marks a deleted slot that was removed from the tree, a writer
+	// holding it must look the key up again
+	cellExpunged
+)
+
+// slot holds the cell chain of a key, it stays the same for as long as the key is
+// in the tree
+type slot[V any] struct {
+	atomic.Pointer[cell[V]]
+}
+
+// unstamped is the sequence number of a cell that was installed by a lock-free
+// writer but not stamped yet
+const unstamped = math.MaxUint64
+
+// cell is one version of the value of an entry. Every write installs a new cell
+// stamped with the sequence number of the write and linked to the cell it replaced,
+// so that readers pinned to an older sequence number can still find their version.
+type cell[V any] struct {
+	value V
+	seq   atomic.Uint64
+	state cellState
+	prev  atomic.Pointer[cell[V]]
+}
+
+func newCell[V any](value V, state cellState, seq uint64, prev *cell[V]) *cell[V] {
+	c := &cell[V]{value: value, state: state}
+	c.seq.Store(seq)
+	if prev != nil {
+		c.prev.Store(prev)
+	}
+	return c
+}
+
+func (c *cell[V]) live() bool {
+	return c != nil && c.state == cellLive
+}
+
+func (c *cell[V]) load() (V, bool) {
+	if !c.live() {
+		return empty[V](), false
+	}
+	return c.value, true
+}
+
+// at returns the newest version of the chain that was written at or before seq
+func (c *cell[V]) at(seq uint64) *cell[V] {
+	for c != nil && c.seq.Load() > seq {
+		c = c.prev.Load()
+	}
+	return c
+}
+
+// current returns the newest published cell of the slot s, skipping the cells of a batch
+// that is still being applied
+func (m *safetyMap[K, V]) current(s *slot[V]) *cell[V] {
+	c := m.head(s)
+	for c != nil && c.seq.Load() > m.clock.Load() {
+		prev := c.prev.Load()
+		if c.seq.Load() <= m.clock.Load() {
+			// published meanwhile, prev might already be released
+			break
+		}
+		c = prev
+	}
+	return c
+}
+
+// head returns the newest cell of s, stamped
+func (m *safetyMap[K, V]) head(s *slot[V]) *cell[V] {
+	return m.stamp(s.Load())
+}
+
+// stamp gives an installed cell the next sequence number unless it has one. The
+// number is only taken once the cell is in place, by its writer or by whoever meets
+// it first, so that a cell never shows up at or before the sequence number of a
+// reader that pinned the map without it.
+func (m *safetyMap[K, V]) stamp(c *cell[V]) *cell[V] {
+	if c != nil && c.seq.Load() == unstamped {
+		c.seq.CompareAndSwap(unstamped, m.clock.Add(1))
+	}
+	return c
+}
+
+// write installs the cell next returns for the current cell of s, next returns nil
+// to leave s untouched. It reports false for an expunged slot, the key has to be
+// looked up again.
+func (m *safetyMap[K, V]) write(s *slot[V], next func(cur *cell[V]) *cell[V]) (cur *cell[V], written, ok bool) {
+	for {
+		cur = m.head(s)
+		if cur.state == cellExpunged {
+			return cur, false, false
+		}
+
+		c := next(cur)
+		if c == nil {
+			return cur, false, true
+		}
+
+		c.seq.Store(unstamped)
+		c.prev.Store(cur)
+		if s.CompareAndSwap(cur, c) {
+			m.stamp(c)
+			m.release(c)
+			m.account(cur, c)
+			return cur, true, true
+		}
+	}
+}
+
+// release drops the versions older than c that no pinned reader may need, in MVCC
+// mode only the ones hidden before the horizon
+func (m *safetyMap[K, V]) release(c *cell[V]) {
+	if m.pinning.Load() != 0 {
+		// a reader picking its sequence number may need any of them
+		return
+	}
+	seq := m.oldest.Load()
+	if m.mvcc {
+		seq = min(seq, m.horizon.Load())
+	}
+	trim(c, seq)
+}
+
+// trim cuts the chain of c below the newest cell written at or before seq
+func trim[V any](c *cell[V], seq uint64) {
+	if c = c.at(seq); c != nil {
+		c.prev.Store(nil)
+	}
+}
+
+// pin returns the sequence number a reader observes the map at, the versions it may
+// need are kept until the matching unpin. The reader counts as pinning before it
+// reads the clock, so that release keeps everything until it is registered.
+func (m *safetyMap[K, V]) pin() uint64 {
+	m.pinning.Add(1)
+	defer m.pinning.Add(-1)
+
+	m.pinMu.Lock()
+	defer m.pinMu.Unlock()
+	seq := m.clock.Load()
+	m.pinned[seq]++
+	if seq < m.oldest.Load() {
+		m.oldest.Store(seq)
+	}
+	return seq
+}
+
+func (m *safetyMap[K, V]) unpin(seq uint64) {
+	m.pinMu.Lock()
+	defer m.pinMu.Unlock()
+	if m.pinned[seq]--; m.pinned[seq] != 0 {
+		return
+	}
+	delete(m.pinned, seq)
+	if seq == m.oldest.Load() {
+		oldest := uint64(math.MaxUint64)
+		for pinned := range m.pinned {
+			oldest = min(oldest, pinned)
+		}
+		m.oldest.Store(oldest)
+	}
+}
+
+// visible returns the oldest sequence number a reader may observe the map at, the
+// readers pinned from now on observe at least the current clock
+func (m *safetyMap[K, V]) visible() uint64 {
+	m.pinMu.Lock()
+	defer m.pinMu.Unlock()
+	return min(m.oldest.Load(), m.clock.Load())
+}
+
+// enter registers a lock-free writer of key, it fails while a batch is being applied
+// or key is watched: the writers of a watched key take turns so that they are
+// notified in order. A subscription is only added with the writers held off, so a
+// key is not watched behind the back of a writer that entered.
+func (m *safetyMap[K, V]) enter(key K) bool {
+	m.writers.Add(1)
+	if m.exclusive.Load() || m.watchers.watched(key) {
+		m.writers.Add(-1)
+		return false
+	}
+	return true
+}
+
+func (m *safetyMap[K, V]) leave() {
+	m.writers.Add(-1)
+}
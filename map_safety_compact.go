@@ -0,0 +1,89 @@
+//go:build safety_map
+
+package odmap
+
+const (
+	// defaultCompactionThreshold is the share of deleted keys that triggers a compaction
+	defaultCompactionThreshold = 0.25
+	// minCompaction is the number of deleted keys below which the tree is never compacted
+	minCompaction = 128
+)
+
+// account tracks the deleted keys of the tree as cur is replaced by next
+func (m *safetyMap[K, V]) account(cur, next *cell[V]) {
+	switch {
+	case cur.live() && !next.live():
+		if dead := m.dead.Add(1); m.threshold > 0 && dead >= m.compactAt.Load() &&
+			float64(dead) >= m.threshold*float64(m.keys.Load().Len()) {
+			m.tryCompact()
+		}
+	case !cur.live() && next.live():
+		m.dead.Add(-1)
+	}
+}
+
+// tryCompact compacts the tree unless another goroutine holds the lock, that one
+// is either compacting or will trigger a compaction with its next delete
+func (m *safetyMap[K, V]) tryCompact() {
+	if !m.mu.TryLock() {
+		return
+	}
+	m.compactLocked()
+	m.mu.Unlock()
+}
+
+// Compact removes the deleted keys from the tree. Keys deleted after the oldest
+// version a concurrent Range or Txn observes and, in MVCC mode, keys deleted after
+// the horizon are kept.
+func (m *safetyMap[K, V]) Compact() {
+	m.mu.Lock()
+	m.compactLocked()
+	m.mu.Unlock()
+}
+
+func (m *safetyMap[K, V]) compactLocked() {
+	// whatever is left is only retried once it has doubled, so a tree full of keys that
+	// cannot be removed yet is not scanned on every delete
+	defer func() {
+		m.compactAt.Store(max(minCompaction, 2*m.dead.Load()))
+	}()
+
+	// a reader pinned before the deletion of a key may still pick the tree holding it,
+	// so only the keys every reader observes as deleted are removed
+	seq := m.visible()
+	if m.mvcc {
+		seq = min(seq, m.horizon.Load())
+	}
+	keys := m.keys.Load()
+	next, removed := keys, int64(0)
+	keys.ascend(interval[K]{}, func(key K, s *slot[V]) bool {
+		if m.tryExpungeLocked(s, seq) {
+			next = next.Without(key)
+			removed++
+		}
+		return true
+	})
+	if removed != 0 {
+		m.keys.Store(next)
+		m.dead.Add(-removed)
+	}
+}
+
+// tryExpungeLocked marks a deleted slot as removed from the tree, lock-free writers
+// holding it then fall back to the lock. Only a slot deleted at or before seq is
+// marked, the readers observing it at a later version see the deletion either way.
+func (m *safetyMap[K, V]) tryExpungeLocked(s *slot[V], seq uint64) bool {
+	for {
+		cur := m.head(s)
+		if cur.live() || cur.state == cellExpunged || cur.seq.Load() > seq {
+			return false
+		}
+		if s.CompareAndSwap(cur, newCell(cur.value, cellExpunged, cur.seq.Load(), cur.prev.Load())) {
+			return true
+		}
+	}
+}
+
+func (m *safetyMap[K, V]) Stats() Stats {
+	return Stats{Entries: m.keys.Load().Len(), Tombstones: m.dead.Load()}
+}
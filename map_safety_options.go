@@ -11,3 +11,31 @@ func WithComparer[K cmp.Ordered, V any](comparer func(K, K) int) Option[K, V] {
 		m.compare = comparer
 	}
 }
+
+// WithMVCC keeps the values replaced or deleted by every write, so that LoadAt and
+// RangeAt can read any earlier version until it is pruned
+func WithMVCC[K cmp.Ordered, V any]() Option[K, V] {
+	return func(m *safetyMap[K, V]) {
+		m.mvcc = true
+	}
+}
+
+// WithCompactionThreshold sets the share of deleted keys, between 0 and 1, at which
+// the map compacts itself. A threshold of 0 leaves compaction to Compact.
+func WithCompactionThreshold[K cmp.Ordered, V any](threshold float64) Option[K, V] {
+	return func(m *safetyMap[K, V]) {
+		m.threshold = threshold
+	}
+}
+
+// WithBTree only applies to the single-threaded map, this map keeps its keys in a
+// persistent tree that readers traverse without locks
+func WithBTree[K cmp.Ordered, V any](degree int) Option[K, V] {
+	return func(m *safetyMap[K, V]) {}
+}
+
+// WithBackend only applies to the single-threaded map, this map keeps its keys in a
+// persistent tree of its own and ignores newBackend
+func WithBackend[K cmp.Ordered, V any](newBackend func(compare func(K, K) int) Backend[K, Versioned[V]]) Option[K, V] {
+	return func(m *safetyMap[K, V]) {}
+}
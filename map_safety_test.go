@@ -0,0 +1,661 @@
+//go:build safety_map
+
+package odmap_test
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	odmap "github.com/RealFax/order-map"
+)
+
+// backendOptions creates maps over every backend, this map takes none
+var backendOptions = map[string][]odmap.Option[int, int]{
+	"persistent": nil,
+}
+
+func TestSafetyMap_WaitFor(t *testing.T) {
+	m := newMap[string, int]()
+
+	var wg sync.WaitGroup
+	results := make([]int, 8)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			v, err := m.WaitFor(ctx, "counter", func(v int) bool { return v >= i })
+			if err != nil {
+				t.Error(err)
+			}
+			results[i] = v
+		}(i)
+	}
+
+	for i := 0; i < len(results); i++ {
+		time.Sleep(time.Millisecond)
+		m.Store("counter", i)
+	}
+	wg.Wait()
+
+	for i, v := range results {
+		if v < i {
+			t.Fatalf("waiter %d woke up with %d", i, v)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := m.WaitFor(ctx, "counter", func(v int) bool { return v > len(results) }); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestSafetyMap_RangePointInTime(t *testing.T) {
+	const keys = 64
+
+	m := newMap[int, int]()
+	for k := 0; k < keys; k++ {
+		m.Store(k, 0)
+	}
+
+	// every round stores its number to the keys in increasing order, so a range taken
+	// at a single point in time never sees a key newer than the ones before it
+	var rounds atomic.Int64
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for round := 1; ; round++ {
+			for k := 0; k < keys; k++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				m.Store(k, round)
+			}
+			rounds.Store(int64(round))
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	for rounds.Load() < 1000 {
+		first, prev, n := -1, -1, 0
+		m.Range(func(key int, value int) bool {
+			if first < 0 {
+				first = value
+			}
+			if prev >= 0 && value > prev {
+				t.Fatalf("key %d holds round %d after round %d", key, value, prev)
+			}
+			prev = value
+			n++
+			return true
+		})
+		if n != keys || first-prev > 1 {
+			t.Fatalf("range saw %d keys spanning rounds %d to %d", n, prev, first)
+		}
+	}
+}
+
+func TestSafetyMap_CompareAndSwapConcurrent(t *testing.T) {
+	const (
+		writers    = 8
+		increments = 1000
+	)
+
+	m := newMap[string, int]()
+	var (
+		wg      sync.WaitGroup
+		created atomic.Int64
+	)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, loaded := m.LoadOrStore("counter", 0); !loaded {
+				created.Add(1)
+			}
+			for i := 0; i < increments; i++ {
+				for {
+					v, _ := m.Load("counter")
+					if m.CompareAndSwap("counter", v, v+1) {
+						break
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := created.Load(); n != 1 {
+		t.Fatalf("LoadOrStore created the key %d times", n)
+	}
+	if v, _ := m.Load("counter"); v != writers*increments {
+		t.Fatalf("counter = %d, want %d", v, writers*increments)
+	}
+}
+
+func TestSafetyMap_ApplyAtomic(t *testing.T) {
+	const (
+		accounts = 16
+		total    = accounts * 100
+	)
+
+	m := newMap[int, int]()
+	for i := 0; i < accounts; i++ {
+		m.Store(i, total/accounts)
+	}
+
+	stop := make(chan struct{})
+	var (
+		wg      sync.WaitGroup
+		applied atomic.Int64
+	)
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				from, to := (i+w)%accounts, (i*7+w+1)%accounts
+				if from == to {
+					continue
+				}
+				a, _ := m.Load(from)
+				b, _ := m.Load(to)
+				if m.Apply(new(odmap.Batch[int, int]).
+					Expect(from, a).
+					Expect(to, b).
+					Put(from, a-1).
+					Put(to, b+1)) == nil {
+					applied.Add(1)
+				}
+			}
+		}(w)
+	}
+
+	// unrelated lock-free writers
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			m.Store(accounts+i%8, i)
+		}
+	}()
+
+	for applied.Load() < 5000 {
+		sum := 0
+		m.Range(func(key int, value int) bool {
+			if key < accounts {
+				sum += value
+			}
+			return true
+		})
+		if sum != total {
+			close(stop)
+			wg.Wait()
+			t.Fatalf("range observed a partial batch, sum = %d", sum)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestSafetyMap_TxnTransfer(t *testing.T) {
+	const (
+		accounts = 8
+		total    = accounts * 100
+	)
+	m := newMap[int, int]()
+	for i := 0; i < accounts; i++ {
+		m.Store(i, 100)
+	}
+
+	var (
+		wg        sync.WaitGroup
+		committed atomic.Int64
+	)
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				from, to := (i+w)%accounts, (i*3+w+1)%accounts
+				if from == to {
+					continue
+				}
+				err := m.Txn(func(tx odmap.Tx[int, int]) error {
+					a, _ := tx.Load(from)
+					b, _ := tx.Load(to)
+					// let other transactions commit in between
+					runtime.Gosched()
+					tx.Store(from, a-1)
+					tx.Store(to, b+1)
+					return nil
+				})
+				if err == nil {
+					committed.Add(1)
+				}
+			}
+		}(w)
+	}
+
+	// read-only transactions observe a consistent total
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			sum := 0
+			_ = m.Txn(func(tx odmap.Tx[int, int]) error {
+				sum = 0
+				tx.Range(func(key int, value int) bool {
+					sum += value
+					return true
+				})
+				return nil
+			})
+			if sum != total {
+				t.Errorf("transaction observed sum %d, want %d", sum, total)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+
+	sum := 0
+	m.Range(func(key int, value int) bool {
+		sum += value
+		return true
+	})
+	if sum != total {
+		t.Fatalf("lost update: sum = %d, want %d", sum, total)
+	}
+	if committed.Load() == 0 {
+		t.Fatal("no transaction committed")
+	}
+}
+
+func TestSafetyMap_CompareVersionAndSwap(t *testing.T) {
+	const (
+		workers = 4
+		adds    = 1000
+	)
+	m := newMap[string, int]()
+	m.Store("counter", 0)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < adds; {
+				value, version, _ := m.LoadVersioned("counter")
+				if m.CompareVersionAndSwap("counter", version, value+1) {
+					i++
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if value, _ := m.Load("counter"); value != workers*adds {
+		t.Fatalf("counter = %d, want %d", value, workers*adds)
+	}
+}
+
+// BenchmarkSafetyMap_StoreAfterRange adds a key after every full scan, which used
+// to rebuild the whole tree
+func BenchmarkSafetyMap_StoreAfterRange(b *testing.B) {
+	const size = 100000
+	m := newMap[int, int]()
+	for i := 0; i < size; i++ {
+		m.Store(i, i)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Range(func(key int, value int) bool { return false })
+		m.Store(size+i, i)
+	}
+}
+
+// readMostlyMaps are the concurrent maps compared on read-mostly workloads
+var readMostlyMaps = []struct {
+	name string
+	new  func() odmap.Map[int, int]
+}{
+	{"safety", func() odmap.Map[int, int] { return odmap.New[int, int]() }},
+	{"rwmutex", odmap.NewRWMap[int, int]},
+}
+
+// BenchmarkScanMostly compares the concurrent map with RWMap on range scans mixed
+// with a trickle of new keys, one write per writeEvery reads
+func BenchmarkScanMostly(b *testing.B) {
+	const (
+		size       = 10000
+		scan       = 100
+		writeEvery = 32
+	)
+	for _, bm := range readMostlyMaps {
+		b.Run(bm.name, func(b *testing.B) {
+			m := bm.new()
+			for i := 0; i < size; i++ {
+				m.Store(2*i, i)
+			}
+			var next atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					if i%writeEvery == 0 {
+						m.Store(2*size+int(next.Add(1)), i)
+						continue
+					}
+					from := i * 7919 % (2 * size)
+					n := 0
+					m.Range(func(key int, value int) bool {
+						if key >= from {
+							n++
+						}
+						return n < scan
+					})
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkLoadMostly(b *testing.B) {
+	const size = 10000
+	for _, bm := range readMostlyMaps {
+		b.Run(bm.name, func(b *testing.B) {
+			m := bm.new()
+			for i := 0; i < size; i++ {
+				m.Store(i, i)
+			}
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					if i%32 == 0 {
+						m.Store(i%size, i)
+					} else {
+						m.Load(i * 7919 % size)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestSafetyMap_AutoCompact(t *testing.T) {
+	m := newMap[int, int](odmap.WithCompactionThreshold[int, int](0.5))
+	for i := 0; i < 4000; i++ {
+		m.Store(i, i)
+	}
+	for i := 0; i < 3000; i++ {
+		m.Delete(i)
+	}
+	if s := m.Stats(); s.Tombstones >= 2000 || s.Entries-s.Tombstones != 1000 {
+		t.Fatalf("got %+v, want the tree to be compacted", s)
+	}
+}
+
+func TestSafetyMap_CompactConcurrent(t *testing.T) {
+	const (
+		workers = 4
+		keys    = 512
+	)
+	m := newMap[int, int](odmap.WithCompactionThreshold[int, int](0.1))
+
+	// every worker owns the keys equal to its index modulo workers
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			model := make(map[int]int)
+			for i := 0; i < 20000; i++ {
+				key := (i*7919)%keys/workers*workers + w
+				if i%3 == 0 {
+					m.Delete(key)
+					delete(model, key)
+				} else {
+					m.Store(key, i)
+					model[key] = i
+				}
+				if i%1000 == 0 {
+					m.Compact()
+				}
+			}
+			for key := w; key < keys; key += workers {
+				v, ok := m.Load(key)
+				if want, present := model[key]; ok != present || v != want {
+					t.Errorf("key %d: got %d, %v, want %d, %v", key, v, ok, want, present)
+				}
+			}
+		}(w)
+	}
+
+	// concurrent readers keep pinning the map
+	stop := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			m.Range(func(key int, value int) bool { return key < keys/2 })
+		}
+	}()
+	wg.Wait()
+	close(stop)
+}
+
+func TestSafetyMap_CompactPinned(t *testing.T) {
+	const keys = 1000
+	m := newMap[int, int](odmap.WithCompactionThreshold[int, int](0))
+	for i := 0; i < keys; i++ {
+		m.Store(i, i)
+	}
+	for i := 0; i < keys/2; i++ {
+		m.Delete(i)
+	}
+
+	// the keys deleted before the snapshot was taken are removed, the others are kept
+	snap := m.Snapshot()
+	for i := keys / 2; i < keys; i++ {
+		m.Delete(i)
+	}
+	m.Compact()
+	if s := m.Stats(); s.Entries != keys/2 || s.Tombstones != keys/2 {
+		t.Fatalf("got %+v, want %d entries and tombstones", s, keys/2)
+	}
+	n := 0
+	snap.Range(func(key int, value int) bool {
+		if key != keys/2+n || value != key {
+			t.Fatalf("got %d: %d after %d pairs", key, value, n)
+		}
+		n++
+		return true
+	})
+	if n != keys/2 {
+		t.Fatalf("got %d pairs, want %d", n, keys/2)
+	}
+
+	snap.Close()
+	m.Compact()
+	if s := m.Stats(); s.Entries != 0 || s.Tombstones != 0 {
+		t.Fatalf("got %+v, want an empty tree", s)
+	}
+}
+
+func TestSafetyMap_SnapshotConcurrent(t *testing.T) {
+	const keys = 1000
+	m := newMap[int, int]()
+	for i := 0; i < keys; i++ {
+		m.Store(i, i)
+	}
+	snap := m.Snapshot()
+	defer snap.Close()
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < keys; i += 4 {
+				if i%2 == 0 {
+					m.Delete(i)
+				} else {
+					m.Store(i, -i)
+				}
+				m.Store(keys+i, i)
+			}
+		}(w)
+	}
+
+	for round := 0; round < 10; round++ {
+		n := 0
+		snap.Range(func(key int, value int) bool {
+			if key != n || value != n {
+				t.Errorf("got %d: %d, want %d: %d", key, value, n, n)
+				return false
+			}
+			n++
+			return true
+		})
+		if n != keys {
+			t.Fatalf("got %d pairs, want %d", n, keys)
+		}
+		if v, ok := snap.Load(round * 2); !ok || v != round*2 {
+			t.Fatalf("%d: got %d, %v", round*2, v, ok)
+		}
+	}
+	wg.Wait()
+}
+
+func TestSafetyMap_SnapshotRepeatable(t *testing.T) {
+	const writers = 4
+	m := newMap[int, int]()
+	m.Store(0, 0)
+
+	var (
+		wg   sync.WaitGroup
+		stop atomic.Bool
+	)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 1; !stop.Load(); i++ {
+				m.Store(0, i*writers+w)
+			}
+		}(w)
+	}
+
+	deadline := time.Now().Add(200 * time.Millisecond)
+	for round := 0; time.Now().Before(deadline); round++ {
+		snap := m.Snapshot()
+		first, _ := snap.Load(0)
+		runtime.Gosched()
+		second, _ := snap.Load(0)
+		snap.Close()
+		if first != second {
+			stop.Store(true)
+			wg.Wait()
+			t.Fatalf("round %d: got %d, then %d from the same snapshot", round, first, second)
+		}
+	}
+	stop.Store(true)
+	wg.Wait()
+}
+
+func TestSafetyMap_WatchOrder(t *testing.T) {
+	const (
+		writers = 8
+		writes  = 2000
+	)
+
+	m := newMap[string, int]()
+	m.Store("counter", 0)
+	var log eventLog[string, int]
+	cancel := m.Watch(log.add, odmap.WatchPrefix("counter"), odmap.WatchBuffer[string](writers*writes))
+	defer cancel()
+
+	// every increment replaces the value it read, so the map applies them in order.
+	// The writes of the key that is not watched stay lock-free meanwhile.
+	var (
+		wg         sync.WaitGroup
+		increments atomic.Int64
+	)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < writes; i++ {
+				v, _ := m.Load("counter")
+				var ok bool
+				if i%4 == 0 {
+					ok = m.Apply(new(odmap.Batch[string, int]).Expect("counter", v).Put("counter", v+1)) == nil
+				} else {
+					ok = m.CompareAndSwap("counter", v, v+1)
+				}
+				if ok {
+					increments.Add(1)
+				}
+				m.Store("other", i)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i, ev := range log.wait(t, int(increments.Load())) {
+		if ev.Kind != odmap.EventStore || ev.Value != i+1 {
+			t.Fatalf("event %d: got %v, want the store of %d", i, ev, i+1)
+		}
+	}
+}
+
+func TestSafetyMap_TxnPanic(t *testing.T) {
+	m := newMap[int, int](odmap.WithCompactionThreshold[int, int](0))
+	m.Store(1, 1)
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("the panic of fn was not propagated")
+			}
+		}()
+		_ = m.Txn(func(tx odmap.Tx[int, int]) error {
+			panic("fn")
+		})
+	}()
+
+	// the snapshot of the transaction is released, compaction is not held off
+	m.Delete(1)
+	m.Compact()
+	if s := m.Stats(); s.Entries != 0 || s.Tombstones != 0 {
+		t.Fatalf("got %+v after compaction", s)
+	}
+}
@@ -4,19 +4,45 @@ package odmap
 
 import (
 	"cmp"
+	"context"
 	"encoding/json"
+	"errors"
+	"slices"
 )
 
 type omap[K cmp.Ordered, V any] struct {
-	tree *RBTree[K, V]
+	compare func(K, K) int
+	// newBackend creates the tree, an inline red-black tree unless an option picked another
+	newBackend func(compare func(K, K) int) Backend[K, Versioned[V]]
+	tree       Backend[K, Versioned[V]]
+	// clock is the version of the last write
+	clock uint64
+	// history holds the replaced and deleted values of every key in MVCC mode
+	mvcc    bool
+	history *RBTree[K, *revision[V]]
+
+	watchers watchers[K, V]
 }
 
-func (m *omap[K, V]) Load(key K) (V, bool) {
-	node := m.tree.FindNode(key)
-	if node == nil {
-		return empty[V](), false
+// put stores value for key, stamped with the next version, and records the value it
+// replaced
+func (m *omap[K, V]) put(key K, value V) (V, bool) {
+	m.clock++
+	previous, loaded := m.tree.Insert(key, Versioned[V]{Value: value, Version: m.clock})
+	if loaded {
+		m.record(key, previous)
 	}
-	return node.Value(), true
+	return previous.Value, loaded
+}
+
+// get returns the value of key with its version
+func (m *omap[K, V]) get(key K) (Versioned[V], bool) {
+	return m.tree.Find(key)
+}
+
+func (m *omap[K, V]) Load(key K) (V, bool) {
+	v, ok := m.get(key)
+	return v.Value, ok
 }
 
 func (m *omap[K, V]) Store(key K, value V) {
@@ -24,80 +50,261 @@ func (m *omap[K, V]) Store(key K, value V) {
 }
 
 func (m *omap[K, V]) Swap(key K, value V) (V, bool) {
-	node := m.tree.FindNode(key)
-	if node == nil {
-		// node not found
-		m.tree.Insert(key, value)
-		return empty[V](), false
-	}
-	oldValue := node.Value()
-	node.value.Store(&value)
-	return oldValue, true
+	previous, loaded := m.put(key, value)
+	m.watchers.notify(EventStore, key, value)
+	return previous, loaded
 }
 
 func (m *omap[K, V]) LoadOrStore(key K, value V) (V, bool) {
-	node := m.tree.FindNode(key)
-	if node != nil {
-		return node.Value(), true
+	if v, ok := m.get(key); ok {
+		return v.Value, true
 	}
-	m.tree.Insert(key, value)
+	m.put(key, value)
+	m.watchers.notify(EventStore, key, value)
 	return empty[V](), false
 }
 
 func (m *omap[K, V]) LoadAndDelete(key K) (V, bool) {
-	node := m.tree.FindNode(key)
-	if node != nil {
-		m.tree.Delete(node)
-		return node.Value(), true
+	v, ok := m.remove(key)
+	if !ok {
+		return empty[V](), false
 	}
-	return empty[V](), false
+	m.watchers.notify(EventDelete, key, v.Value)
+	return v.Value, true
 }
 
 func (m *omap[K, V]) Delete(key K) {
-	node := m.tree.FindNode(key)
-	if node != nil {
-		m.tree.Delete(node)
-	}
+	_, _ = m.LoadAndDelete(key)
 }
 
 func (m *omap[K, V]) CompareAndSwap(key K, old, new V) bool {
-	node := m.tree.FindNode(key)
-	if node == nil {
+	v, ok := m.get(key)
+	if !ok || any(v.Value) != any(old) {
 		return false
 	}
-	return node.value.CompareAndSwap(&old, &new)
+	m.put(key, new)
+	m.watchers.notify(EventStore, key, new)
+	return true
 }
 
 func (m *omap[K, V]) CompareAndDelete(key K, old V) bool {
-	node := m.tree.FindNode(key)
-	if node == nil {
+	v, ok := m.get(key)
+	if !ok || any(v.Value) != any(old) {
 		return false
 	}
+	m.remove(key)
+	m.watchers.notify(EventDelete, key, old)
+	return true
+}
+
+func (m *omap[K, V]) LoadVersioned(key K) (V, uint64, bool) {
+	v, ok := m.get(key)
+	if !ok {
+		return empty[V](), 0, false
+	}
+	return v.Value, v.Version, true
+}
 
-	p := node.value.Load()
-	if any(*p) != any(old) {
+func (m *omap[K, V]) CompareVersionAndSwap(key K, version uint64, new V) bool {
+	v, ok := m.get(key)
+	if !ok || v.Version != version {
 		return false
 	}
+	m.put(key, new)
+	m.watchers.notify(EventStore, key, new)
+	return true
+}
 
-	m.tree.Delete(node)
+func (m *omap[K, V]) CompareVersionAndDelete(key K, version uint64) bool {
+	v, ok := m.get(key)
+	if !ok || v.Version != version {
+		return false
+	}
+	m.remove(key)
+	m.watchers.notify(EventDelete, key, v.Value)
 	return true
 }
 
 func (m *omap[K, V]) Range(fc func(key K, value V) bool) {
-	for iter := m.tree.IterFirst(); iter.IsValid(); iter.Next() {
-		if !fc(iter.Key(), iter.Value()) {
+	for c := m.tree.First(); c.Valid(); c.Next() {
+		if !fc(c.Key(), c.Value().Value) {
 			return
 		}
 	}
 }
 
+func (m *omap[K, V]) Floor(key K) (K, V, bool) {
+	c := m.tree.UpperBound(key)
+	if c.Valid() {
+		c.Prev()
+	} else {
+		c = m.tree.Last()
+	}
+	if !c.Valid() {
+		return empty[K](), empty[V](), false
+	}
+	return c.Key(), c.Value().Value, true
+}
+
+func (m *omap[K, V]) Ceiling(key K) (K, V, bool) {
+	c := m.tree.LowerBound(key)
+	if !c.Valid() {
+		return empty[K](), empty[V](), false
+	}
+	return c.Key(), c.Value().Value, true
+}
+
+// scan calls fn for every pair of r in key order
+func (m *omap[K, V]) scan(r interval[K], fn func(key K, value V) bool) {
+	m.versions(r, func(key K, v Versioned[V]) bool {
+		return fn(key, v.Value)
+	})
+}
+
+// versions calls fn for every pair of r in key order
+func (m *omap[K, V]) versions(r interval[K], fn func(key K, v Versioned[V]) bool) {
+	c := m.tree.First()
+	if r.hasLo {
+		c = m.tree.LowerBound(r.lo)
+	}
+	for ; c.Valid() && r.belowHi(m.compare, c.Key()); c.Next() {
+		if !fn(c.Key(), c.Value()) {
+			return
+		}
+	}
+}
+
+func (m *omap[K, V]) Watch(fn func(Event[K, V]), opts ...WatchOption[K]) (cancel func()) {
+	return m.watchers.watch(fn, opts, m.scan)
+}
+
+// WaitFor is unsupported by this map: the write it waits for would have to come from
+// another goroutine, which is a data race. It only checks the current value of key
+// and otherwise fails with errors.ErrUnsupported right away.
+func (m *omap[K, V]) WaitFor(_ context.Context, key K, cond func(V) bool) (V, error) {
+	if v, ok := m.Load(key); ok && cond(v) {
+		return v, nil
+	}
+	return empty[V](), errors.ErrUnsupported
+}
+
+func (m *omap[K, V]) Apply(b *Batch[K, V]) error {
+	if err := b.check(m.Load); err != nil {
+		return err
+	}
+	for _, op := range b.ops {
+		if op.delete {
+			m.Delete(op.key)
+		} else {
+			m.Store(op.key, op.value)
+		}
+	}
+	return nil
+}
+
+// Txn runs fn against the map itself, nothing can conflict with a map that is not
+// shared between goroutines
+func (m *omap[K, V]) Txn(fn func(tx Tx[K, V]) error) error {
+	tx := newTxn[K, V](m.compare, (*omapSource[K, V])(m))
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return m.Apply(tx.batch())
+}
+
+type omapSource[K cmp.Ordered, V any] omap[K, V]
+
+func (s *omapSource[K, V]) get(key K) (V, uint64, bool) {
+	return (*omap[K, V])(s).LoadVersioned(key)
+}
+
+func (s *omapSource[K, V]) scan(r interval[K], fn func(key K, value V, seq uint64) bool) {
+	(*omap[K, V])(s).versions(r, func(key K, v Versioned[V]) bool {
+		return fn(key, v.Value, v.Version)
+	})
+}
+
+// Snapshot copies the pairs of the map, the map is not shared so there is nothing
+// cheaper to pin
+func (m *omap[K, V]) Snapshot() Snapshot[K, V] {
+	snap := &pairsSnapshot[K, V]{compare: m.compare, pairs: make([]Pair[K, V], 0, m.tree.Len()), version: m.clock}
+	m.Range(func(key K, value V) bool {
+		snap.pairs = append(snap.pairs, Pair[K, V]{Key: key, Value: value})
+		return true
+	})
+	return snap
+}
+
+func (m *omap[K, V]) RangeSnapshot(fn func(key K, value V) bool) {
+	m.Snapshot().Range(fn)
+}
+
+// pairsSnapshot is a sorted copy of the pairs of a map
+type pairsSnapshot[K cmp.Ordered, V any] struct {
+	compare func(K, K) int
+	pairs   []Pair[K, V]
+	version uint64
+}
+
+func (s *pairsSnapshot[K, V]) Load(key K) (V, bool) {
+	i, ok := slices.BinarySearchFunc(s.pairs, key, func(p Pair[K, V], key K) int {
+		return s.compare(p.Key, key)
+	})
+	if !ok {
+		return empty[V](), false
+	}
+	return s.pairs[i].Value, true
+}
+
+func (s *pairsSnapshot[K, V]) Range(fn func(key K, value V) bool) {
+	for _, p := range s.pairs {
+		if !fn(p.Key, p.Value) {
+			return
+		}
+	}
+}
+
+func (s *pairsSnapshot[K, V]) Version() uint64 { return s.version }
+
+func (s *pairsSnapshot[K, V]) Close() {}
+
+func (m *omap[K, V]) Freeze(opts ...FreezeOption) *FrozenMap[K, V] {
+	keys, values := make([]K, 0, m.tree.Len()), make([]V, 0, m.tree.Len())
+	for c := m.tree.First(); c.Valid(); c.Next() {
+		keys, values = append(keys, c.Key()), append(values, c.Value().Value)
+	}
+	return newFrozenMap(m.compare, keys, values, opts)
+}
+
+// loadSorted fills the empty map with pairs in increasing key order, stamped with
+// consecutive versions
+func (m *omap[K, V]) loadSorted(keys []K, values []V) error {
+	if !sortedKeys(m.compare, keys) {
+		return ErrKeyOrder
+	}
+	versioned := make([]Versioned[V], len(values))
+	for i, value := range values {
+		m.clock++
+		versioned[i] = Versioned[V]{Value: value, Version: m.clock}
+	}
+	loadBackend(m.tree, keys, versioned)
+	return nil
+}
+
 func (m *omap[K, V]) Len() int64 {
-	return int64(m.tree.Size())
+	return int64(m.tree.Len())
+}
+
+// Compact does nothing, deleted keys are removed right away
+func (m *omap[K, V]) Compact() {}
+
+func (m *omap[K, V]) Stats() Stats {
+	return Stats{Entries: int64(m.tree.Len())}
 }
 
 func (m *omap[K, V]) Contains(key K) bool {
-	n := m.tree.FindNode(key)
-	return n != nil
+	_, ok := m.get(key)
+	return ok
 }
 
 func (m *omap[K, V]) MarshalJSON() ([]byte, error) {
@@ -110,17 +317,28 @@ func (m *omap[K, V]) MarshalJSON() ([]byte, error) {
 }
 
 func newODMap[K cmp.Ordered, V any](opts ...Option[K, V]) *omap[K, V] {
-	m := &omap[K, V]{tree: NewRBTree[K, V](func(a K, b K) int {
-		return cmp.Compare(a, b)
-	})}
+	m := &omap[K, V]{
+		compare:    cmp.Compare[K],
+		newBackend: NewInlineBackend[K, Versioned[V]],
+	}
 
 	for _, opt := range opts {
 		opt(m)
 	}
+	m.tree = m.newBackend(m.compare)
+	m.watchers.compare = m.compare
+	if m.mvcc {
+		m.history = NewRBTree[K, *revision[V]](m.compare)
+	}
 
 	return m
 }
 
 func New[K cmp.Ordered, V any](opts ...Option[K, V]) Map[K, V] {
+	return newMap[K, V](opts...)
+}
+
+// newMap returns the map New returns, with every optional interface
+func newMap[K cmp.Ordered, V any](opts ...Option[K, V]) fullMap[K, V] {
 	return newODMap[K, V](opts...)
 }
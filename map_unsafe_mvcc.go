@@ -0,0 +1,157 @@
+//go:build !safety_map
+
+package odmap
+
+import "cmp"
+
+// revision is a value a key held before a write replaced or deleted it
+type revision[V any] struct {
+	value   V
+	version uint64
+	deleted bool
+	prev    *revision[V]
+}
+
+// at returns the newest revision of the chain that was written at or before version
+func (r *revision[V]) at(version uint64) *revision[V] {
+	for r != nil && r.version > version {
+		r = r.prev
+	}
+	return r
+}
+
+// record pushes a value replaced by a write to the history of its key
+func (m *omap[K, V]) record(key K, v Versioned[V]) {
+	if m.history == nil {
+		return
+	}
+	m.push(key, &revision[V]{value: v.Value, version: v.Version})
+}
+
+func (m *omap[K, V]) push(key K, r *revision[V]) {
+	if e, ok := m.history.get(key); ok {
+		r.prev = e.Value()
+		e.value.Store(&r)
+		return
+	}
+	m.history.Insert(key, r)
+}
+
+// remove deletes key, the deletion is stamped with the next version
+func (m *omap[K, V]) remove(key K) (Versioned[V], bool) {
+	v, ok := m.tree.Delete(key)
+	if !ok {
+		return v, false
+	}
+	m.clock++
+	if m.history != nil {
+		m.record(key, v)
+		m.push(key, &revision[V]{version: m.clock, deleted: true})
+	}
+	return v, true
+}
+
+// valueAt resolves the value of a key at version from its current value, if any, and
+// its history, which may be nil
+func valueAt[K cmp.Ordered, V any](v Versioned[V], ok bool, history *Entry[K, *revision[V]], version uint64) (V, bool) {
+	if ok && v.Version <= version {
+		return v.Value, true
+	}
+	if history == nil {
+		return empty[V](), false
+	}
+	r := history.Value().at(version)
+	if r == nil || r.deleted {
+		return empty[V](), false
+	}
+	return r.value, true
+}
+
+func (m *omap[K, V]) Version() uint64 {
+	return m.clock
+}
+
+func (m *omap[K, V]) LoadAt(key K, version uint64) (V, bool) {
+	var history *Entry[K, *revision[V]]
+	if m.history != nil {
+		history = m.history.FindNode(key)
+	}
+	v, ok := m.get(key)
+	return valueAt(v, ok, history, version)
+}
+
+func (m *omap[K, V]) RangeAt(version uint64, fn func(key K, value V) bool) {
+	var history *Entry[K, *revision[V]]
+	if m.history != nil {
+		history = m.history.First()
+	}
+
+	// merge the current pairs with the history, both in key order
+	c := m.tree.First()
+	for c.Valid() || history != nil {
+		var order int
+		switch {
+		case !c.Valid():
+			order = 1
+		case history == nil:
+			order = -1
+		default:
+			order = m.compare(c.Key(), history.key)
+		}
+
+		var (
+			key K
+			v   Versioned[V]
+			ok  bool
+			h   *Entry[K, *revision[V]]
+		)
+		if order <= 0 {
+			key, v, ok = c.Key(), c.Value(), true
+			c.Next()
+		}
+		if order >= 0 {
+			key, h = history.key, history
+			history = history.Next()
+		}
+		if value, found := valueAt(v, ok, h, version); found && !fn(key, value) {
+			return
+		}
+	}
+}
+
+func (m *omap[K, V]) Prune(version uint64) {
+	if m.history == nil {
+		return
+	}
+	version = min(version, m.clock)
+
+	var dead []K
+	for e := m.history.First(); e != nil; e = e.Next() {
+		// the newest pair visible at version, older ones are never read again
+		if v, ok := m.get(e.key); ok && v.Version <= version {
+			dead = append(dead, e.key)
+			continue
+		}
+
+		var newer *revision[V]
+		r := e.Value()
+		for r != nil && r.version > version {
+			newer, r = r, r.prev
+		}
+		switch {
+		case r == nil:
+		case !r.deleted:
+			r.prev = nil
+		case newer == nil:
+			// only the deletion is visible at version, which reads like no history
+			dead = append(dead, e.key)
+		default:
+			// reading the deletion is the same as reading past the end of the chain
+			newer.prev = nil
+		}
+	}
+
+	for _, key := range dead {
+		m.history.del(key)
+	}
+}
@@ -8,6 +8,40 @@ type Option[K cmp.Ordered, V any] func(m *omap[K, V])
 
 func WithComparer[K cmp.Ordered, V any](comparer func(K, K) int) Option[K, V] {
 	return func(m *omap[K, V]) {
-		m.tree.compare = comparer
+		m.compare = comparer
+	}
+}
+
+// WithMVCC keeps the values replaced or deleted by every write, so that LoadAt and
+// RangeAt can read any earlier version until it is pruned
+func WithMVCC[K cmp.Ordered, V any]() Option[K, V] {
+	return func(m *omap[K, V]) {
+		m.mvcc = true
+	}
+}
+
+// WithCompactionThreshold only applies to the concurrent map, this map removes deleted
+// keys right away
+func WithCompactionThreshold[K cmp.Ordered, V any](threshold float64) Option[K, V] {
+	return func(m *omap[K, V]) {}
+}
+
+// WithBTree keeps the pairs in a B-tree of the given minimum degree rather than a
+// red-black tree, a degree below 2 selects the default of 32
+func WithBTree[K cmp.Ordered, V any](degree int) Option[K, V] {
+	return func(m *omap[K, V]) {
+		m.newBackend = func(compare func(K, K) int) Backend[K, Versioned[V]] {
+			return newBTreeBackend[K, Versioned[V]](degree, compare)
+		}
+	}
+}
+
+// WithBackend keeps the pairs in the backend newBackend returns, ordered by the
+// comparer of the map. The constructors of the bundled backends fit as they are:
+//
+//	odmap.New[string, int](odmap.WithBackend[string, int](odmap.NewSkipListBackend))
+func WithBackend[K cmp.Ordered, V any](newBackend func(compare func(K, K) int) Backend[K, Versioned[V]]) Option[K, V] {
+	return func(m *omap[K, V]) {
+		m.newBackend = newBackend
 	}
 }
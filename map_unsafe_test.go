@@ -0,0 +1,30 @@
+//go:build !safety_map
+
+package odmap_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	odmap "github.com/RealFax/order-map"
+)
+
+func TestOrderedMap_WaitForUnsupported(t *testing.T) {
+	m := newMap[string, int]()
+	m.Store("ready", 3)
+
+	// nothing could ever make cond hold, WaitFor must not block
+	if _, err := m.WaitFor(context.Background(), "ready", func(v int) bool { return v > 3 }); !errors.Is(err, errors.ErrUnsupported) {
+		t.Fatalf("got %v, want %v", err, errors.ErrUnsupported)
+	}
+}
+
+// backendOptions creates maps over every backend
+var backendOptions = map[string][]odmap.Option[int, int]{
+	"rbtree":   nil,
+	"btree":    {odmap.WithBTree[int, int](2)},
+	"skiplist": {odmap.WithBackend[int, int](odmap.NewSkipListBackend)},
+	"sorted":   {odmap.WithBackend[int, int](odmap.NewSortedBackend)},
+	"arena":    {odmap.WithBackend[int, int](odmap.NewArenaBackend)},
+}
@@ -0,0 +1,375 @@
+package odmap
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+var (
+	ErrMappedFormat = errors.New("odmap: malformed mapped file")
+	ErrKeyOrder     = errors.New("odmap: encoded keys are not in increasing order")
+)
+
+// The mapped file starts with a header of little-endian fields:
+//
+//	magic       [4]byte "ODMM"
+//	version     uint32
+//	key width   uint32, 0 if the keys are length-prefixed
+//	value width uint32, 0 if the values are length-prefixed
+//	count       uint64
+//	index       uint64, the offset of the index, 0 if the pairs are fixed-width
+//
+// The pairs follow in increasing order of their keys, a key then its value, a field
+// of varying width is prefixed by its length as a uvarint. When a field varies the
+// index closes the file with the offset of every pair as an uint64.
+const (
+	mappedMagic   = "ODMM"
+	mappedVersion = 1
+	mappedHeader  = 32
+)
+
+// ranger is anything holding pairs in key order
+type ranger[K cmp.Ordered, V any] interface {
+	Range(fn func(key K, value V) bool)
+}
+
+// WriteMapped writes the pairs of m to a mapped file at path, replacing it once
+// complete. The keys are ordered by their encoding, which must increase with them as
+// those of KeyCodec do.
+func WriteMapped[K cmp.Ordered, V any](path string, m ranger[K, V], keys Codec[K], values Codec[V]) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	var (
+		w       = bufio.NewWriter(f)
+		fixed   = keys.Width() > 0 && values.Width() > 0
+		offsets []uint64
+		offset  = uint64(mappedHeader)
+		buf     []byte
+		key     []byte
+		prev    []byte
+		value   []byte
+		count   uint64
+	)
+	if _, err = w.Write(make([]byte, mappedHeader)); err != nil {
+		return err
+	}
+	m.Range(func(k K, v V) bool {
+		if key, err = keys.Append(key[:0], k); err != nil {
+			return false
+		}
+		if count > 0 && bytes.Compare(prev, key) >= 0 {
+			err = ErrKeyOrder
+			return false
+		}
+		prev, key = key, prev
+
+		buf = buf[:0]
+		if buf, err = appendField(buf, prev, keys.Width()); err != nil {
+			return false
+		}
+		if value, err = values.Append(value[:0], v); err != nil {
+			return false
+		}
+		if buf, err = appendField(buf, value, values.Width()); err != nil {
+			return false
+		}
+		if _, err = w.Write(buf); err != nil {
+			return false
+		}
+
+		if !fixed {
+			offsets = append(offsets, offset)
+		}
+		offset += uint64(len(buf))
+		count++
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, mappedHeader)
+	copy(header, mappedMagic)
+	binary.LittleEndian.PutUint32(header[4:], mappedVersion)
+	binary.LittleEndian.PutUint32(header[8:], uint32(keys.Width()))
+	binary.LittleEndian.PutUint32(header[12:], uint32(values.Width()))
+	binary.LittleEndian.PutUint64(header[16:], count)
+	if !fixed {
+		binary.LittleEndian.PutUint64(header[24:], offset)
+		for _, offset := range offsets {
+			if _, err = w.Write(binary.LittleEndian.AppendUint64(buf[:0], offset)); err != nil {
+				return err
+			}
+		}
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	if _, err = f.WriteAt(header, 0); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// appendField appends b, prefixed by its length unless the field has a width
+func appendField(buf, b []byte, width int) ([]byte, error) {
+	if width == 0 {
+		buf = binary.AppendUvarint(buf, uint64(len(b)))
+	} else if len(b) != width {
+		return buf, ErrCodec
+	}
+	return append(buf, b...), nil
+}
+
+// MappedMap is a read-only ordered map of byte slices over a memory-mapped file
+// written by WriteMapped, ordered by bytes.Compare. Opening it reads nothing but the
+// header and the index, the pages of the pairs are loaded as they are searched. It
+// may be read by any number of goroutines.
+//
+// The slices it returns are the mapping itself, they must not be written to and are
+// only valid until Close. Keys and values are decoded by the codecs that wrote them.
+type MappedMap struct {
+	data                 []byte
+	keyWidth, valueWidth int
+	count                int
+	// index holds the offsets of the pairs unless they are fixed-width
+	fixed bool
+	index []byte
+}
+
+// OpenMapped maps the file at path. It checks the header and that the index points
+// into the pairs, Verify checks every pair of a file that may be damaged. The pairs of
+// a damaged file read as truncated rather than out of the mapping.
+func OpenMapped(path string) (*MappedMap, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < mappedHeader || info.Size() > math.MaxInt {
+		return nil, ErrMappedFormat
+	}
+	data, err := mapFile(f, int(info.Size()))
+	if err != nil {
+		return nil, err
+	}
+
+	m := &MappedMap{data: data}
+	if err = m.parseHeader(); err != nil {
+		_ = unmapFile(data)
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *MappedMap) parseHeader() error {
+	h := m.data[:mappedHeader]
+	if string(h[:4]) != mappedMagic || binary.LittleEndian.Uint32(h[4:]) != mappedVersion {
+		return ErrMappedFormat
+	}
+	keyWidth, valueWidth := uint64(binary.LittleEndian.Uint32(h[8:])), uint64(binary.LittleEndian.Uint32(h[12:]))
+	count, index := binary.LittleEndian.Uint64(h[16:]), binary.LittleEndian.Uint64(h[24:])
+	size := uint64(len(m.data))
+
+	if keyWidth > 0 && valueWidth > 0 {
+		if index != 0 || count > (size-mappedHeader)/(keyWidth+valueWidth) ||
+			mappedHeader+count*(keyWidth+valueWidth) != size {
+			return ErrMappedFormat
+		}
+		m.fixed = true
+	} else if index < mappedHeader || index > size || count != (size-index)/8 || (size-index)%8 != 0 {
+		return ErrMappedFormat
+	} else {
+		m.index = m.data[index:]
+		for i := 0; i < int(count); i++ {
+			if offset := binary.LittleEndian.Uint64(m.index[8*i:]); offset < mappedHeader || offset >= index {
+				return ErrMappedFormat
+			}
+		}
+	}
+	m.keyWidth, m.valueWidth, m.count = int(keyWidth), int(valueWidth), int(count)
+	return nil
+}
+
+// Verify checks that every pair lies within the file and that the keys increase
+func (m *MappedMap) Verify() error {
+	end := len(m.data) - len(m.index)
+
+	var prev []byte
+	for i := 0; i < m.count; i++ {
+		offset := m.offset(i)
+		if offset < mappedHeader || offset > uint64(end) {
+			return ErrMappedFormat
+		}
+		key, next, ok := m.checkField(int(offset), end, m.keyWidth)
+		if !ok {
+			return ErrMappedFormat
+		}
+		if _, next, ok = m.checkField(next, end, m.valueWidth); !ok {
+			return ErrMappedFormat
+		}
+		if i > 0 && bytes.Compare(prev, key) >= 0 {
+			return ErrKeyOrder
+		}
+		if !m.fixed && (i+1 < m.count && m.offset(i+1) != uint64(next) || i+1 == m.count && next != end) {
+			return ErrMappedFormat
+		}
+		prev = key
+	}
+	return nil
+}
+
+func (m *MappedMap) checkField(offset, end, width int) ([]byte, int, bool) {
+	if width == 0 {
+		n, w := binary.Uvarint(m.data[offset:end])
+		if w <= 0 || n > uint64(end-offset-w) {
+			return nil, 0, false
+		}
+		offset, width = offset+w, int(n)
+	} else if width > end-offset {
+		return nil, 0, false
+	}
+	return m.data[offset : offset+width], offset + width, true
+}
+
+func (m *MappedMap) Close() error {
+	data := m.data
+	m.data, m.index, m.count = nil, nil, 0
+	return unmapFile(data)
+}
+
+func (m *MappedMap) offset(i int) uint64 {
+	if m.fixed {
+		return uint64(mappedHeader + i*(m.keyWidth+m.valueWidth))
+	}
+	return binary.LittleEndian.Uint64(m.index[8*i:])
+}
+
+// field returns the field at offset and the offset following it, the field is cut
+// short where a damaged length runs past the pairs
+func (m *MappedMap) field(offset, width int) ([]byte, int) {
+	end := len(m.data) - len(m.index)
+	if width == 0 {
+		n, w := binary.Uvarint(m.data[offset:end])
+		if w <= 0 {
+			return nil, end
+		}
+		offset, width = offset+w, int(min(n, uint64(end-offset-w)))
+	}
+	width = min(width, end-offset)
+	return m.data[offset : offset+width : offset+width], offset + width
+}
+
+func (m *MappedMap) key(i int) []byte {
+	key, _ := m.field(int(m.offset(i)), m.keyWidth)
+	return key
+}
+
+func (m *MappedMap) pair(i int) ([]byte, []byte) {
+	key, next := m.field(int(m.offset(i)), m.keyWidth)
+	value, _ := m.field(next, m.valueWidth)
+	return key, value
+}
+
+// search returns the index of the first key not below key, nor at it when strict
+func (m *MappedMap) search(key []byte, strict bool) int {
+	lo, hi := 0, m.count
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		if c := bytes.Compare(m.key(mid), key); c > 0 || (!strict && c == 0) {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	return lo
+}
+
+func (m *MappedMap) Load(key []byte) ([]byte, bool) {
+	i := m.search(key, false)
+	if i == m.count {
+		return nil, false
+	}
+	k, value := m.pair(i)
+	if !bytes.Equal(k, key) {
+		return nil, false
+	}
+	return value, true
+}
+
+func (m *MappedMap) Contains(key []byte) bool {
+	_, ok := m.Load(key)
+	return ok
+}
+
+func (m *MappedMap) Len() int64 {
+	return int64(m.count)
+}
+
+// Floor returns the pair with the greatest key less than or equal to key
+func (m *MappedMap) Floor(key []byte) ([]byte, []byte, bool) {
+	i := m.search(key, true) - 1
+	if i < 0 {
+		return nil, nil, false
+	}
+	k, v := m.pair(i)
+	return k, v, true
+}
+
+// Ceiling returns the pair with the least key greater than or equal to key
+func (m *MappedMap) Ceiling(key []byte) ([]byte, []byte, bool) {
+	i := m.search(key, false)
+	if i == m.count {
+		return nil, nil, false
+	}
+	k, v := m.pair(i)
+	return k, v, true
+}
+
+// Range calls fn for every pair in key order
+func (m *MappedMap) Range(fn func(key, value []byte) bool) {
+	m.scan(0, fn, nil)
+}
+
+// Scan calls fn for every pair with a key in [lo, hi), in key order
+func (m *MappedMap) Scan(lo, hi []byte, fn func(key, value []byte) bool) {
+	m.scan(m.search(lo, false), fn, hi)
+}
+
+func (m *MappedMap) scan(i int, fn func(key, value []byte) bool, hi []byte) {
+	for ; i < m.count; i++ {
+		key, value := m.pair(i)
+		if hi != nil && bytes.Compare(key, hi) >= 0 {
+			return
+		}
+		if !fn(key, value) {
+			return
+		}
+	}
+}
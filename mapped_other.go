@@ -0,0 +1,17 @@
+//go:build !linux
+
+package odmap
+
+import (
+	"io"
+	"os"
+)
+
+// mapFile reads the file where mapping it is not supported
+func mapFile(f *os.File, size int) ([]byte, error) {
+	data := make([]byte, size)
+	_, err := io.ReadFull(f, data)
+	return data, err
+}
+
+func unmapFile([]byte) error { return nil }
@@ -0,0 +1,198 @@
+package odmap_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	odmap "github.com/RealFax/order-map"
+)
+
+func TestMappedMap_Fixed(t *testing.T) {
+	m := newMap[int32, uint64]()
+	for i := int32(-50); i < 50; i++ {
+		m.Store(i*2, uint64(i*i))
+	}
+	path := filepath.Join(t.TempDir(), "table")
+	if err := odmap.WriteMapped(path, m, odmap.KeyCodec[int32](), odmap.KeyCodec[uint64]()); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := odmap.OpenMapped(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err = f.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	if f.Len() != 100 {
+		t.Fatalf("got len %d, want 100", f.Len())
+	}
+
+	keys, values := odmap.KeyCodec[int32](), odmap.KeyCodec[uint64]()
+	encode := func(key int32) []byte {
+		b, _ := keys.Append(nil, key)
+		return b
+	}
+	for i := int32(-101); i <= 101; i++ {
+		v, ok := f.Load(encode(i))
+		if ok != (i%2 == 0 && i >= -100 && i < 100) {
+			t.Fatalf("Load(%d): got %v", i, ok)
+		}
+		if ok {
+			if value, _ := values.Decode(v); value != uint64(i*i/4) {
+				t.Fatalf("Load(%d): got %d", i, value)
+			}
+		}
+
+		key, _, ok := f.Floor(encode(i))
+		if floor, _ := keys.Decode(key); ok != (i >= -100) || (ok && floor != min(i-(i%2+2)%2, 98)) {
+			t.Fatalf("Floor(%d): got %d, %v", i, floor, ok)
+		}
+		key, _, ok = f.Ceiling(encode(i))
+		if ceiling, _ := keys.Decode(key); ok != (i <= 98) || (ok && ceiling != max(i+(i%2+2)%2, -100)) {
+			t.Fatalf("Ceiling(%d): got %d, %v", i, ceiling, ok)
+		}
+	}
+
+	var scanned []int32
+	f.Scan(encode(-3), encode(4), func(key, _ []byte) bool {
+		k, _ := keys.Decode(key)
+		scanned = append(scanned, k)
+		return true
+	})
+	if fmt.Sprint(scanned) != "[-2 0 2]" {
+		t.Fatalf("Scan: got %v", scanned)
+	}
+}
+
+func TestMappedMap_Varying(t *testing.T) {
+	m := newMap[string, []string]()
+	for i := 0; i < 1000; i++ {
+		m.Store(fmt.Sprintf("key-%04d", i), []string{fmt.Sprint(i)})
+	}
+	path := filepath.Join(t.TempDir(), "table")
+	if err := odmap.WriteMapped(path, m, odmap.KeyCodec[string](), odmap.JSONCodec[[]string]()); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := odmap.OpenMapped(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err = f.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	i := 0
+	f.Range(func(key, value []byte) bool {
+		if string(key) != fmt.Sprintf("key-%04d", i) || string(value) != fmt.Sprintf(`["%d"]`, i) {
+			t.Fatalf("unexpected pair %s=%s", key, value)
+		}
+		i++
+		return true
+	})
+	if i != 1000 {
+		t.Fatalf("got %d pairs, want 1000", i)
+	}
+	if v, ok := f.Load([]byte("key-0500")); !ok || string(v) != `["500"]` {
+		t.Fatalf("Load: got %s, %v", v, ok)
+	}
+	if _, ok := f.Load([]byte("key-05")); ok {
+		t.Fatal("Load: found a prefix of a key")
+	}
+	if key, _, ok := f.Floor([]byte("key-0500x")); !ok || string(key) != "key-0500" {
+		t.Fatalf("Floor: got %s, %v", key, ok)
+	}
+}
+
+func TestMappedMap_Empty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "table")
+	if err := odmap.WriteMapped(path, newMap[string, string](), odmap.KeyCodec[string](), odmap.BytesCodec[string]()); err != nil {
+		t.Fatal(err)
+	}
+	f, err := odmap.OpenMapped(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, ok := f.Load(nil); ok || f.Len() != 0 {
+		t.Fatalf("got len %d", f.Len())
+	}
+	if _, _, ok := f.Floor([]byte("a")); ok {
+		t.Fatal("Floor: found a pair")
+	}
+}
+
+func TestMappedMap_KeyOrder(t *testing.T) {
+	m := newMap[int, int]()
+	m.Store(9, 0)
+	m.Store(10, 0)
+	path := filepath.Join(t.TempDir(), "table")
+	// the JSON of 10 sorts before the JSON of 9
+	if err := odmap.WriteMapped(path, m, odmap.JSONCodec[int](), odmap.JSONCodec[int]()); !errors.Is(err, odmap.ErrKeyOrder) {
+		t.Fatalf("got %v, want ErrKeyOrder", err)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 0 {
+		t.Fatalf("left %d files behind", len(entries))
+	}
+}
+
+func TestMappedMap_Malformed(t *testing.T) {
+	m := newMap[string, string]()
+	for _, key := range []string{"a", "b", "c"} {
+		m.Store(key, key)
+	}
+	path := filepath.Join(t.TempDir(), "table")
+	if err := odmap.WriteMapped(path, m, odmap.KeyCodec[string](), odmap.BytesCodec[string]()); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a truncated file fails to open
+	if err = os.WriteFile(path, data[:len(data)-1], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = odmap.OpenMapped(path); !errors.Is(err, odmap.ErrMappedFormat) {
+		t.Fatalf("truncated: got %v, want ErrMappedFormat", err)
+	}
+
+	// a damaged pair is found by Verify
+	damaged := bytes.Clone(data)
+	damaged[32] = 0x7f
+	if err = os.WriteFile(path, damaged, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := odmap.OpenMapped(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err = f.Verify(); !errors.Is(err, odmap.ErrMappedFormat) {
+		t.Fatalf("damaged: got %v, want ErrMappedFormat", err)
+	}
+	// and reads short rather than out of the file
+	f.Range(func(key, value []byte) bool { return true })
+	if _, ok := f.Load([]byte("c")); !ok {
+		t.Fatal("damaged: lost an intact pair")
+	}
+
+	// an index pointing out of the pairs fails to open
+	damaged = bytes.Clone(data)
+	binary.LittleEndian.PutUint64(damaged[len(damaged)-8:], uint64(len(damaged)))
+	if err = os.WriteFile(path, damaged, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = odmap.OpenMapped(path); !errors.Is(err, odmap.ErrMappedFormat) {
+		t.Fatalf("index: got %v, want ErrMappedFormat", err)
+	}
+}
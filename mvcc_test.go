@@ -0,0 +1,99 @@
+package odmap_test
+
+import (
+	"cmp"
+	"maps"
+	"math/rand"
+	"testing"
+
+	odmap "github.com/RealFax/order-map"
+)
+
+func TestOrderedMap_LoadAt(t *testing.T) {
+	m := newMap[string, int](odmap.WithMVCC[string, int]())
+	m.Store("a", 1)
+	m.Store("b", 1)
+	before := m.Version()
+
+	m.Store("a", 2)
+	m.Delete("b")
+	m.Store("c", 3)
+
+	if v, ok := m.LoadAt("a", before); !ok || v != 1 {
+		t.Fatalf("a: got %d, %v, want 1", v, ok)
+	}
+	if v, ok := m.LoadAt("b", before); !ok || v != 1 {
+		t.Fatalf("b: got %d, %v, want 1", v, ok)
+	}
+	if _, ok := m.LoadAt("c", before); ok {
+		t.Fatal("c is present before it was stored")
+	}
+	if _, ok := m.LoadAt("b", m.Version()); ok {
+		t.Fatal("b is present after it was deleted")
+	}
+
+	// pruning up to before keeps it readable
+	m.Prune(before)
+	if got := collectAt(m, before); !maps.Equal(got, map[string]int{"a": 1, "b": 1}) {
+		t.Fatalf("got %v at version %d", got, before)
+	}
+	if got := collectAt(m, m.Version()); !maps.Equal(got, map[string]int{"a": 2, "c": 3}) {
+		t.Fatalf("got %v at the current version", got)
+	}
+}
+
+func TestOrderedMap_RangeAt(t *testing.T) {
+	for name, opts := range backendOptions {
+		t.Run(name, func(t *testing.T) {
+			m := newMap[int, int](append(opts, odmap.WithMVCC[int, int]())...)
+			r := rand.New(rand.NewSource(1))
+
+			model := make(map[int]int)
+			history := make(map[uint64]map[int]int)
+			pruned := uint64(0)
+			for i := 0; i < 2000; i++ {
+				key := r.Intn(32)
+				if r.Intn(3) == 0 {
+					m.Delete(key)
+					delete(model, key)
+				} else {
+					m.Store(key, i)
+					model[key] = i
+				}
+				history[m.Version()] = maps.Clone(model)
+
+				if i%100 == 99 {
+					version := m.Version() - min(m.Version(), uint64(r.Intn(200)))
+					m.Prune(version)
+					// reads are exact from the newest version passed to Prune on
+					pruned = max(pruned, version)
+				}
+				for j := 0; j < 4; j++ {
+					version := pruned + uint64(r.Int63n(int64(m.Version()-pruned+1)))
+					want, ok := history[version]
+					if !ok {
+						// deleting a missing key does not write
+						continue
+					}
+					if got := collectAt(m, version); !maps.Equal(got, want) {
+						t.Fatalf("version %d: got %v, want %v", version, got, want)
+					}
+					key := r.Intn(32)
+					v, ok := m.LoadAt(key, version)
+					if w, present := want[key]; ok != present || v != w {
+						t.Fatalf("version %d, key %d: got %d, %v, want %d, %v", version, key, v, ok, w, present)
+					}
+				}
+			}
+		})
+	}
+}
+
+func collectAt[K cmp.Ordered, V any](m odmap.Historian[K, V], version uint64) map[K]V {
+	got := make(map[K]V)
+	m.RangeAt(version, func(key K, value V) bool {
+		got[key] = value
+		return true
+	})
+	return got
+}
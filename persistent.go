@@ -0,0 +1,336 @@
+package odmap
+
+import (
+	"cmp"
+	"encoding/json"
+)
+
+// Persistent is an immutable ordered map. With and Without return a new map that
+// shares every node they did not touch with the original, so a map can be handed to
+// other goroutines as is and every earlier version stays valid.
+// The zero value is an empty map ordered by cmp.Compare.
+type Persistent[K cmp.Ordered, V any] struct {
+	compare func(K, K) int
+	root    *pnode[K, V]
+	size    int64
+}
+
+// pnode is a node of a persistent red-black tree, it is never modified once linked
+type pnode[K cmp.Ordered, V any] struct {
+	color Color
+	left  *pnode[K, V]
+	right *pnode[K, V]
+	key   K
+	value V
+}
+
+func NewPersistent[K cmp.Ordered, V any]() *Persistent[K, V] {
+	return &Persistent[K, V]{}
+}
+
+// NewPersistentFunc returns an empty map ordered by compare
+func NewPersistentFunc[K cmp.Ordered, V any](compare func(K, K) int) *Persistent[K, V] {
+	return &Persistent[K, V]{compare: compare}
+}
+
+// persistentOf returns the map of pairs in increasing key order, built in linear time
+func persistentOf[K cmp.Ordered, V any](compare func(K, K) int, keys []K, values []V) *Persistent[K, V] {
+	red := redDepth(len(keys))
+	var build func(keys []K, values []V, depth int) *pnode[K, V]
+	build = func(keys []K, values []V, depth int) *pnode[K, V] {
+		if len(keys) == 0 {
+			return nil
+		}
+		color, mid := Color(BLACK), len(keys)/2
+		if depth >= red {
+			color = RED
+		}
+		return newPNode(color, build(keys[:mid], values[:mid], depth+1), keys[mid], values[mid],
+			build(keys[mid+1:], values[mid+1:], depth+1))
+	}
+	return &Persistent[K, V]{compare: compare, root: build(keys, values, 0), size: int64(len(keys))}
+}
+
+func (p *Persistent[K, V]) cmp(a, b K) int {
+	if p.compare == nil {
+		return cmp.Compare(a, b)
+	}
+	return p.compare(a, b)
+}
+
+// Snapshot returns p itself, a persistent map never changes
+func (p *Persistent[K, V]) Snapshot() *Persistent[K, V] {
+	return p
+}
+
+func (p *Persistent[K, V]) Load(key K) (V, bool) {
+	for n := p.root; n != nil; {
+		switch c := p.cmp(key, n.key); {
+		case c < 0:
+			n = n.left
+		case c > 0:
+			n = n.right
+		default:
+			return n.value, true
+		}
+	}
+	return empty[V](), false
+}
+
+func (p *Persistent[K, V]) Contains(key K) bool {
+	_, ok := p.Load(key)
+	return ok
+}
+
+func (p *Persistent[K, V]) Len() int64 {
+	return p.size
+}
+
+// Range calls fn for every pair in key order
+func (p *Persistent[K, V]) Range(fn func(key K, value V) bool) {
+	p.root.walk(fn)
+}
+
+// ascend calls fn for every pair of r in key order
+func (p *Persistent[K, V]) ascend(r interval[K], fn func(key K, value V) bool) {
+	p.root.ascend(p.cmp, r, fn)
+}
+
+// ascend reports false once fn stopped or a key past r was reached
+func (n *pnode[K, V]) ascend(compare func(K, K) int, r interval[K], fn func(key K, value V) bool) bool {
+	if n == nil {
+		return true
+	}
+	above := r.aboveLo(compare, n.key)
+	if above && !n.left.ascend(compare, r, fn) {
+		return false
+	}
+	if !r.belowHi(compare, n.key) {
+		return false
+	}
+	if above && !fn(n.key, n.value) {
+		return false
+	}
+	return n.right.ascend(compare, r, fn)
+}
+
+// descend calls fn for every pair of r in reverse key order
+func (p *Persistent[K, V]) descend(r interval[K], fn func(key K, value V) bool) {
+	p.root.descend(p.cmp, r, fn)
+}
+
+// descend reports false once fn stopped or a key before r was reached
+func (n *pnode[K, V]) descend(compare func(K, K) int, r interval[K], fn func(key K, value V) bool) bool {
+	if n == nil {
+		return true
+	}
+	below := r.belowHi(compare, n.key)
+	if below && !n.right.descend(compare, r, fn) {
+		return false
+	}
+	if !r.aboveLo(compare, n.key) {
+		return false
+	}
+	if below && !fn(n.key, n.value) {
+		return false
+	}
+	return n.left.descend(compare, r, fn)
+}
+
+func (n *pnode[K, V]) walk(fn func(key K, value V) bool) bool {
+	if n == nil {
+		return true
+	}
+	return n.left.walk(fn) && fn(n.key, n.value) && n.right.walk(fn)
+}
+
+// Floor returns the pair with the greatest key less than or equal to key
+func (p *Persistent[K, V]) Floor(key K) (k K, v V, ok bool) {
+	p.descend(interval[K]{hi: key, hasHi: true, closed: true}, func(key K, value V) bool {
+		k, v, ok = key, value, true
+		return false
+	})
+	return
+}
+
+// Ceiling returns the pair with the least key greater than or equal to key
+func (p *Persistent[K, V]) Ceiling(key K) (k K, v V, ok bool) {
+	p.ascend(interval[K]{lo: key, hasLo: true}, func(key K, value V) bool {
+		k, v, ok = key, value, true
+		return false
+	})
+	return
+}
+
+func (p *Persistent[K, V]) MarshalJSON() ([]byte, error) {
+	s := make([]Pair[K, V], 0, p.size)
+	p.Range(func(key K, value V) bool {
+		s = append(s, Pair[K, V]{Key: key, Value: value})
+		return true
+	})
+	return json.Marshal(s)
+}
+
+// With returns a map holding value for key, p is left unchanged
+func (p *Persistent[K, V]) With(key K, value V) *Persistent[K, V] {
+	added := false
+	var ins func(n *pnode[K, V]) *pnode[K, V]
+	ins = func(n *pnode[K, V]) *pnode[K, V] {
+		if n == nil {
+			added = true
+			return &pnode[K, V]{color: RED, key: key, value: value}
+		}
+		switch c := p.cmp(key, n.key); {
+		case c < 0:
+			if n.color == BLACK {
+				return balance(ins(n.left), n.key, n.value, n.right)
+			}
+			return &pnode[K, V]{color: RED, left: ins(n.left), key: n.key, value: n.value, right: n.right}
+		case c > 0:
+			if n.color == BLACK {
+				return balance(n.left, n.key, n.value, ins(n.right))
+			}
+			return &pnode[K, V]{color: RED, left: n.left, key: n.key, value: n.value, right: ins(n.right)}
+		default:
+			return &pnode[K, V]{color: n.color, left: n.left, key: key, value: value, right: n.right}
+		}
+	}
+
+	next := &Persistent[K, V]{compare: p.compare, root: ins(p.root).blacken(), size: p.size}
+	if added {
+		next.size++
+	}
+	return next
+}
+
+// Without returns a map without key, p is left unchanged. p itself is returned when
+// key is missing.
+func (p *Persistent[K, V]) Without(key K) *Persistent[K, V] {
+	if !p.Contains(key) {
+		return p
+	}
+
+	var del func(n *pnode[K, V]) *pnode[K, V]
+	del = func(n *pnode[K, V]) *pnode[K, V] {
+		switch c := p.cmp(key, n.key); {
+		case c < 0:
+			if n.left.isBlack() {
+				return balanceLeft(del(n.left), n.key, n.value, n.right)
+			}
+			return &pnode[K, V]{color: RED, left: del(n.left), key: n.key, value: n.value, right: n.right}
+		case c > 0:
+			if n.right.isBlack() {
+				return balanceRight(n.left, n.key, n.value, del(n.right))
+			}
+			return &pnode[K, V]{color: RED, left: n.left, key: n.key, value: n.value, right: del(n.right)}
+		default:
+			return join(n.left, n.right)
+		}
+	}
+
+	return &Persistent[K, V]{compare: p.compare, root: del(p.root).blacken(), size: p.size - 1}
+}
+
+// ---- balancing, after Okasaki for insertion and Kahrs for deletion ----
+
+func (n *pnode[K, V]) isRed() bool {
+	return n != nil && n.color == RED
+}
+
+// isBlack reports whether n is a black node, unlike !isRed it is false for a leaf
+func (n *pnode[K, V]) isBlack() bool {
+	return n != nil && n.color == BLACK
+}
+
+func (n *pnode[K, V]) blacken() *pnode[K, V] {
+	if n == nil || n.color == BLACK {
+		return n
+	}
+	return &pnode[K, V]{color: BLACK, left: n.left, key: n.key, value: n.value, right: n.right}
+}
+
+func (n *pnode[K, V]) redden() *pnode[K, V] {
+	return &pnode[K, V]{color: RED, left: n.left, key: n.key, value: n.value, right: n.right}
+}
+
+func newPNode[K cmp.Ordered, V any](color Color, left *pnode[K, V], key K, value V, right *pnode[K, V]) *pnode[K, V] {
+	return &pnode[K, V]{color: color, left: left, key: key, value: value, right: right}
+}
+
+// balance builds a black node from key and its subtrees, resolving a red child with
+// a red child of its own
+func balance[K cmp.Ordered, V any](left *pnode[K, V], key K, value V, right *pnode[K, V]) *pnode[K, V] {
+	switch {
+	case left.isRed() && right.isRed():
+		return newPNode(RED, left.blacken(), key, value, right.blacken())
+	case left.isRed() && left.left.isRed():
+		return newPNode(RED, left.left.blacken(), left.key, left.value,
+			newPNode(BLACK, left.right, key, value, right))
+	case left.isRed() && left.right.isRed():
+		return newPNode(RED, newPNode(BLACK, left.left, left.key, left.value, left.right.left),
+			left.right.key, left.right.value, newPNode(BLACK, left.right.right, key, value, right))
+	case right.isRed() && right.right.isRed():
+		return newPNode(RED, newPNode(BLACK, left, key, value, right.left),
+			right.key, right.value, right.right.blacken())
+	case right.isRed() && right.left.isRed():
+		return newPNode(RED, newPNode(BLACK, left, key, value, right.left.left),
+			right.left.key, right.left.value, newPNode(BLACK, right.left.right, right.key, right.value, right.right))
+	}
+	return newPNode(BLACK, left, key, value, right)
+}
+
+// balanceLeft restores the black height after the left subtree lost a black node
+func balanceLeft[K cmp.Ordered, V any](left *pnode[K, V], key K, value V, right *pnode[K, V]) *pnode[K, V] {
+	switch {
+	case left.isRed():
+		return newPNode(RED, left.blacken(), key, value, right)
+	case right.isBlack():
+		return balance(left, key, value, right.redden())
+	default:
+		// right is red with a black left child
+		return newPNode(RED, newPNode(BLACK, left, key, value, right.left.left),
+			right.left.key, right.left.value, balance(right.left.right, right.key, right.value, right.right.redden()))
+	}
+}
+
+// balanceRight restores the black height after the right subtree lost a black node
+func balanceRight[K cmp.Ordered, V any](left *pnode[K, V], key K, value V, right *pnode[K, V]) *pnode[K, V] {
+	switch {
+	case right.isRed():
+		return newPNode(RED, left, key, value, right.blacken())
+	case left.isBlack():
+		return balance(left.redden(), key, value, right)
+	default:
+		// left is red with a black right child
+		return newPNode(RED, balance(left.left.redden(), left.key, left.value, left.right.left),
+			left.right.key, left.right.value, newPNode(BLACK, left.right.right, key, value, right))
+	}
+}
+
+// join merges two subtrees of equal black height whose keys are all ordered left to right
+func join[K cmp.Ordered, V any](left, right *pnode[K, V]) *pnode[K, V] {
+	switch {
+	case left == nil:
+		return right
+	case right == nil:
+		return left
+	case left.isRed() && right.isRed():
+		m := join(left.right, right.left)
+		if m.isRed() {
+			return newPNode(RED, newPNode(RED, left.left, left.key, left.value, m.left),
+				m.key, m.value, newPNode(RED, m.right, right.key, right.value, right.right))
+		}
+		return newPNode(RED, left.left, left.key, left.value, newPNode(RED, m, right.key, right.value, right.right))
+	case left.isBlack() && right.isBlack():
+		m := join(left.right, right.left)
+		if m.isRed() {
+			return newPNode(RED, newPNode(BLACK, left.left, left.key, left.value, m.left),
+				m.key, m.value, newPNode(BLACK, m.right, right.key, right.value, right.right))
+		}
+		return balanceLeft(left.left, left.key, left.value, newPNode(BLACK, m, right.key, right.value, right.right))
+	case right.isRed():
+		return newPNode(RED, join(left, right.left), right.key, right.value, right.right)
+	default:
+		return newPNode(RED, left.left, left.key, left.value, join(left.right, right))
+	}
+}
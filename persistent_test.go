@@ -0,0 +1,117 @@
+package odmap_test
+
+import (
+	"maps"
+	"math/rand"
+	"sync"
+	"testing"
+
+	odmap "github.com/RealFax/order-map"
+)
+
+func TestPersistent_WithWithout(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	p := odmap.NewPersistent[int, int]()
+	model := make(map[int]int)
+
+	type version struct {
+		p     *odmap.Persistent[int, int]
+		model map[int]int
+	}
+	var versions []version
+	for i := 0; i < 5000; i++ {
+		key := r.Intn(256)
+		if r.Intn(3) == 0 {
+			p = p.Without(key)
+			delete(model, key)
+		} else {
+			p = p.With(key, i)
+			model[key] = i
+		}
+		if i%50 == 0 {
+			versions = append(versions, version{p: p.Snapshot(), model: maps.Clone(model)})
+		}
+	}
+	versions = append(versions, version{p: p, model: model})
+
+	// every version still holds what it held when it was taken
+	for _, v := range versions {
+		if v.p.Len() != int64(len(v.model)) {
+			t.Fatalf("got len %d, want %d", v.p.Len(), len(v.model))
+		}
+		prev := -1
+		v.p.Range(func(key int, value int) bool {
+			if key <= prev {
+				t.Fatalf("key %d after %d", key, prev)
+			}
+			if want, ok := v.model[key]; !ok || value != want {
+				t.Fatalf("key %d: got %d, want %d, %v", key, value, want, ok)
+			}
+			prev = key
+			return true
+		})
+		for key, want := range v.model {
+			if got, ok := v.p.Load(key); !ok || got != want {
+				t.Fatalf("key %d: got %d, %v, want %d", key, got, ok, want)
+			}
+		}
+	}
+}
+
+func TestPersistent_WithoutMissing(t *testing.T) {
+	var p odmap.Persistent[string, int]
+	q := p.With("a", 1)
+	if q.Without("b") != q {
+		t.Fatal("removing a missing key copied the map")
+	}
+	if p.Len() != 0 || p.Contains("a") {
+		t.Fatal("With modified the original map")
+	}
+}
+
+func TestPersistent_Share(t *testing.T) {
+	p := odmap.NewPersistent[int, int]()
+	for i := 0; i < 100; i++ {
+		p = p.With(i, i)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(snapshot *odmap.Persistent[int, int]) {
+			defer wg.Done()
+			sum := 0
+			snapshot.Range(func(key int, value int) bool {
+				sum += value
+				return true
+			})
+			if sum != 4950 {
+				t.Errorf("got sum %d, want 4950", sum)
+			}
+		}(p.Snapshot())
+	}
+
+	// derive new versions while the snapshots are being read
+	q := p
+	for i := 0; i < 100; i++ {
+		q = q.With(i, 0).Without(i + 1)
+	}
+	wg.Wait()
+}
+
+func TestPersistent_FloorCeiling(t *testing.T) {
+	p := odmap.NewPersistent[int, string]().With(10, "a").With(20, "b").With(30, "c").Without(20)
+
+	if k, v, ok := p.Floor(25); !ok || k != 10 || v != "a" {
+		t.Fatalf("Floor(25): got %d, %q, %v", k, v, ok)
+	}
+	if k, v, ok := p.Ceiling(25); !ok || k != 30 || v != "c" {
+		t.Fatalf("Ceiling(25): got %d, %q, %v", k, v, ok)
+	}
+	if _, _, ok := p.Floor(5); ok {
+		t.Fatal("Floor(5) found a key")
+	}
+	if _, _, ok := p.Ceiling(35); ok {
+		t.Fatal("Ceiling(35) found a key")
+	}
+}
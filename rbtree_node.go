@@ -43,105 +43,6 @@ func (n *Entry[K, V]) Value() V {
 	return *p
 }
 
-func (n *Entry[K, V]) load() (V, bool) {
-	p := n.value.Load()
-	if p == nil || p == n.expunged {
-		return empty[V](), false
-	}
-	return *p, true
-}
-
-func (n *Entry[K, V]) tryCompareAndSwap(old, new V) bool {
-	p := n.value.Load()
-	if p == nil || p == n.expunged || any(*p) != any(old) {
-		return false
-	}
-
-	nc := new
-	for {
-		if n.value.CompareAndSwap(p, &nc) {
-			return true
-		}
-
-		p = n.value.Load()
-
-		if p == nil || p == n.expunged || any(*p) != any(old) {
-			return false
-		}
-	}
-}
-
-func (n *Entry[K, V]) unexpungeLocked() bool {
-	return n.value.CompareAndSwap(n.expunged, nil)
-}
-
-func (n *Entry[K, V]) swapLocked(i *V) *V {
-	return n.value.Swap(i)
-}
-
-func (n *Entry[K, V]) tryLoadOrStore(i V) (V, bool, bool) {
-	p := n.value.Load()
-	if p == n.expunged {
-		return empty[V](), false, false
-	}
-	if p != nil {
-		return *p, true, true
-	}
-
-	ic := i
-	for {
-		if n.value.CompareAndSwap(nil, &ic) {
-			return i, false, true
-		}
-
-		p = n.value.Load()
-		if p == n.expunged {
-			return empty[V](), false, false
-		}
-
-		if p != nil {
-			return *p, true, true
-		}
-	}
-}
-
-func (n *Entry[K, V]) delete() (V, bool) {
-	for {
-		p := n.value.Load()
-		if p == nil || p == n.expunged {
-			return empty[V](), false
-		}
-
-		if n.value.CompareAndSwap(p, nil) {
-			return *p, true
-		}
-	}
-}
-
-func (n *Entry[K, V]) trySwap(i *V) (*V, bool) {
-	for {
-		p := n.value.Load()
-		if p == n.expunged {
-			return nil, false
-		}
-		if n.value.CompareAndSwap(p, i) {
-			return p, true
-		}
-	}
-}
-
-func (n *Entry[K, V]) tryExpungeLocked() bool {
-	p := n.value.Load()
-	for p == nil {
-		if n.value.CompareAndSwap(nil, n.expunged) {
-			return true
-		}
-		p = n.value.Load()
-	}
-
-	return p == n.expunged
-}
-
 // ---- iterator ----
 
 // Next returns the Entry's successor as an iterator.
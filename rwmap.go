@@ -0,0 +1,112 @@
+package odmap
+
+import (
+	"cmp"
+	"encoding/json"
+)
+
+// RWMap is a concurrent ordered map guarding a single RBTree with a sync.RWMutex.
+// Reads share the lock and writers take it exclusively, which suits read-mostly
+// workloads of range scans and steadily added keys. Keys are ordered by cmp.Compare.
+type RWMap[K cmp.Ordered, V any] struct {
+	// an RWMap is a single shard of a ShardedMap
+	shard[K, V]
+}
+
+func NewRWMap[K cmp.Ordered, V any]() Map[K, V] {
+	return &RWMap[K, V]{shard: shard[K, V]{tree: NewRBTree[K, V](cmp.Compare[K])}}
+}
+
+func (m *RWMap[K, V]) Load(key K) (V, bool) {
+	return m.load(key)
+}
+
+func (m *RWMap[K, V]) Store(key K, value V) {
+	_, _ = m.Swap(key, value)
+}
+
+func (m *RWMap[K, V]) Swap(key K, value V) (V, bool) {
+	return m.swap(key, value)
+}
+
+func (m *RWMap[K, V]) LoadOrStore(key K, value V) (V, bool) {
+	return m.loadOrStore(key, value)
+}
+
+func (m *RWMap[K, V]) LoadAndDelete(key K) (V, bool) {
+	return m.loadAndDelete(key)
+}
+
+func (m *RWMap[K, V]) Delete(key K) {
+	_, _ = m.LoadAndDelete(key)
+}
+
+func (m *RWMap[K, V]) CompareAndSwap(key K, old, new V) bool {
+	return m.compareAndSwap(key, old, new)
+}
+
+func (m *RWMap[K, V]) CompareAndDelete(key K, old V) bool {
+	return m.compareAndDelete(key, old)
+}
+
+// Range calls fn for every pair in key order. The pairs are copied a batch at a time
+// and the map is not locked while fn runs, so fn may call any method of the map, but
+// as for sync.Map the pairs are not observed at a single point in time.
+func (m *RWMap[K, V]) Range(fn func(key K, value V) bool) {
+	c := &shardCursor[K, V]{shard: &m.shard}
+	for c.next() {
+		if p := c.buf[c.pos]; !fn(p.Key, p.Value) {
+			return
+		}
+	}
+}
+
+func (m *RWMap[K, V]) Floor(key K) (K, V, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	node := m.tree.FindUpperBoundNode(key)
+	if node == nil {
+		node = m.tree.Last()
+	} else {
+		node = node.Prev()
+	}
+	if node == nil {
+		return empty[K](), empty[V](), false
+	}
+	return node.Key(), node.Value(), true
+}
+
+func (m *RWMap[K, V]) Ceiling(key K) (K, V, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	node := m.tree.FindLowerBoundNode(key)
+	if node == nil {
+		return empty[K](), empty[V](), false
+	}
+	return node.Key(), node.Value(), true
+}
+
+func (m *RWMap[K, V]) Len() int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return int64(m.tree.Size())
+}
+
+func (m *RWMap[K, V]) Contains(key K) bool {
+	_, ok := m.Load(key)
+	return ok
+}
+
+func (m *RWMap[K, V]) MarshalJSON() ([]byte, error) {
+	m.mu.RLock()
+	s := make([]Pair[K, V], 0, m.tree.Size())
+	m.tree.Traversal(func(key K, value V) bool {
+		s = append(s, Pair[K, V]{Key: key, Value: value})
+		return true
+	})
+	m.mu.RUnlock()
+	return json.Marshal(s)
+}
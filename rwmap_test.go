@@ -0,0 +1,91 @@
+package odmap_test
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+
+	odmap "github.com/RealFax/order-map"
+)
+
+func TestRWMap_Model(t *testing.T) {
+	testModel(t, odmap.NewRWMap[int, int]())
+}
+
+func TestRWMap_RangeConcurrent(t *testing.T) {
+	const (
+		workers = 4
+		stable  = 1000
+	)
+	m := odmap.NewRWMap[int, int]()
+	// the keys that are multiples of 10 stay in the map, the workers write and delete
+	// the keys between them. Every key holds a tenth of itself.
+	for i := 0; i < stable; i++ {
+		m.Store(10*i, i)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < stable; i++ {
+				m.Store(10*i+1+w, i)
+				if i%2 == 0 {
+					m.Delete(10*(i-1) + 1 + w)
+				}
+				runtime.Gosched()
+			}
+		}(w)
+	}
+
+	// Range is not a point in time, but it visits every key present throughout it
+	// exactly once, in order and with a value stored for it
+	for i := 0; i < 100; i++ {
+		prev, kept := -1, 0
+		m.Range(func(key int, value int) bool {
+			if key <= prev {
+				t.Fatalf("got %d after %d", key, prev)
+			}
+			if value != key/10 {
+				t.Fatalf("key %d: got %d", key, value)
+			}
+			if prev = key; key%10 == 0 {
+				kept++
+			}
+			return true
+		})
+		if kept != stable {
+			t.Fatalf("got %d of the %d keys kept throughout", kept, stable)
+		}
+	}
+	wg.Wait()
+
+	if want := int64(stable + workers*stable/2 + workers); m.Len() != want {
+		t.Fatalf("got len %d, want %d", m.Len(), want)
+	}
+}
+
+func TestRWMap_RangeReentrant(t *testing.T) {
+	m := odmap.NewRWMap[int, int]()
+	for i := 0; i < 1000; i++ {
+		m.Store(i, i)
+	}
+
+	// fn reads and writes the map, which holding the read lock would deadlock
+	n := 0
+	m.Range(func(key int, value int) bool {
+		if v, ok := m.Load(key); !ok || v != value {
+			t.Fatalf("Load(%d): got %d, %v", key, v, ok)
+		}
+		m.Store(key, -value)
+		n++
+		return true
+	})
+	if n != 1000 {
+		t.Fatalf("got %d pairs, want 1000", n)
+	}
+	if v, _ := m.Load(999); v != -999 {
+		t.Fatalf("got %d, want -999", v)
+	}
+}
@@ -0,0 +1,331 @@
+package odmap
+
+import (
+	"cmp"
+	"container/heap"
+	"encoding/binary"
+	"encoding/json"
+	"hash/maphash"
+	"math"
+	"reflect"
+	"runtime"
+	"sync"
+	"unsafe"
+)
+
+// shardBatch is the number of pairs Range copies from a shard per lock
+const shardBatch = 64
+
+// ShardedMap is a concurrent ordered map partitioned by the hash of the keys. Every
+// shard has its own lock, so writers of different shards do not contend, ordered
+// reads merge the shards. Keys are ordered by cmp.Compare.
+type ShardedMap[K cmp.Ordered, V any] struct {
+	shards []shard[K, V]
+	hash   func(K) uint64
+}
+
+type shard[K cmp.Ordered, V any] struct {
+	mu   sync.RWMutex
+	tree *RBTree[K, V]
+}
+
+type shardConfig struct {
+	shards int
+}
+
+type ShardOption func(c *shardConfig)
+
+// WithShards sets the number of shards, there are four per CPU by default
+func WithShards(n int) ShardOption {
+	return func(c *shardConfig) {
+		c.shards = n
+	}
+}
+
+func NewSharded[K cmp.Ordered, V any](opts ...ShardOption) Map[K, V] {
+	c := shardConfig{shards: 4 * runtime.GOMAXPROCS(0)}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	c.shards = max(c.shards, 1)
+
+	m := &ShardedMap[K, V]{shards: make([]shard[K, V], c.shards), hash: newHasher[K]()}
+	for i := range m.shards {
+		m.shards[i].tree = NewRBTree[K, V](cmp.Compare[K])
+	}
+	return m
+}
+
+// newHasher returns a hash of the keys that agrees with cmp.Compare
+func newHasher[K cmp.Ordered]() func(K) uint64 {
+	seed := maphash.MakeSeed()
+	switch reflect.TypeOf((*K)(nil)).Elem().Kind() {
+	case reflect.String:
+		return func(key K) uint64 {
+			return maphash.String(seed, *(*string)(unsafe.Pointer(&key)))
+		}
+	case reflect.Float32:
+		return func(key K) uint64 {
+			return hashFloat(seed, float64(*(*float32)(unsafe.Pointer(&key))))
+		}
+	case reflect.Float64:
+		return func(key K) uint64 {
+			return hashFloat(seed, *(*float64)(unsafe.Pointer(&key)))
+		}
+	}
+	return func(key K) uint64 {
+		return maphash.Bytes(seed, unsafe.Slice((*byte)(unsafe.Pointer(&key)), unsafe.Sizeof(key)))
+	}
+}
+
+// hashFloat hashes the floats cmp.Compare considers equal alike, both zeros and every NaN
+func hashFloat(seed maphash.Seed, f float64) uint64 {
+	if f != f {
+		return 0
+	}
+	if f == 0 {
+		f = 0
+	}
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], math.Float64bits(f))
+	return maphash.Bytes(seed, b[:])
+}
+
+func (m *ShardedMap[K, V]) shard(key K) *shard[K, V] {
+	return &m.shards[m.hash(key)%uint64(len(m.shards))]
+}
+
+func (m *ShardedMap[K, V]) Load(key K) (V, bool) {
+	return m.shard(key).load(key)
+}
+
+func (m *ShardedMap[K, V]) Store(key K, value V) {
+	_, _ = m.Swap(key, value)
+}
+
+func (m *ShardedMap[K, V]) Swap(key K, value V) (V, bool) {
+	return m.shard(key).swap(key, value)
+}
+
+func (m *ShardedMap[K, V]) LoadOrStore(key K, value V) (V, bool) {
+	return m.shard(key).loadOrStore(key, value)
+}
+
+func (m *ShardedMap[K, V]) LoadAndDelete(key K) (V, bool) {
+	return m.shard(key).loadAndDelete(key)
+}
+
+func (m *ShardedMap[K, V]) Delete(key K) {
+	_, _ = m.LoadAndDelete(key)
+}
+
+func (m *ShardedMap[K, V]) CompareAndSwap(key K, old, new V) bool {
+	return m.shard(key).compareAndSwap(key, old, new)
+}
+
+func (m *ShardedMap[K, V]) CompareAndDelete(key K, old V) bool {
+	return m.shard(key).compareAndDelete(key, old)
+}
+
+func (s *shard[K, V]) load(key K) (V, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	node := s.tree.FindNode(key)
+	if node == nil {
+		return empty[V](), false
+	}
+	return node.Value(), true
+}
+
+func (s *shard[K, V]) swap(key K, value V) (V, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	node := s.tree.FindNode(key)
+	if node == nil {
+		s.tree.Insert(key, value)
+		return empty[V](), false
+	}
+	previous := node.Value()
+	node.value.Store(&value)
+	return previous, true
+}
+
+func (s *shard[K, V]) loadOrStore(key K, value V) (V, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if node := s.tree.FindNode(key); node != nil {
+		return node.Value(), true
+	}
+	s.tree.Insert(key, value)
+	return value, false
+}
+
+func (s *shard[K, V]) loadAndDelete(key K) (V, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	node := s.tree.FindNode(key)
+	if node == nil {
+		return empty[V](), false
+	}
+	value := node.Value()
+	s.tree.Delete(node)
+	return value, true
+}
+
+func (s *shard[K, V]) compareAndSwap(key K, old, new V) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	node := s.tree.FindNode(key)
+	if node == nil || any(node.Value()) != any(old) {
+		return false
+	}
+	node.value.Store(&new)
+	return true
+}
+
+func (s *shard[K, V]) compareAndDelete(key K, old V) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	node := s.tree.FindNode(key)
+	if node == nil || any(node.Value()) != any(old) {
+		return false
+	}
+	s.tree.Delete(node)
+	return true
+}
+
+// Range calls fn for every pair in key order. The shards are not locked while fn
+// runs, so as for sync.Map the pairs are not observed at a single point in time.
+func (m *ShardedMap[K, V]) Range(fn func(key K, value V) bool) {
+	h := make(shardCursors[K, V], 0, len(m.shards))
+	for i := range m.shards {
+		c := &shardCursor[K, V]{shard: &m.shards[i]}
+		if c.next() {
+			h = append(h, c)
+		}
+	}
+	heap.Init(&h)
+
+	for len(h) != 0 {
+		c := h[0]
+		p := c.buf[c.pos]
+		if !fn(p.Key, p.Value) {
+			return
+		}
+		if c.next() {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+}
+
+// shardCursor walks a shard in key order, copying a batch of pairs per lock
+type shardCursor[K cmp.Ordered, V any] struct {
+	shard *shard[K, V]
+	buf   []Pair[K, V]
+	pos   int
+	done  bool
+}
+
+func (c *shardCursor[K, V]) next() bool {
+	if c.pos+1 < len(c.buf) {
+		c.pos++
+		return true
+	}
+	if c.done {
+		return false
+	}
+
+	c.shard.mu.RLock()
+	node := c.shard.tree.First()
+	if len(c.buf) != 0 {
+		node = c.shard.tree.FindUpperBoundNode(c.buf[len(c.buf)-1].Key)
+	}
+	c.buf = c.buf[:0]
+	for ; node != nil && len(c.buf) < shardBatch; node = node.Next() {
+		c.buf = append(c.buf, Pair[K, V]{Key: node.Key(), Value: node.Value()})
+	}
+	c.shard.mu.RUnlock()
+
+	c.done = len(c.buf) < shardBatch
+	c.pos = 0
+	return len(c.buf) != 0
+}
+
+type shardCursors[K cmp.Ordered, V any] []*shardCursor[K, V]
+
+func (h shardCursors[K, V]) Len() int { return len(h) }
+func (h shardCursors[K, V]) Less(i, j int) bool {
+	return cmp.Less(h[i].buf[h[i].pos].Key, h[j].buf[h[j].pos].Key)
+}
+func (h shardCursors[K, V]) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *shardCursors[K, V]) Push(x any)   { *h = append(*h, x.(*shardCursor[K, V])) }
+func (h *shardCursors[K, V]) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+func (m *ShardedMap[K, V]) Floor(key K) (k K, v V, ok bool) {
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.mu.RLock()
+		node := s.tree.FindUpperBoundNode(key)
+		if node == nil {
+			node = s.tree.Last()
+		} else {
+			node = node.Prev()
+		}
+		if node != nil && (!ok || cmp.Less(k, node.Key())) {
+			k, v, ok = node.Key(), node.Value(), true
+		}
+		s.mu.RUnlock()
+	}
+	return
+}
+
+func (m *ShardedMap[K, V]) Ceiling(key K) (k K, v V, ok bool) {
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.mu.RLock()
+		node := s.tree.FindLowerBoundNode(key)
+		if node != nil && (!ok || cmp.Less(node.Key(), k)) {
+			k, v, ok = node.Key(), node.Value(), true
+		}
+		s.mu.RUnlock()
+	}
+	return
+}
+
+func (m *ShardedMap[K, V]) Len() int64 {
+	n := int64(0)
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.mu.RLock()
+		n += int64(s.tree.Size())
+		s.mu.RUnlock()
+	}
+	return n
+}
+
+func (m *ShardedMap[K, V]) Contains(key K) bool {
+	_, ok := m.Load(key)
+	return ok
+}
+
+func (m *ShardedMap[K, V]) MarshalJSON() ([]byte, error) {
+	s := make([]Pair[K, V], 0, 1024)
+	m.Range(func(key K, value V) bool {
+		s = append(s, Pair[K, V]{Key: key, Value: value})
+		return true
+	})
+	return json.Marshal(s)
+}
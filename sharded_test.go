@@ -0,0 +1,146 @@
+package odmap_test
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"slices"
+	"sort"
+	"sync"
+	"testing"
+
+	odmap "github.com/RealFax/order-map"
+)
+
+func TestOrderedMap_FloorCeiling(t *testing.T) {
+	maps := map[string]odmap.Map[int, int]{
+		"sharded":  odmap.NewSharded[int, int](odmap.WithShards(7)),
+		"skiplist": odmap.NewSkipList[int, int](),
+		"rwmap":    odmap.NewRWMap[int, int](),
+	}
+	for name, opts := range backendOptions {
+		maps["map/"+name] = newMap[int, int](opts...)
+	}
+	for name, m := range maps {
+		t.Run(name, func(t *testing.T) {
+			for i := 10; i <= 50; i += 10 {
+				m.Store(i, i)
+			}
+			m.Delete(30)
+
+			nav := m.(odmap.Navigator[int, int])
+			for _, tt := range []struct {
+				key         int
+				floor, ceil int
+				hasF, hasC  bool
+			}{
+				{key: 5, ceil: 10, hasC: true},
+				{key: 10, floor: 10, ceil: 10, hasF: true, hasC: true},
+				{key: 30, floor: 20, ceil: 40, hasF: true, hasC: true},
+				{key: 55, floor: 50, hasF: true},
+			} {
+				if k, v, ok := nav.Floor(tt.key); ok != tt.hasF || (ok && (k != tt.floor || v != tt.floor)) {
+					t.Errorf("Floor(%d): got %d, %d, %v", tt.key, k, v, ok)
+				}
+				if k, v, ok := nav.Ceiling(tt.key); ok != tt.hasC || (ok && (k != tt.ceil || v != tt.ceil)) {
+					t.Errorf("Ceiling(%d): got %d, %d, %v", tt.key, k, v, ok)
+				}
+			}
+		})
+	}
+}
+
+func TestShardedMap_Range(t *testing.T) {
+	m := odmap.NewSharded[int, int](odmap.WithShards(5))
+	r := rand.New(rand.NewSource(1))
+	model := make(map[int]int)
+	for i := 0; i < 5000; i++ {
+		key := r.Intn(1000)
+		if r.Intn(4) == 0 {
+			m.Delete(key)
+			delete(model, key)
+		} else {
+			m.Store(key, i)
+			model[key] = i
+		}
+	}
+
+	keys := make([]int, 0, len(model))
+	for key := range model {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+
+	var got []int
+	m.Range(func(key int, value int) bool {
+		if value != model[key] {
+			t.Fatalf("key %d: got %d, want %d", key, value, model[key])
+		}
+		got = append(got, key)
+		return true
+	})
+	if !slices.Equal(got, keys) {
+		t.Fatalf("got %d keys out of order or missing, want %d", len(got), len(keys))
+	}
+	if m.Len() != int64(len(keys)) {
+		t.Fatalf("got len %d, want %d", m.Len(), len(keys))
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pairs []odmap.Pair[int, int]
+	if err = json.Unmarshal(data, &pairs); err != nil {
+		t.Fatal(err)
+	}
+	if len(pairs) != len(keys) || pairs[0].Key != keys[0] {
+		t.Fatalf("got %d pairs starting at %v", len(pairs), pairs[0])
+	}
+}
+
+func TestShardedMap_FloatKeys(t *testing.T) {
+	m := odmap.NewSharded[float64, int](odmap.WithShards(16))
+	m.Store(0, 1)
+	m.Store(math.NaN(), 2)
+	if v, ok := m.Load(math.Copysign(0, -1)); !ok || v != 1 {
+		t.Fatalf("-0: got %d, %v", v, ok)
+	}
+	if v, ok := m.Load(math.NaN()); !ok || v != 2 {
+		t.Fatalf("NaN: got %d, %v", v, ok)
+	}
+}
+
+func TestShardedMap_Concurrent(t *testing.T) {
+	const workers = 8
+	m := odmap.NewSharded[int, int]()
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				m.Store(i*workers+w, i)
+				if i%10 == 0 {
+					m.Range(func(key int, value int) bool { return key < 100 })
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if m.Len() != workers*1000 {
+		t.Fatalf("got len %d, want %d", m.Len(), workers*1000)
+	}
+}
+
+func BenchmarkShardedMap_StoreParallel(b *testing.B) {
+	m := odmap.NewSharded[int, int]()
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(rand.Int63()))
+		for pb.Next() {
+			m.Store(r.Intn(1<<20), 0)
+		}
+	})
+}
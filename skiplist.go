@@ -0,0 +1,350 @@
+package odmap
+
+import (
+	"cmp"
+	"encoding/json"
+	"math/bits"
+	"math/rand"
+	"sync/atomic"
+)
+
+// skipListLevels bounds the height of a skip list, enough for 4^24 keys
+const skipListLevels = 24
+
+// SkipListMap is a lock-free concurrent ordered map. Writers never block each other,
+// they link and unlink nodes with compare-and-swap and help the writers they race
+// with. Keys are ordered by cmp.Compare.
+type SkipListMap[K cmp.Ordered, V any] struct {
+	compare func(K, K) int
+	head    *slNode[K, V]
+	size    atomic.Int64
+}
+
+// slNode is a node of the skip list. A nil value marks a deleted node, which is then
+// marked in every level and unlinked by the next traversal.
+type slNode[K cmp.Ordered, V any] struct {
+	key   K
+	value atomic.Pointer[V]
+	next  []atomic.Pointer[slLink[K, V]]
+}
+
+// slLink is an immutable successor reference, marked once the node holding it is deleted
+type slLink[K cmp.Ordered, V any] struct {
+	node   *slNode[K, V]
+	marked bool
+}
+
+// slPath is the position of a key in every level
+type slPath[K cmp.Ordered, V any] struct {
+	preds [skipListLevels]*slNode[K, V]
+	links [skipListLevels]*slLink[K, V]
+	succs [skipListLevels]*slNode[K, V]
+}
+
+func NewSkipList[K cmp.Ordered, V any]() Map[K, V] {
+	return newSkipList[K, V](cmp.Compare[K])
+}
+
+func newSkipList[K cmp.Ordered, V any](compare func(K, K) int) *SkipListMap[K, V] {
+	head := &slNode[K, V]{next: make([]atomic.Pointer[slLink[K, V]], skipListLevels)}
+	for i := range head.next {
+		head.next[i].Store(&slLink[K, V]{})
+	}
+	return &SkipListMap[K, V]{compare: compare, head: head}
+}
+
+// randomLevel returns the height of a new node, each level is four times rarer
+func randomLevel() int {
+	return min(1+bits.TrailingZeros64(rand.Uint64())/2, skipListLevels)
+}
+
+// find fills path with the position of key, unlinking the marked nodes on the way,
+// and returns the node holding key
+func (l *SkipListMap[K, V]) find(key K, path *slPath[K, V]) *slNode[K, V] {
+retry:
+	pred := l.head
+	for level := skipListLevels - 1; level >= 0; level-- {
+		link := pred.next[level].Load()
+		if link.marked {
+			// pred was deleted since it was reached
+			goto retry
+		}
+		curr := link.node
+		for curr != nil {
+			next := curr.next[level].Load()
+			if next.marked {
+				unlinked := &slLink[K, V]{node: next.node}
+				if !pred.next[level].CompareAndSwap(link, unlinked) {
+					goto retry
+				}
+				link, curr = unlinked, next.node
+				continue
+			}
+			if l.compare(curr.key, key) < 0 {
+				pred, link, curr = curr, next, next.node
+				continue
+			}
+			break
+		}
+		path.preds[level], path.links[level], path.succs[level] = pred, link, curr
+	}
+
+	if n := path.succs[0]; n != nil && l.compare(n.key, key) == 0 {
+		return n
+	}
+	return nil
+}
+
+// descend walks down to the last node of level 0 for which before holds, skipping
+// the marked nodes without unlinking them
+func (l *SkipListMap[K, V]) descend(before func(key K) bool) (pred, succ *slNode[K, V]) {
+	pred = l.head
+	for level := skipListLevels - 1; level >= 0; level-- {
+		succ = pred.next[level].Load().node
+		for succ != nil {
+			next := succ.next[level].Load()
+			if next.marked {
+				succ = next.node
+				continue
+			}
+			if !before(succ.key) {
+				break
+			}
+			pred, succ = succ, next.node
+		}
+	}
+	return pred, succ
+}
+
+// lookup returns the node holding key
+func (l *SkipListMap[K, V]) lookup(key K) *slNode[K, V] {
+	_, n := l.descend(func(k K) bool { return l.compare(k, key) < 0 })
+	if n != nil && l.compare(n.key, key) == 0 {
+		return n
+	}
+	return nil
+}
+
+// mark marks every level of a deleted node, top down so that it is unlinked from
+// the upper levels first
+func (n *slNode[K, V]) mark() {
+	for level := len(n.next) - 1; level >= 0; level-- {
+		for {
+			link := n.next[level].Load()
+			if link.marked || n.next[level].CompareAndSwap(link, &slLink[K, V]{node: link.node, marked: true}) {
+				break
+			}
+		}
+	}
+}
+
+// remove takes the value of n, the winner of concurrent removals gets it and unlinks n
+func (l *SkipListMap[K, V]) remove(n *slNode[K, V], match func(V) bool) (V, bool) {
+	for {
+		p := n.value.Load()
+		if p == nil || (match != nil && !match(*p)) {
+			return empty[V](), false
+		}
+		if n.value.CompareAndSwap(p, nil) {
+			n.mark()
+			l.size.Add(-1)
+			var path slPath[K, V]
+			l.find(n.key, &path)
+			return *p, true
+		}
+	}
+}
+
+// insert links a new node holding value, it fails when the path changed meanwhile
+func (l *SkipListMap[K, V]) insert(key K, value V, path *slPath[K, V]) bool {
+	n := &slNode[K, V]{key: key, next: make([]atomic.Pointer[slLink[K, V]], randomLevel())}
+	n.value.Store(&value)
+	for level := range n.next {
+		n.next[level].Store(&slLink[K, V]{node: path.succs[level]})
+	}
+	if !path.preds[0].next[0].CompareAndSwap(path.links[0], &slLink[K, V]{node: n}) {
+		return false
+	}
+	l.size.Add(1)
+
+	// the node is in the map, the upper levels only speed up searches
+	for level := 1; level < len(n.next); level++ {
+		for {
+			link := n.next[level].Load()
+			if link.marked {
+				return true
+			}
+			if link.node != path.succs[level] &&
+				!n.next[level].CompareAndSwap(link, &slLink[K, V]{node: path.succs[level]}) {
+				continue
+			}
+			if path.preds[level].next[level].CompareAndSwap(path.links[level], &slLink[K, V]{node: n}) {
+				break
+			}
+			if l.find(key, path) != n {
+				// deleted meanwhile
+				return true
+			}
+		}
+	}
+	return true
+}
+
+func (l *SkipListMap[K, V]) Load(key K) (V, bool) {
+	n := l.lookup(key)
+	if n == nil {
+		return empty[V](), false
+	}
+	if p := n.value.Load(); p != nil {
+		return *p, true
+	}
+	return empty[V](), false
+}
+
+func (l *SkipListMap[K, V]) Store(key K, value V) {
+	_, _ = l.Swap(key, value)
+}
+
+func (l *SkipListMap[K, V]) Swap(key K, value V) (V, bool) {
+	var path slPath[K, V]
+	for {
+		if n := l.find(key, &path); n != nil {
+			if p := n.value.Load(); p != nil {
+				if n.value.CompareAndSwap(p, &value) {
+					return *p, true
+				}
+				continue
+			}
+			// deleted but still linked, help unlinking it
+			n.mark()
+			continue
+		}
+		if l.insert(key, value, &path) {
+			return empty[V](), false
+		}
+	}
+}
+
+func (l *SkipListMap[K, V]) LoadOrStore(key K, value V) (V, bool) {
+	var path slPath[K, V]
+	for {
+		if n := l.find(key, &path); n != nil {
+			if p := n.value.Load(); p != nil {
+				return *p, true
+			}
+			n.mark()
+			continue
+		}
+		if l.insert(key, value, &path) {
+			return value, false
+		}
+	}
+}
+
+func (l *SkipListMap[K, V]) LoadAndDelete(key K) (V, bool) {
+	n := l.lookup(key)
+	if n == nil {
+		return empty[V](), false
+	}
+	return l.remove(n, nil)
+}
+
+func (l *SkipListMap[K, V]) Delete(key K) {
+	_, _ = l.LoadAndDelete(key)
+}
+
+func (l *SkipListMap[K, V]) CompareAndSwap(key K, old, new V) bool {
+	n := l.lookup(key)
+	if n == nil {
+		return false
+	}
+	for {
+		p := n.value.Load()
+		if p == nil || any(*p) != any(old) {
+			return false
+		}
+		if n.value.CompareAndSwap(p, &new) {
+			return true
+		}
+	}
+}
+
+func (l *SkipListMap[K, V]) CompareAndDelete(key K, old V) bool {
+	n := l.lookup(key)
+	if n == nil {
+		return false
+	}
+	_, deleted := l.remove(n, func(v V) bool { return any(v) == any(old) })
+	return deleted
+}
+
+// Range calls fn for every pair in key order. It is weakly consistent: a pair
+// written or deleted while Range runs may or may not be observed.
+func (l *SkipListMap[K, V]) Range(fn func(key K, value V) bool) {
+	for n := l.head.next[0].Load().node; n != nil; n = n.next[0].Load().node {
+		if p := n.value.Load(); p != nil && !fn(n.key, *p) {
+			return
+		}
+	}
+}
+
+// last returns the last live node for whose key before holds
+func (l *SkipListMap[K, V]) last(before func(key K) bool) (*slNode[K, V], *V) {
+	for {
+		pred, _ := l.descend(before)
+		if pred == l.head {
+			return nil, nil
+		}
+		if p := pred.value.Load(); p != nil {
+			return pred, p
+		}
+		// deleted, look before it
+		key := pred.key
+		before = func(k K) bool { return l.compare(k, key) < 0 }
+	}
+}
+
+// first returns the first live node from n on
+func (l *SkipListMap[K, V]) first(n *slNode[K, V]) (*slNode[K, V], *V) {
+	for ; n != nil; n = n.next[0].Load().node {
+		if p := n.value.Load(); p != nil {
+			return n, p
+		}
+	}
+	return nil, nil
+}
+
+func (l *SkipListMap[K, V]) Floor(key K) (K, V, bool) {
+	n, p := l.last(func(k K) bool { return l.compare(k, key) <= 0 })
+	if n == nil {
+		return empty[K](), empty[V](), false
+	}
+	return n.key, *p, true
+}
+
+func (l *SkipListMap[K, V]) Ceiling(key K) (K, V, bool) {
+	_, n := l.descend(func(k K) bool { return l.compare(k, key) < 0 })
+	n, p := l.first(n)
+	if n == nil {
+		return empty[K](), empty[V](), false
+	}
+	return n.key, *p, true
+}
+
+func (l *SkipListMap[K, V]) Len() int64 {
+	return l.size.Load()
+}
+
+func (l *SkipListMap[K, V]) Contains(key K) bool {
+	_, ok := l.Load(key)
+	return ok
+}
+
+func (l *SkipListMap[K, V]) MarshalJSON() ([]byte, error) {
+	s := make([]Pair[K, V], 0, 1024)
+	l.Range(func(key K, value V) bool {
+		s = append(s, Pair[K, V]{Key: key, Value: value})
+		return true
+	})
+	return json.Marshal(s)
+}
@@ -0,0 +1,299 @@
+package odmap_test
+
+import (
+	"cmp"
+	"math"
+	"math/rand"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	odmap "github.com/RealFax/order-map"
+)
+
+// checkModel fails unless m holds exactly the pairs of model, in the same order
+func checkModel(t *testing.T, m odmap.Map[int, int], model *odmap.RBTree[int, int]) {
+	t.Helper()
+	node := model.First()
+	m.Range(func(key int, value int) bool {
+		if node == nil {
+			t.Fatalf("unexpected key %d", key)
+		}
+		if key != node.Key() || value != node.Value() {
+			t.Fatalf("got %d=%d, want %d=%d", key, value, node.Key(), node.Value())
+		}
+		node = node.Next()
+		return true
+	})
+	if node != nil {
+		t.Fatalf("missing key %d", node.Key())
+	}
+	if m.Len() != int64(model.Size()) {
+		t.Fatalf("got len %d, want %d", m.Len(), model.Size())
+	}
+}
+
+func modelPut(model *odmap.RBTree[int, int], key, value int) {
+	if node := model.FindNode(key); node != nil {
+		model.Delete(node)
+	}
+	model.Insert(key, value)
+}
+
+func TestSkipListMap_Model(t *testing.T) {
+	testModel(t, odmap.NewSkipList[int, int]())
+}
+
+// testModel runs random operations against m and an RBTree in lockstep
+func testModel(t *testing.T, m odmap.Map[int, int]) {
+	model := odmap.NewRBTree[int, int](cmp.Compare[int])
+	r := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 20000; i++ {
+		key := r.Intn(500)
+		node := model.FindNode(key)
+		switch r.Intn(6) {
+		case 0:
+			v, ok := m.Swap(key, i)
+			if ok != (node != nil) || (ok && v != node.Value()) {
+				t.Fatalf("Swap(%d): got %d, %v", key, v, ok)
+			}
+			modelPut(model, key, i)
+		case 1:
+			v, loaded := m.LoadOrStore(key, i)
+			if loaded != (node != nil) || (loaded && v != node.Value()) || (!loaded && v != i) {
+				t.Fatalf("LoadOrStore(%d): got %d, %v", key, v, loaded)
+			}
+			if !loaded {
+				model.Insert(key, i)
+			}
+		case 2:
+			v, ok := m.LoadAndDelete(key)
+			if ok != (node != nil) || (ok && v != node.Value()) {
+				t.Fatalf("LoadAndDelete(%d): got %d, %v", key, v, ok)
+			}
+			model.Delete(node)
+		case 3:
+			old := r.Intn(i + 1)
+			want := node != nil && node.Value() == old
+			if m.CompareAndSwap(key, old, i) != want {
+				t.Fatalf("CompareAndSwap(%d, %d): want %v", key, old, want)
+			}
+			if want {
+				modelPut(model, key, i)
+			}
+		case 4:
+			var old int
+			if node != nil && r.Intn(2) == 0 {
+				old = node.Value()
+			}
+			want := node != nil && node.Value() == old
+			if m.CompareAndDelete(key, old) != want {
+				t.Fatalf("CompareAndDelete(%d, %d): want %v", key, old, want)
+			}
+			if want {
+				model.Delete(node)
+			}
+		default:
+			v, ok := m.Load(key)
+			if ok != (node != nil) || (ok && v != node.Value()) {
+				t.Fatalf("Load(%d): got %d, %v", key, v, ok)
+			}
+		}
+
+		if i%1000 == 0 {
+			checkModel(t, m, model)
+		}
+	}
+	checkModel(t, m, model)
+}
+
+func TestSkipListMap_FloatKeys(t *testing.T) {
+	m := odmap.NewSkipList[float64, int]()
+	m.Store(0, 1)
+	m.Store(math.NaN(), 2)
+	if v, ok := m.Load(math.Copysign(0, -1)); !ok || v != 1 {
+		t.Fatalf("-0: got %d, %v", v, ok)
+	}
+	if v, ok := m.Load(math.NaN()); !ok || v != 2 {
+		t.Fatalf("NaN: got %d, %v", v, ok)
+	}
+	if k, _, ok := m.(odmap.Navigator[float64, int]).Floor(-1); !ok || !math.IsNaN(k) {
+		t.Fatalf("Floor(-1): got %v, %v", k, ok)
+	}
+}
+
+// TestSkipListMap_Stress runs random writes of disjoint key sets against the same
+// list, every worker keeps its own model, the list must end up as their union
+func TestSkipListMap_Stress(t *testing.T) {
+	const (
+		workers = 8
+		keys    = 256
+		ops     = 20000
+	)
+	m := odmap.NewSkipList[int, int]()
+	models := make([]*odmap.RBTree[int, int], workers)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		models[w] = odmap.NewRBTree[int, int](cmp.Compare[int])
+		wg.Add(1)
+		go func(w int, model *odmap.RBTree[int, int]) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(int64(w)))
+			for i := 0; i < ops; i++ {
+				// interleave the workers' keys so that they share predecessors
+				key := r.Intn(keys)*workers + w
+				node := model.FindNode(key)
+				switch r.Intn(3) {
+				case 0:
+					if _, ok := m.LoadAndDelete(key); ok != (node != nil) {
+						t.Errorf("LoadAndDelete(%d): got %v", key, ok)
+						return
+					}
+					model.Delete(node)
+				case 1:
+					if _, ok := m.Swap(key, i); ok != (node != nil) {
+						t.Errorf("Swap(%d): got %v", key, ok)
+						return
+					}
+					modelPut(model, key, i)
+				default:
+					v, ok := m.Load(key)
+					if ok != (node != nil) || (ok && v != node.Value()) {
+						t.Errorf("Load(%d): got %d, %v", key, v, ok)
+						return
+					}
+				}
+				if i%64 == 0 {
+					runtime.Gosched()
+				}
+			}
+		}(w, models[w])
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+
+	union := odmap.NewRBTree[int, int](cmp.Compare[int])
+	for _, model := range models {
+		for node := model.First(); node != nil; node = node.Next() {
+			union.Insert(node.Key(), node.Value())
+		}
+	}
+	checkModel(t, m, union)
+}
+
+func TestSkipListMap_RangeConcurrent(t *testing.T) {
+	const keys = 2000
+	m := odmap.NewSkipList[int, int]()
+	// the even keys are never touched, Range must always see them
+	for i := 0; i < keys; i += 2 {
+		m.Store(i, i)
+	}
+
+	var (
+		wg   sync.WaitGroup
+		stop atomic.Bool
+	)
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(int64(w)))
+			for !stop.Load() {
+				key := r.Intn(keys/2)*2 + 1
+				if r.Intn(2) == 0 {
+					m.Delete(key)
+				} else {
+					m.Store(key, key)
+				}
+				runtime.Gosched()
+			}
+		}(w)
+	}
+	defer func() {
+		stop.Store(true)
+		wg.Wait()
+	}()
+
+	for i := 0; i < 200; i++ {
+		prev, even := -1, 0
+		m.Range(func(key int, value int) bool {
+			if key <= prev || value != key {
+				t.Fatalf("got %d=%d after %d", key, value, prev)
+			}
+			if key%2 == 0 {
+				if key != even {
+					t.Fatalf("missing key %d", even)
+				}
+				even += 2
+			}
+			prev = key
+			return true
+		})
+		if even != keys {
+			t.Fatalf("got %d even keys, want %d", even/2, keys/2)
+		}
+	}
+}
+
+func TestSkipListMap_LoadOrStoreConcurrent(t *testing.T) {
+	const (
+		workers = 8
+		keys    = 500
+	)
+	m := odmap.NewSkipList[int, int]()
+	stored := make([]atomic.Int32, keys)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 3*keys; i++ {
+				key := (i*7 + w) % keys
+				if i%3 == 2 {
+					// deleting lets another LoadOrStore win the key again
+					if _, ok := m.LoadAndDelete(key); ok {
+						stored[key].Add(-1)
+					}
+					continue
+				}
+				if _, loaded := m.LoadOrStore(key, w); !loaded {
+					stored[key].Add(1)
+				}
+				if i%16 == 0 {
+					runtime.Gosched()
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	var n int64
+	for key := range stored {
+		_, ok := m.Load(key)
+		if c := stored[key].Load(); (ok && c != 1) || (!ok && c != 0) {
+			t.Fatalf("key %d: stored %d times, present %v", key, c, ok)
+		}
+		if ok {
+			n++
+		}
+	}
+	if m.Len() != n {
+		t.Fatalf("got len %d, want %d", m.Len(), n)
+	}
+}
+
+func BenchmarkSkipListMap_StoreParallel(b *testing.B) {
+	m := odmap.NewSkipList[int, int]()
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(rand.Int63()))
+		for pb.Next() {
+			m.Store(r.Intn(1<<20), 0)
+		}
+	})
+}
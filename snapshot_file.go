@@ -0,0 +1,394 @@
+package odmap
+
+import (
+	"bufio"
+	"cmp"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+var ErrSnapshotFormat = errors.New("odmap: malformed snapshot")
+
+// Compression is the compression of the body of a snapshot
+type Compression uint16
+
+const (
+	CompressionNone Compression = iota
+	CompressionFlate
+	CompressionGzip
+)
+
+type snapshotConfig struct {
+	compression Compression
+	blockSize   int
+	keys        KeyProvider
+}
+
+type SnapshotOption func(c *snapshotConfig)
+
+// WithCompression compresses the body of the snapshot, it is not by default
+func WithCompression(compression Compression) SnapshotOption {
+	return func(c *snapshotConfig) {
+		c.compression = compression
+	}
+}
+
+// WithBlockSize sets the size the blocks of the snapshot grow to before they are
+// written, 64 KiB by default
+func WithBlockSize(size int) SnapshotOption {
+	return func(c *snapshotConfig) {
+		c.blockSize = size
+	}
+}
+
+// WithEncryption encrypts the snapshot with the current key of keys, as a stream of
+// NewEncryptWriter that is read through NewDecryptReader. The body is compressed
+// before it is encrypted.
+func WithEncryption(keys KeyProvider) SnapshotOption {
+	return func(c *snapshotConfig) {
+		c.keys = keys
+	}
+}
+
+// A snapshot starts with a header of little-endian fields:
+//
+//	magic       [4]byte "ODMS", "ODMD" for a delta
+//	version     uint16
+//	compression uint16
+//	checksum    uint32, CRC-32C of the fields above
+//
+// The body follows, compressed as a whole if the header says so. It is a sequence of
+// blocks, each with a header:
+//
+//	length   uint32, of the pairs
+//	count    uint32, of the pairs
+//	checksum uint32, CRC-32C of the pairs
+//
+// and the pairs in increasing key order, a uvarint key length, the key, a uvarint
+// value length and the value. The pairs of a delta are preceded by the operation that
+// wrote them, the value of a deleted key is empty. A block of no pair ends the body.
+const (
+	snapshotMagic       = "ODMS"
+	deltaMagic          = "ODMD"
+	snapshotVersion     = 1
+	snapshotHeader      = 12
+	snapshotBlockHeader = 12
+	defaultBlockSize    = 64 << 10
+)
+
+// bulkLoader is a map that can be filled in linear time while empty
+type bulkLoader[K cmp.Ordered, V any] interface {
+	loadSorted(keys []K, values []V) error
+}
+
+// sortedKeys reports whether keys strictly increase
+func sortedKeys[K cmp.Ordered](compare func(K, K) int, keys []K) bool {
+	for i := 1; i < len(keys); i++ {
+		if compare(keys[i-1], keys[i]) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteSnapshot streams the pairs of m to w in key order, a block at a time
+func WriteSnapshot[K cmp.Ordered, V any](w io.Writer, m ranger[K, V], keys Codec[K], values Codec[V], opts ...SnapshotOption) error {
+	sw, err := newSnapshotWriter(w, snapshotMagic, opts)
+	if err != nil {
+		return err
+	}
+	var key, value []byte
+	m.Range(func(k K, v V) bool {
+		if key, err = keys.Append(key[:0], k); err != nil {
+			return false
+		}
+		if value, err = values.Append(value[:0], v); err != nil {
+			return false
+		}
+		err = sw.add(0, key, value)
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	return sw.close()
+}
+
+// snapshotWriter writes the blocks of a snapshot or a delta
+type snapshotWriter struct {
+	body io.WriteCloser
+	// encrypted encrypts the snapshot when it is
+	encrypted io.WriteCloser
+	delta     bool
+	blockSize int
+
+	header [snapshotBlockHeader]byte
+	pairs  []byte
+	count  uint32
+}
+
+func newSnapshotWriter(w io.Writer, magic string, opts []SnapshotOption) (*snapshotWriter, error) {
+	c := snapshotConfig{blockSize: defaultBlockSize}
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	var encrypted io.WriteCloser
+	if c.keys != nil {
+		var err error
+		if encrypted, err = NewEncryptWriter(w, c.keys); err != nil {
+			return nil, err
+		}
+		w = encrypted
+	}
+
+	header := make([]byte, snapshotHeader)
+	copy(header, magic)
+	binary.LittleEndian.PutUint16(header[4:], snapshotVersion)
+	binary.LittleEndian.PutUint16(header[6:], uint16(c.compression))
+	binary.LittleEndian.PutUint32(header[8:], crc32.Checksum(header[:8], castagnoli))
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	body, err := compressor(w, c.compression)
+	if err != nil {
+		return nil, err
+	}
+	return &snapshotWriter{body: body, encrypted: encrypted, delta: magic == deltaMagic, blockSize: c.blockSize}, nil
+}
+
+// add appends a pair, preceded by op in a delta
+func (s *snapshotWriter) add(op byte, key, value []byte) error {
+	if s.delta {
+		s.pairs = append(s.pairs, op)
+	}
+	s.pairs = append(binary.AppendUvarint(s.pairs, uint64(len(key))), key...)
+	s.pairs = append(binary.AppendUvarint(s.pairs, uint64(len(value))), value...)
+	s.count++
+	if len(s.pairs) >= s.blockSize {
+		return s.flush()
+	}
+	return nil
+}
+
+func (s *snapshotWriter) flush() error {
+	binary.LittleEndian.PutUint32(s.header[:], uint32(len(s.pairs)))
+	binary.LittleEndian.PutUint32(s.header[4:], s.count)
+	binary.LittleEndian.PutUint32(s.header[8:], crc32.Checksum(s.pairs, castagnoli))
+	if _, err := s.body.Write(s.header[:]); err != nil {
+		return err
+	}
+	if _, err := s.body.Write(s.pairs); err != nil {
+		return err
+	}
+	s.pairs, s.count = s.pairs[:0], 0
+	return nil
+}
+
+// close writes the last block and the empty one ending the body
+func (s *snapshotWriter) close() error {
+	if s.count > 0 {
+		if err := s.flush(); err != nil {
+			return err
+		}
+	}
+	if err := s.flush(); err != nil {
+		return err
+	}
+	if err := s.body.Close(); err != nil {
+		return err
+	}
+	if s.encrypted != nil {
+		return s.encrypted.Close()
+	}
+	return nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+func compressor(w io.Writer, compression Compression) (io.WriteCloser, error) {
+	switch compression {
+	case CompressionNone:
+		return nopCloser{w}, nil
+	case CompressionFlate:
+		return flate.NewWriter(w, flate.DefaultCompression)
+	case CompressionGzip:
+		return gzip.NewWriter(w), nil
+	}
+	return nil, ErrSnapshotFormat
+}
+
+// ReadSnapshot returns a map of the pairs of the snapshot read from r, created with
+// opts. The pairs arrive in key order, so the map is built in linear time.
+func ReadSnapshot[K cmp.Ordered, V any](r io.Reader, keys Codec[K], values Codec[V], opts ...Option[K, V]) (Map[K, V], error) {
+	sr, err := newSnapshotReader(r, snapshotMagic)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		ks []K
+		vs []V
+	)
+	for {
+		_, key, value, err := sr.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		k, err := keys.Decode(key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := values.Decode(value)
+		if err != nil {
+			return nil, err
+		}
+		ks, vs = append(ks, k), append(vs, v)
+	}
+
+	m := New[K, V](opts...)
+	if err := m.(bulkLoader[K, V]).loadSorted(ks, vs); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// snapshotReader reads the pairs of a snapshot or a delta a block at a time
+type snapshotReader struct {
+	body       io.Reader
+	compressed bool
+	delta      bool
+
+	header [snapshotBlockHeader]byte
+	block  []byte
+	// pairs holds the pairs of the block not read yet, count their number
+	pairs []byte
+	count uint32
+}
+
+func newSnapshotReader(r io.Reader, magic string) (*snapshotReader, error) {
+	header := make([]byte, snapshotHeader)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, snapshotError(err)
+	}
+	if string(header[:4]) != magic || binary.LittleEndian.Uint16(header[4:]) != snapshotVersion ||
+		binary.LittleEndian.Uint32(header[8:]) != crc32.Checksum(header[:8], castagnoli) {
+		return nil, ErrSnapshotFormat
+	}
+
+	s := &snapshotReader{delta: magic == deltaMagic, compressed: true}
+	switch Compression(binary.LittleEndian.Uint16(header[6:])) {
+	case CompressionNone:
+		s.body, s.compressed = bufio.NewReader(r), false
+	case CompressionFlate:
+		s.body = flate.NewReader(r)
+	case CompressionGzip:
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, snapshotError(err)
+		}
+		gz.Multistream(false)
+		s.body = gz
+	default:
+		return nil, ErrSnapshotFormat
+	}
+	return s, nil
+}
+
+// next returns the next pair, and the operation that wrote it in a delta, or io.EOF
+// past the last one. The pair is only valid until the next call.
+func (s *snapshotReader) next() (op byte, key, value []byte, err error) {
+	for s.count == 0 {
+		if len(s.pairs) != 0 {
+			return 0, nil, nil, ErrSnapshotFormat
+		}
+		if err = s.readBlock(); err != nil {
+			return 0, nil, nil, err
+		}
+	}
+
+	b, ok := s.pairs, true
+	if s.delta {
+		if len(b) == 0 {
+			return 0, nil, nil, ErrSnapshotFormat
+		}
+		op, b = b[0], b[1:]
+	}
+	if key, b, ok = readField(b); !ok {
+		return 0, nil, nil, ErrSnapshotFormat
+	}
+	if value, b, ok = readField(b); !ok {
+		return 0, nil, nil, ErrSnapshotFormat
+	}
+	s.pairs, s.count = b, s.count-1
+	return op, key, value, nil
+}
+
+// readBlock reads the next block, or returns io.EOF past the last one
+func (s *snapshotReader) readBlock() error {
+	if s.body == nil {
+		return io.EOF
+	}
+	if _, err := io.ReadFull(s.body, s.header[:]); err != nil {
+		return snapshotError(err)
+	}
+	n, count := binary.LittleEndian.Uint32(s.header[:]), binary.LittleEndian.Uint32(s.header[4:])
+	if n == 0 {
+		// a compressed body ends with the end of its stream, which checks it as a whole
+		if s.compressed {
+			if n, err := io.Copy(io.Discard, s.body); err != nil {
+				return snapshotError(err)
+			} else if n != 0 {
+				return ErrSnapshotFormat
+			}
+		}
+		s.body = nil
+		return io.EOF
+	}
+
+	// a damaged length is caught by the checksum once the pairs are read, read them a
+	// chunk at a time so that it cannot make us allocate it at once
+	s.block = s.block[:0]
+	for remaining := int(n); remaining > 0; {
+		chunk := min(remaining, defaultBlockSize)
+		s.block = append(s.block, make([]byte, chunk)...)
+		if _, err := io.ReadFull(s.body, s.block[len(s.block)-chunk:]); err != nil {
+			return snapshotError(err)
+		}
+		remaining -= chunk
+	}
+	if crc32.Checksum(s.block, castagnoli) != binary.LittleEndian.Uint32(s.header[8:]) {
+		return ErrSnapshotFormat
+	}
+	s.pairs, s.count = s.block, count
+	return nil
+}
+
+// readField returns the length-prefixed field starting b and the bytes following it
+func readField(b []byte) ([]byte, []byte, bool) {
+	n, w := binary.Uvarint(b)
+	if w <= 0 || n > uint64(len(b)-w) {
+		return nil, nil, false
+	}
+	return b[w : w+int(n) : w+int(n)], b[w+int(n):], true
+}
+
+// snapshotError reports a snapshot cut short as malformed
+func snapshotError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrSnapshotFormat
+	}
+	return err
+}
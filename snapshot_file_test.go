@@ -0,0 +1,142 @@
+package odmap_test
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"testing"
+
+	odmap "github.com/RealFax/order-map"
+)
+
+var compressions = map[string]odmap.Compression{
+	"none":  odmap.CompressionNone,
+	"flate": odmap.CompressionFlate,
+	"gzip":  odmap.CompressionGzip,
+}
+
+func TestSnapshotFile_RoundTrip(t *testing.T) {
+	for name, compression := range compressions {
+		for _, size := range []int{0, 1, 1000} {
+			t.Run(fmt.Sprint(name, "/", size), func(t *testing.T) {
+				m := newMap[string, []int]()
+				for i := 0; i < size; i++ {
+					m.Store(fmt.Sprintf("key-%04d", i), []int{i, -i})
+				}
+				m.Store("", nil)
+
+				var buf bytes.Buffer
+				// small blocks make the snapshot span many of them
+				err := odmap.WriteSnapshot(&buf, m, odmap.KeyCodec[string](), odmap.JSONCodec[[]int](),
+					odmap.WithCompression(compression), odmap.WithBlockSize(256))
+				if err != nil {
+					t.Fatal(err)
+				}
+				loaded, err := odmap.ReadSnapshot(&buf, odmap.KeyCodec[string](), odmap.JSONCodec[[]int]())
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				want, _ := m.MarshalJSON()
+				got, _ := loaded.MarshalJSON()
+				if !bytes.Equal(got, want) {
+					t.Fatalf("got %.100s, want %.100s", got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestSnapshotFile_Backends(t *testing.T) {
+	m := newMap[int, int]()
+	for i := 0; i < 500; i++ {
+		m.Store(i*2, i)
+	}
+	var buf bytes.Buffer
+	if err := odmap.WriteSnapshot(&buf, m, odmap.KeyCodec[int](), odmap.KeyCodec[int]()); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, opts := range backendOptions {
+		t.Run(name, func(t *testing.T) {
+			loaded, err := odmap.ReadSnapshot(bytes.NewReader(buf.Bytes()), odmap.KeyCodec[int](), odmap.KeyCodec[int](), opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the loaded tree takes writes like any other
+			model := odmap.NewRBTree[int, int](cmp.Compare[int])
+			m.Range(func(key, value int) bool {
+				model.Insert(key, value)
+				return true
+			})
+			for i := 0; i < 1000; i += 3 {
+				if i%2 == 0 {
+					loaded.Delete(i)
+					model.Delete(model.FindNode(i))
+				} else {
+					loaded.Store(i, -i)
+					modelPut(model, i, -i)
+				}
+			}
+			// not checkModel, the safety map does not count its keys
+			node := model.First()
+			loaded.Range(func(key, value int) bool {
+				if node == nil || key != node.Key() || value != node.Value() {
+					t.Fatalf("unexpected pair %d=%d", key, value)
+				}
+				node = node.Next()
+				return true
+			})
+			if node != nil {
+				t.Fatalf("missing key %d", node.Key())
+			}
+
+			versions := map[uint64]bool{}
+			loaded.Range(func(key, _ int) bool {
+				_, version, _ := loaded.(odmap.Versioner[int, int]).LoadVersioned(key)
+				if version == 0 || versions[version] {
+					t.Fatalf("LoadVersioned(%d): got version %d again", key, version)
+				}
+				versions[version] = true
+				return true
+			})
+		})
+	}
+}
+
+func TestSnapshotFile_Malformed(t *testing.T) {
+	m := newMap[int, string]()
+	for i := 0; i < 100; i++ {
+		m.Store(i, "value")
+	}
+	for name, compression := range compressions {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := odmap.WriteSnapshot(&buf, m, odmap.KeyCodec[int](), odmap.BytesCodec[string](),
+				odmap.WithCompression(compression)); err != nil {
+				t.Fatal(err)
+			}
+			data := buf.Bytes()
+
+			_, err := odmap.ReadSnapshot(bytes.NewReader(data[:len(data)-3]), odmap.KeyCodec[int](), odmap.BytesCodec[string]())
+			if err == nil {
+				t.Fatal("read a truncated snapshot")
+			}
+			if compression == odmap.CompressionNone && !errors.Is(err, odmap.ErrSnapshotFormat) {
+				t.Fatalf("truncated: got %v, want ErrSnapshotFormat", err)
+			}
+
+			damaged := bytes.Clone(data)
+			damaged[len(damaged)/2] ^= 0x01
+			if _, err = odmap.ReadSnapshot(bytes.NewReader(damaged), odmap.KeyCodec[int](), odmap.BytesCodec[string]()); err == nil {
+				t.Fatal("read a damaged snapshot")
+			}
+		})
+	}
+
+	if _, err := odmap.ReadSnapshot(bytes.NewReader([]byte("not a snapshot")), odmap.KeyCodec[int](), odmap.BytesCodec[string]()); !errors.Is(err, odmap.ErrSnapshotFormat) {
+		t.Fatalf("got %v, want ErrSnapshotFormat", err)
+	}
+}
@@ -0,0 +1,60 @@
+package odmap_test
+
+import "testing"
+
+func TestOrderedMap_Snapshot(t *testing.T) {
+	m := newMap[int, int]()
+	for i := 0; i < 100; i++ {
+		m.Store(i, i)
+	}
+	snap := m.Snapshot()
+	defer snap.Close()
+	if snap.Version() != m.Version() {
+		t.Fatalf("got version %d, want %d", snap.Version(), m.Version())
+	}
+
+	for i := 0; i < 100; i += 2 {
+		m.Delete(i)
+		m.Store(i+1, -1)
+	}
+	m.Store(100, 100)
+
+	n := 0
+	snap.Range(func(key int, value int) bool {
+		if key != n || value != n {
+			t.Fatalf("got %d: %d, want %d: %d", key, value, n, n)
+		}
+		n++
+		return true
+	})
+	if n != 100 {
+		t.Fatalf("got %d pairs, want 100", n)
+	}
+	if v, ok := snap.Load(2); !ok || v != 2 {
+		t.Fatalf("2: got %d, %v", v, ok)
+	}
+	if _, ok := snap.Load(100); ok {
+		t.Fatal("100 is in the snapshot")
+	}
+}
+
+func TestOrderedMap_RangeSnapshot(t *testing.T) {
+	m := newMap[int, int]()
+	for i := 0; i < 100; i++ {
+		m.Store(i, i)
+	}
+
+	n := 0
+	m.RangeSnapshot(func(key int, value int) bool {
+		m.Delete(key)
+		m.Store(key+1000, value)
+		n++
+		return true
+	})
+	if n != 100 {
+		t.Fatalf("got %d pairs, want 100", n)
+	}
+	if m.Contains(0) || !m.Contains(1099) {
+		t.Fatal("writes made while ranging were lost")
+	}
+}
@@ -0,0 +1,189 @@
+package odmap
+
+import (
+	"cmp"
+	"errors"
+)
+
+// ErrConflict is returned by Txn when concurrent writes kept invalidating the transaction
+var ErrConflict = errors.New("odmap: transaction conflict")
+
+// maxTxnAttempts bounds how many times Txn runs a conflicting transaction
+const maxTxnAttempts = 16
+
+// Tx is the view of the map inside a transaction. Reads see a snapshot of the map
+// taken when the transaction started, together with the transaction's own writes.
+type Tx[K cmp.Ordered, V any] interface {
+	Load(key K) (V, bool)
+	Store(key K, value V)
+	Delete(key K)
+	// Range calls fn for every pair in key order
+	Range(fn func(key K, value V) bool)
+	// Scan calls fn for every pair whose key is in [lo, hi), in key order
+	Scan(lo, hi K, fn func(key K, value V) bool)
+}
+
+// txnSource reads the snapshot of a transaction. seq is the version of the pair
+// that was read, it is compared with the current one when committing.
+type txnSource[K cmp.Ordered, V any] interface {
+	get(key K) (value V, seq uint64, ok bool)
+	scan(r interval[K], fn func(key K, value V, seq uint64) bool)
+}
+
+type txnWrite[V any] struct {
+	value  V
+	delete bool
+}
+
+type txnRead[K cmp.Ordered] struct {
+	key K
+	seq uint64
+	ok  bool
+}
+
+type txnScan[K cmp.Ordered] struct {
+	interval interval[K]
+	keys     []K
+	seqs     []uint64
+}
+
+type txn[K cmp.Ordered, V any] struct {
+	compare func(K, K) int
+	source  txnSource[K, V]
+	writes  *RBTree[K, txnWrite[V]]
+	reads   []txnRead[K]
+	scans   []txnScan[K]
+}
+
+func newTxn[K cmp.Ordered, V any](compare func(K, K) int, source txnSource[K, V]) *txn[K, V] {
+	return &txn[K, V]{
+		compare: compare,
+		source:  source,
+		writes:  NewRBTree[K, txnWrite[V]](compare),
+	}
+}
+
+func (t *txn[K, V]) Load(key K) (V, bool) {
+	if e, ok := t.writes.get(key); ok {
+		w := e.Value()
+		if w.delete {
+			return empty[V](), false
+		}
+		return w.value, true
+	}
+
+	v, seq, ok := t.source.get(key)
+	t.reads = append(t.reads, txnRead[K]{key: key, seq: seq, ok: ok})
+	return v, ok
+}
+
+func (t *txn[K, V]) Store(key K, value V) {
+	t.writes.put(key, txnWrite[V]{value: value})
+}
+
+func (t *txn[K, V]) Delete(key K) {
+	t.writes.put(key, txnWrite[V]{delete: true})
+}
+
+func (t *txn[K, V]) Range(fn func(key K, value V) bool) {
+	t.scan(interval[K]{}, fn)
+}
+
+func (t *txn[K, V]) Scan(lo, hi K, fn func(key K, value V) bool) {
+	t.scan(interval[K]{lo: lo, hi: hi, hasLo: true, hasHi: true}, fn)
+}
+
+// scan merges the snapshot with the pending writes of r and records which pairs of
+// the snapshot were observed
+func (t *txn[K, V]) scan(r interval[K], fn func(key K, value V) bool) {
+	pending := t.writes.First()
+	if r.hasLo {
+		pending = t.writes.FindLowerBoundNode(r.lo)
+	}
+
+	// flush emits the pending writes ordered before key, or all of them when key is nil
+	flush := func(key *K) bool {
+		for pending != nil && r.belowHi(t.compare, pending.key) &&
+			(key == nil || t.compare(pending.key, *key) < 0) {
+			k, w := pending.Key(), pending.Value()
+			pending = pending.Next()
+			if !w.delete && !fn(k, w.value) {
+				return false
+			}
+		}
+		return true
+	}
+
+	rec := txnScan[K]{interval: r}
+	stopped := false
+	t.source.scan(r, func(key K, value V, seq uint64) bool {
+		rec.keys = append(rec.keys, key)
+		rec.seqs = append(rec.seqs, seq)
+
+		if !flush(&key) {
+			stopped = true
+			return false
+		}
+		if pending != nil && t.compare(pending.key, key) == 0 {
+			w := pending.Value()
+			pending = pending.Next()
+			if w.delete {
+				return true
+			}
+			value = w.value
+		}
+		if !fn(key, value) {
+			stopped = true
+			return false
+		}
+		return true
+	})
+
+	if stopped {
+		// only the snapshot up to the last key was observed
+		rec.interval.hi, rec.interval.hasHi, rec.interval.closed = rec.keys[len(rec.keys)-1], true, true
+	} else {
+		flush(nil)
+	}
+	t.scans = append(t.scans, rec)
+}
+
+// validate reports whether everything the transaction read is still current. version
+// returns the current version of a key, versions calls fn for the live keys of r in order.
+func (t *txn[K, V]) validate(
+	version func(key K) (uint64, bool),
+	versions func(r interval[K], fn func(key K, seq uint64) bool),
+) bool {
+	for _, r := range t.reads {
+		seq, ok := version(r.key)
+		if ok != r.ok || (ok && seq != r.seq) {
+			return false
+		}
+	}
+
+	for _, s := range t.scans {
+		i, valid := 0, true
+		versions(s.interval, func(key K, seq uint64) bool {
+			if i == len(s.keys) || t.compare(key, s.keys[i]) != 0 || seq != s.seqs[i] {
+				valid = false
+				return false
+			}
+			i++
+			return true
+		})
+		if !valid || i != len(s.keys) {
+			return false
+		}
+	}
+	return true
+}
+
+// batch returns the pending writes in key order
+func (t *txn[K, V]) batch() *Batch[K, V] {
+	b := &Batch[K, V]{ops: make([]batchOp[K, V], 0, t.writes.Size())}
+	for e := t.writes.First(); e != nil; e = e.Next() {
+		w := e.Value()
+		b.ops = append(b.ops, batchOp[K, V]{key: e.Key(), value: w.value, delete: w.delete})
+	}
+	return b
+}
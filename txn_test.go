@@ -0,0 +1,87 @@
+package odmap_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	odmap "github.com/RealFax/order-map"
+)
+
+func TestOrderedMap_Txn(t *testing.T) {
+	m := newMap[int, int]()
+	for i := 0; i < 6; i++ {
+		m.Store(i, i)
+	}
+
+	err := m.Txn(func(tx odmap.Tx[int, int]) error {
+		tx.Store(1, 10)
+		tx.Delete(2)
+		tx.Store(7, 7)
+		if v, ok := tx.Load(1); !ok || v != 10 {
+			t.Fatalf("read own write: got %d, %v", v, ok)
+		}
+		if _, ok := tx.Load(2); ok {
+			t.Fatal("read own delete: 2 is present")
+		}
+		if v, _ := m.Load(1); v != 1 {
+			t.Fatalf("write visible before commit, 1 = %d", v)
+		}
+
+		var keys []int
+		tx.Scan(1, 8, func(key int, value int) bool {
+			keys = append(keys, key)
+			return true
+		})
+		if want := []int{1, 3, 4, 5, 7}; !slices.Equal(keys, want) {
+			t.Fatalf("scan: got %v, want %v", keys, want)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, _ := m.Load(1); v != 10 {
+		t.Fatalf("1 = %d, want 10", v)
+	}
+	if _, ok := m.Load(2); ok {
+		t.Fatal("2 is still present")
+	}
+	if v, _ := m.Load(7); v != 7 {
+		t.Fatalf("7 = %d, want 7", v)
+	}
+}
+
+func TestOrderedMap_TxnAbort(t *testing.T) {
+	m := newMap[string, int]()
+	m.Store("a", 1)
+
+	errAbort := errors.New("abort")
+	err := m.Txn(func(tx odmap.Tx[string, int]) error {
+		tx.Store("a", 2)
+		tx.Store("b", 2)
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("got %v, want %v", err, errAbort)
+	}
+	if v, _ := m.Load("a"); v != 1 {
+		t.Fatalf("aborted write applied, a = %d", v)
+	}
+	if m.Contains("b") {
+		t.Fatal("aborted write applied, b is present")
+	}
+
+	// the error is returned as is even if what fn read was modified meanwhile
+	calls := 0
+	err = m.Txn(func(tx odmap.Tx[string, int]) error {
+		calls++
+		v, _ := tx.Load("a")
+		m.Store("a", v+1)
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) || calls != 1 {
+		t.Fatalf("got %v after %d calls, want %v after one", err, calls, errAbort)
+	}
+}
@@ -0,0 +1,71 @@
+package odmap_test
+
+import "testing"
+
+func TestOrderedMap_LoadVersioned(t *testing.T) {
+	m := newMap[string, []int]()
+	if _, _, ok := m.LoadVersioned("a"); ok {
+		t.Fatal("missing key loaded")
+	}
+
+	m.Store("a", []int{1})
+	_, v1, ok := m.LoadVersioned("a")
+	if !ok || v1 == 0 {
+		t.Fatalf("got version %d, %v", v1, ok)
+	}
+
+	m.Store("a", []int{2})
+	value, v2, _ := m.LoadVersioned("a")
+	if v2 <= v1 || value[0] != 2 {
+		t.Fatalf("got %v at version %d after version %d", value, v2, v1)
+	}
+
+	// a deleted key does not get its version back
+	m.Delete("a")
+	m.Store("a", []int{1})
+	if _, v3, _ := m.LoadVersioned("a"); v3 <= v2 {
+		t.Fatalf("got version %d after version %d", v3, v2)
+	}
+}
+
+func TestOrderedMap_CompareVersionAndSwap(t *testing.T) {
+	m := newMap[string, []int]()
+	m.Store("a", []int{1})
+	_, stale, _ := m.LoadVersioned("a")
+	m.Store("a", []int{1})
+
+	if m.CompareVersionAndSwap("a", stale, []int{3}) {
+		t.Fatal("swapped with a stale version")
+	}
+	_, version, _ := m.LoadVersioned("a")
+	if !m.CompareVersionAndSwap("a", version, []int{3}) {
+		t.Fatal("swap with the current version failed")
+	}
+	if value, _ := m.Load("a"); value[0] != 3 {
+		t.Fatalf("a = %v, want [3]", value)
+	}
+	if m.CompareVersionAndSwap("b", 0, []int{3}) {
+		t.Fatal("swapped a missing key")
+	}
+
+	if m.CompareVersionAndDelete("a", version) {
+		t.Fatal("deleted with a stale version")
+	}
+	_, version, _ = m.LoadVersioned("a")
+	if !m.CompareVersionAndDelete("a", version) || m.Contains("a") {
+		t.Fatal("delete with the current version failed")
+	}
+}
+
+func TestOrderedMap_CompareAndSwapVersion(t *testing.T) {
+	m := newMap[string, string]()
+	m.Store("a", "x")
+	_, before, _ := m.LoadVersioned("a")
+
+	if !m.CompareAndSwap("a", "x", "y") {
+		t.Fatal("CompareAndSwap with the current value failed")
+	}
+	if _, after, _ := m.LoadVersioned("a"); after <= before {
+		t.Fatalf("got version %d after version %d", after, before)
+	}
+}
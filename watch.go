@@ -0,0 +1,537 @@
+package odmap
+
+import (
+	"cmp"
+	"context"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+// EventKind describes what happened to a key
+type EventKind uint8
+
+const (
+	// EventStore is sent when a key is inserted or its value is replaced
+	EventStore EventKind = iota + 1
+	// EventDelete is sent when a key is removed, Value holds the removed value
+	EventDelete
+	// EventSnapshot is sent for every pair in the watched range before live events,
+	// only when the subscription was created with WatchSnapshot
+	EventSnapshot
+	// EventOverflow is the last event of a subscription that fell more events behind
+	// than its buffer holds, the changes that did not fit are lost
+	EventOverflow
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventStore:
+		return "store"
+	case EventDelete:
+		return "delete"
+	case EventSnapshot:
+		return "snapshot"
+	case EventOverflow:
+		return "overflow"
+	default:
+		return "unknown"
+	}
+}
+
+// Event is a single change delivered to a watcher
+type Event[K cmp.Ordered, V any] struct {
+	Kind  EventKind
+	Key   K
+	Value V
+}
+
+// WatchOption configures a subscription created by Watch
+type WatchOption[K cmp.Ordered] func(*watchConfig[K])
+
+type watchConfig[K cmp.Ordered] struct {
+	interval interval[K]
+	snapshot bool
+	buffer   int
+}
+
+// WatchRange limits a subscription to keys in [lo, hi)
+func WatchRange[K cmp.Ordered](lo, hi K) WatchOption[K] {
+	return func(c *watchConfig[K]) {
+		c.interval = interval[K]{lo: lo, hi: hi, hasLo: true, hasHi: true}
+	}
+}
+
+// WatchPrefix limits a subscription to keys starting with prefix.
+//
+// The range is derived from the byte-wise ordering of strings, so it is only
+// meaningful for maps that keep the default comparer.
+func WatchPrefix[K ~string](prefix K) WatchOption[K] {
+	return func(c *watchConfig[K]) {
+		c.interval = interval[K]{lo: prefix, hasLo: true}
+		if hi, ok := prefixEnd(prefix); ok {
+			c.interval.hi, c.interval.hasHi = hi, true
+		}
+	}
+}
+
+// WatchSnapshot makes the subscription emit the current pairs of the watched
+// range, in key order, as EventSnapshot events before any live change
+func WatchSnapshot[K cmp.Ordered]() WatchOption[K] {
+	return func(c *watchConfig[K]) {
+		c.snapshot = true
+	}
+}
+
+// defaultWatchBuffer is the number of events a subscription buffers unless WatchBuffer
+// sets another
+const defaultWatchBuffer = 1 << 16
+
+// WatchBuffer sets the number of events the subscription holds for a callback that is
+// behind. Once they are exceeded, the subscription ends with an EventOverflow.
+// A size below 1 selects the default of 65536.
+func WatchBuffer[K cmp.Ordered](size int) WatchOption[K] {
+	return func(c *watchConfig[K]) {
+		c.buffer = size
+	}
+}
+
+// prefixEnd returns the smallest string greater than every string with the given prefix
+func prefixEnd[K ~string](prefix K) (K, bool) {
+	b := []byte(prefix)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < 0xff {
+			b[i]++
+			return K(b[:i+1]), true
+		}
+	}
+	return "", false
+}
+
+// interval is a key range, lo is always inclusive, hi is exclusive unless closed is set.
+// A missing bound is unbounded.
+type interval[K cmp.Ordered] struct {
+	lo, hi       K
+	hasLo, hasHi bool
+	closed       bool
+}
+
+func pointInterval[K cmp.Ordered](key K) interval[K] {
+	return interval[K]{lo: key, hi: key, hasLo: true, hasHi: true, closed: true}
+}
+
+// aboveLo reports whether key is not before the lower bound
+func (r interval[K]) aboveLo(compare func(K, K) int, key K) bool {
+	return !r.hasLo || compare(key, r.lo) >= 0
+}
+
+// belowHi reports whether key is not after the upper bound
+func (r interval[K]) belowHi(compare func(K, K) int, key K) bool {
+	if !r.hasHi {
+		return true
+	}
+	if r.closed {
+		return compare(key, r.hi) <= 0
+	}
+	return compare(key, r.hi) < 0
+}
+
+func (r interval[K]) contains(compare func(K, K) int, key K) bool {
+	return r.aboveLo(compare, key) && r.belowHi(compare, key)
+}
+
+// compareLo orders intervals by their lower bound, unbounded first
+func (r interval[K]) compareLo(compare func(K, K) int, o interval[K]) int {
+	switch {
+	case !r.hasLo && !o.hasLo:
+		return 0
+	case !r.hasLo:
+		return -1
+	case !o.hasLo:
+		return 1
+	}
+	return compare(r.lo, o.lo)
+}
+
+// compareHi orders intervals by their upper bound, unbounded last
+func (r interval[K]) compareHi(compare func(K, K) int, o interval[K]) int {
+	switch {
+	case !r.hasHi && !o.hasHi:
+		return 0
+	case !r.hasHi:
+		return 1
+	case !o.hasHi:
+		return -1
+	}
+	if c := compare(r.hi, o.hi); c != 0 {
+		return c
+	}
+	switch {
+	case r.closed == o.closed:
+		return 0
+	case r.closed:
+		return 1
+	default:
+		return -1
+	}
+}
+
+type subscription[K cmp.Ordered, V any] struct {
+	id       uint64
+	interval interval[K]
+	deliver  func(Event[K, V])
+}
+
+// watchNode is a node of a treap ordered by interval lower bound and augmented
+// with the greatest upper bound of its subtree, which turns it into an interval tree
+type watchNode[K cmp.Ordered, V any] struct {
+	sub         *subscription[K, V]
+	priority    uint64
+	max         interval[K]
+	left, right *watchNode[K, V]
+}
+
+// watchers holds the subscriptions of a map and dispatches changes to them.
+// Matching a key costs O(log w + m) for w subscriptions of which m match.
+//
+// A map notifies its writes in the order it applies them. hold, when set, runs fn
+// with the writers of the map held off, so that a write in progress is not notified
+// to a subscription behind the ones following it.
+type watchers[K cmp.Ordered, V any] struct {
+	compare func(K, K) int
+	hold    func(fn func())
+
+	mu     sync.RWMutex
+	root   *watchNode[K, V]
+	nextID uint64
+	count  atomic.Int64
+}
+
+// watch registers fn for the configured interval. When a snapshot is requested,
+// scan is used to read the current pairs of the interval in key order.
+func (w *watchers[K, V]) watch(
+	fn func(Event[K, V]),
+	opts []WatchOption[K],
+	scan func(interval[K], func(K, V) bool),
+) (cancel func()) {
+	c := watchConfig[K]{buffer: defaultWatchBuffer}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	if c.buffer < 1 {
+		c.buffer = defaultWatchBuffer
+	}
+
+	q := newWatchQueue[K, V](c.buffer)
+	sub := w.subscribe(c.interval, q.push)
+
+	if c.snapshot {
+		// the subscription is already live, so changes made while scanning are
+		// queued behind the snapshot instead of being lost
+		snapshot := make([]Event[K, V], 0, 64)
+		scan(c.interval, func(key K, value V) bool {
+			snapshot = append(snapshot, Event[K, V]{Kind: EventSnapshot, Key: key, Value: value})
+			return true
+		})
+		q.prepend(snapshot)
+	}
+
+	var once sync.Once
+	cancel = func() {
+		once.Do(func() {
+			w.unsubscribe(sub)
+			q.close()
+		})
+	}
+	go q.run(fn, cancel)
+	return cancel
+}
+
+// waitFor blocks until cond holds for the value of key. load reads the current value
+// of the map, it is checked again after subscribing so no change can be missed.
+func (w *watchers[K, V]) waitFor(
+	ctx context.Context,
+	key K,
+	cond func(V) bool,
+	load func(K) (V, bool),
+) (V, error) {
+	if v, ok := load(key); ok && cond(v) {
+		return v, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return empty[V](), err
+	}
+
+	ready := make(chan V, 1)
+	sub := w.subscribe(pointInterval(key), func(ev Event[K, V]) {
+		if ev.Kind != EventStore || !cond(ev.Value) {
+			return
+		}
+		select {
+		case ready <- ev.Value:
+		default:
+		}
+	})
+	defer w.unsubscribe(sub)
+
+	if v, ok := load(key); ok && cond(v) {
+		return v, nil
+	}
+
+	select {
+	case v := <-ready:
+		return v, nil
+	case <-ctx.Done():
+		return empty[V](), ctx.Err()
+	}
+}
+
+func (w *watchers[K, V]) subscribe(r interval[K], deliver func(Event[K, V])) (sub *subscription[K, V]) {
+	if w.hold != nil {
+		w.hold(func() {
+			sub = w.add(r, deliver)
+		})
+		return sub
+	}
+	return w.add(r, deliver)
+}
+
+func (w *watchers[K, V]) add(r interval[K], deliver func(Event[K, V])) *subscription[K, V] {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.nextID++
+	sub := &subscription[K, V]{id: w.nextID, interval: r, deliver: deliver}
+	w.root = w.insert(w.root, &watchNode[K, V]{sub: sub, priority: rand.Uint64(), max: r})
+	w.count.Add(1)
+	return sub
+}
+
+func (w *watchers[K, V]) unsubscribe(sub *subscription[K, V]) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var removed bool
+	w.root, removed = w.remove(w.root, sub)
+	if removed {
+		w.count.Add(-1)
+	}
+}
+
+// notify delivers a change of key to every subscription whose interval contains it,
+// it must be called before the next write of the map is applied
+func (w *watchers[K, V]) notify(kind EventKind, key K, value V) {
+	if w.count.Load() == 0 {
+		return
+	}
+
+	w.mu.RLock()
+	var matched []*subscription[K, V]
+	w.stab(w.root, key, &matched)
+	w.mu.RUnlock()
+
+	ev := Event[K, V]{Kind: kind, Key: key, Value: value}
+	for _, sub := range matched {
+		sub.deliver(ev)
+	}
+}
+
+// watched reports whether a subscription contains key
+func (w *watchers[K, V]) watched(key K) bool {
+	if w.count.Load() == 0 {
+		return false
+	}
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	var matched []*subscription[K, V]
+	w.stab(w.root, key, &matched)
+	return len(matched) != 0
+}
+
+func (w *watchers[K, V]) stab(n *watchNode[K, V], key K, out *[]*subscription[K, V]) {
+	for n != nil && n.max.belowHi(w.compare, key) {
+		w.stab(n.left, key, out)
+		if !n.sub.interval.aboveLo(w.compare, key) {
+			// every interval on the right starts even later
+			return
+		}
+		if n.sub.interval.belowHi(w.compare, key) {
+			*out = append(*out, n.sub)
+		}
+		n = n.right
+	}
+}
+
+func (w *watchers[K, V]) less(a, b *subscription[K, V]) bool {
+	if c := a.interval.compareLo(w.compare, b.interval); c != 0 {
+		return c < 0
+	}
+	return a.id < b.id
+}
+
+func (w *watchers[K, V]) update(n *watchNode[K, V]) {
+	n.max = n.sub.interval
+	if n.left != nil && n.left.max.compareHi(w.compare, n.max) > 0 {
+		n.max = n.left.max
+	}
+	if n.right != nil && n.right.max.compareHi(w.compare, n.max) > 0 {
+		n.max = n.right.max
+	}
+}
+
+func (w *watchers[K, V]) rotateLeft(n *watchNode[K, V]) *watchNode[K, V] {
+	r := n.right
+	n.right, r.left = r.left, n
+	w.update(n)
+	w.update(r)
+	return r
+}
+
+func (w *watchers[K, V]) rotateRight(n *watchNode[K, V]) *watchNode[K, V] {
+	l := n.left
+	n.left, l.right = l.right, n
+	w.update(n)
+	w.update(l)
+	return l
+}
+
+func (w *watchers[K, V]) insert(n, x *watchNode[K, V]) *watchNode[K, V] {
+	if n == nil {
+		return x
+	}
+	if w.less(x.sub, n.sub) {
+		n.left = w.insert(n.left, x)
+		if n.left.priority > n.priority {
+			return w.rotateRight(n)
+		}
+	} else {
+		n.right = w.insert(n.right, x)
+		if n.right.priority > n.priority {
+			return w.rotateLeft(n)
+		}
+	}
+	w.update(n)
+	return n
+}
+
+func (w *watchers[K, V]) remove(n *watchNode[K, V], sub *subscription[K, V]) (*watchNode[K, V], bool) {
+	if n == nil {
+		return nil, false
+	}
+
+	var removed bool
+	switch {
+	case n.sub == sub:
+		return w.merge(n.left, n.right), true
+	case w.less(sub, n.sub):
+		n.left, removed = w.remove(n.left, sub)
+	default:
+		n.right, removed = w.remove(n.right, sub)
+	}
+	w.update(n)
+	return n, removed
+}
+
+// merge joins two treaps where every node of l orders before every node of r
+func (w *watchers[K, V]) merge(l, r *watchNode[K, V]) *watchNode[K, V] {
+	switch {
+	case l == nil:
+		return r
+	case r == nil:
+		return l
+	case l.priority > r.priority:
+		l.right = w.merge(l.right, r)
+		w.update(l)
+		return l
+	default:
+		r.left = w.merge(l, r.left)
+		w.update(r)
+		return r
+	}
+}
+
+// watchQueue buffers the events of one subscription so that writers never
+// wait for a slow callback. It holds up to limit live events besides the ones
+// being delivered, the snapshot does not count.
+type watchQueue[K cmp.Ordered, V any] struct {
+	mu     sync.Mutex
+	cond   sync.Cond
+	events []Event[K, V]
+	live   int
+	limit  int
+	// overflowed is set once an EventOverflow is queued, nothing is queued after it
+	overflowed bool
+	closed     bool
+}
+
+func newWatchQueue[K cmp.Ordered, V any](limit int) *watchQueue[K, V] {
+	q := &watchQueue[K, V]{limit: limit}
+	q.cond.L = &q.mu
+	return q
+}
+
+func (q *watchQueue[K, V]) push(ev Event[K, V]) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed || q.overflowed {
+		return
+	}
+
+	if q.live == q.limit {
+		q.overflowed = true
+		ev = Event[K, V]{Kind: EventOverflow}
+	}
+	q.events = append(q.events, ev)
+	q.live++
+	q.cond.Signal()
+}
+
+func (q *watchQueue[K, V]) prepend(events []Event[K, V]) {
+	q.mu.Lock()
+	q.events = append(events, q.events...)
+	q.mu.Unlock()
+}
+
+func (q *watchQueue[K, V]) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.events = nil
+	q.cond.Signal()
+	q.mu.Unlock()
+}
+
+// run delivers the events to fn until the queue is closed, or calls cancel once the
+// overflow is delivered
+func (q *watchQueue[K, V]) run(fn func(Event[K, V]), cancel func()) {
+	for {
+		q.mu.Lock()
+		for len(q.events) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if q.closed {
+			q.mu.Unlock()
+			return
+		}
+		events := q.events
+		q.events, q.live = nil, 0
+		q.mu.Unlock()
+
+		for _, ev := range events {
+			if q.isClosed() {
+				return
+			}
+			fn(ev)
+			if ev.Kind == EventOverflow {
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+func (q *watchQueue[K, V]) isClosed() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.closed
+}
@@ -0,0 +1,204 @@
+package odmap_test
+
+import (
+	"cmp"
+	"context"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	odmap "github.com/RealFax/order-map"
+)
+
+type eventLog[K cmp.Ordered, V comparable] struct {
+	mu     sync.Mutex
+	events []odmap.Event[K, V]
+}
+
+func (l *eventLog[K, V]) add(ev odmap.Event[K, V]) {
+	l.mu.Lock()
+	l.events = append(l.events, ev)
+	l.mu.Unlock()
+}
+
+// wait returns the logged events once n of them arrived
+func (l *eventLog[K, V]) wait(t *testing.T, n int) []odmap.Event[K, V] {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		l.mu.Lock()
+		events := append([]odmap.Event[K, V](nil), l.events...)
+		l.mu.Unlock()
+		if len(events) >= n {
+			return events
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d events, want %d", len(events), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestOrderedMap_WatchRange(t *testing.T) {
+	m := newMap[int, string]()
+	var log eventLog[int, string]
+	cancel := m.Watch(log.add, odmap.WatchRange(10, 20))
+	defer cancel()
+
+	m.Store(5, "out")
+	m.Store(10, "a")
+	m.Store(19, "b")
+	m.Store(20, "out")
+	m.Delete(10)
+
+	events := log.wait(t, 3)
+	want := []odmap.Event[int, string]{
+		{Kind: odmap.EventStore, Key: 10, Value: "a"},
+		{Kind: odmap.EventStore, Key: 19, Value: "b"},
+		{Kind: odmap.EventDelete, Key: 10, Value: "a"},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %v, want %v", events, want)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Fatalf("event %d: got %v, want %v", i, events[i], want[i])
+		}
+	}
+}
+
+func TestOrderedMap_WatchPrefixSnapshot(t *testing.T) {
+	m := newMap[string, int]()
+	m.Store("tenant/41/a", 0)
+	m.Store("tenant/42/b", 2)
+	m.Store("tenant/42/a", 1)
+	m.Store("tenant/43/a", 3)
+
+	var log eventLog[string, int]
+	cancel := m.Watch(log.add, odmap.WatchPrefix("tenant/42/"), odmap.WatchSnapshot[string]())
+	defer cancel()
+
+	m.Store("tenant/42/c", 4)
+	m.Store("tenant/420", 5)
+
+	events := log.wait(t, 3)
+	want := []odmap.Event[string, int]{
+		{Kind: odmap.EventSnapshot, Key: "tenant/42/a", Value: 1},
+		{Kind: odmap.EventSnapshot, Key: "tenant/42/b", Value: 2},
+		{Kind: odmap.EventStore, Key: "tenant/42/c", Value: 4},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %v, want %v", events, want)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Fatalf("event %d: got %v, want %v", i, events[i], want[i])
+		}
+	}
+}
+
+func TestOrderedMap_WatchMatching(t *testing.T) {
+	type sub struct {
+		lo, hi int
+		all    bool
+		log    eventLog[int, int]
+	}
+
+	m := newMap[int, int]()
+	r := rand.New(rand.NewSource(1))
+	subs := make([]*sub, 200)
+	for i := range subs {
+		s := &sub{lo: r.Intn(1000)}
+		s.hi = s.lo + r.Intn(100)
+		s.all = i%50 == 0
+		subs[i] = s
+
+		var cancel func()
+		if s.all {
+			cancel = m.Watch(s.log.add)
+		} else {
+			cancel = m.Watch(s.log.add, odmap.WatchRange(s.lo, s.hi))
+		}
+		defer cancel()
+	}
+
+	keys := make([]int, 500)
+	for i := range keys {
+		keys[i] = r.Intn(1100)
+		m.Store(keys[i], i)
+	}
+
+	for _, s := range subs {
+		want := 0
+		for _, key := range keys {
+			if s.all || (key >= s.lo && key < s.hi) {
+				want++
+			}
+		}
+		if events := s.log.wait(t, want); len(events) != want {
+			t.Fatalf("[%d, %d): got %d events, want %d", s.lo, s.hi, len(events), want)
+		}
+	}
+}
+
+func TestOrderedMap_WatchCancel(t *testing.T) {
+	m := newMap[int, int]()
+	var log eventLog[int, int]
+	cancel := m.Watch(log.add)
+	m.Store(1, 1)
+	log.wait(t, 1)
+
+	cancel()
+	cancel()
+	m.Store(2, 2)
+	time.Sleep(10 * time.Millisecond)
+	if events := log.wait(t, 1); len(events) != 1 {
+		t.Fatalf("got %v after cancel", events)
+	}
+}
+
+func TestOrderedMap_WatchOverflow(t *testing.T) {
+	m := newMap[int, int]()
+	var log eventLog[int, int]
+	release := make(chan struct{})
+	cancel := m.Watch(func(ev odmap.Event[int, int]) {
+		<-release
+		log.add(ev)
+	}, odmap.WatchBuffer[int](4))
+	defer cancel()
+
+	for i := 0; i < 20; i++ {
+		m.Store(i, i)
+	}
+	close(release)
+
+	// the subscription ends with the overflow and nothing comes after it
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		events := log.wait(t, 1)
+		if last := events[len(events)-1]; last.Kind == odmap.EventOverflow {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %v, want an overflow", events)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	m.Store(100, 100)
+	time.Sleep(10 * time.Millisecond)
+	events := log.wait(t, 1)
+	if n := len(events); n > 20 || events[n-1].Kind != odmap.EventOverflow {
+		t.Fatalf("got %v after the overflow", events)
+	}
+}
+
+func TestOrderedMap_WaitFor(t *testing.T) {
+	m := newMap[string, int]()
+	m.Store("ready", 3)
+
+	v, err := m.WaitFor(context.Background(), "ready", func(v int) bool { return v > 2 })
+	if err != nil || v != 3 {
+		t.Fatalf("got %d, %v, want 3", v, err)
+	}
+}